@@ -2,23 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/Khovanskiy5/yopass/internal/audit"
 	"github.com/Khovanskiy5/yopass/internal/config"
 	"github.com/Khovanskiy5/yopass/internal/repository"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/Khovanskiy5/yopass/internal/secret/handler"
 	"github.com/Khovanskiy5/yopass/internal/secret/service"
 	"github.com/Khovanskiy5/yopass/internal/server"
 	"github.com/Khovanskiy5/yopass/internal/utils"
+	"github.com/Khovanskiy5/yopass/internal/webhook"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
+// databaseRetryInterval is the pause between two pings while waiting for the
+// database on startup.
+const databaseRetryInterval = time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		os.Exit(verifyAuditLog(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// 1. Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -39,34 +52,76 @@ func main() {
 
 func run(ctx context.Context, cfg *config.Config, logger *zap.Logger, registry *prometheus.Registry) error {
 	// 3. Setup repository
-	repo, err := repository.NewRepository(cfg, logger)
+	repo, err := repository.NewRepository(cfg, logger, registry)
 	if err != nil {
 		return err
 	}
+	if closer, ok := repo.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if cfg.WaitForDatabase > 0 {
+		if err := waitForDatabase(ctx, repo, cfg.WaitForDatabase, logger); err != nil {
+			return err
+		}
+	}
 
 	// 4. Setup business logic
+	var notifier domain.Notifier
+	if len(cfg.WebhookAllowlist) > 0 {
+		n, err := webhook.New(webhook.Options{
+			Allowlist:   cfg.WebhookAllowlist,
+			Secret:      cfg.WebhookSecret,
+			Path:        cfg.WebhookQueue,
+			MaxAttempts: cfg.WebhookMaxAttempts,
+			Repository:  repo,
+			Logger:      logger,
+			Registerer:  registry,
+		})
+		if err != nil {
+			return err
+		}
+		defer n.Close()
+		notifier = n
+	}
+	var auditor domain.Auditor
+	if len(cfg.AuditSinks) > 0 {
+		a, err := audit.New(audit.Options{
+			Sinks:       cfg.AuditSinks,
+			TruncateIPs: cfg.AuditTruncateIPs,
+			Logger:      logger,
+		})
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		auditor = a
+	}
 	var allowedExpirationsI32 []int32
 	for _, e := range cfg.AllowedExpirations {
 		allowedExpirationsI32 = append(allowedExpirationsI32, int32(e))
 	}
-	secretService := service.NewSecretService(
-		repo,
-		cfg.MaxLength,
-		cfg.ForceOneTimeSecrets,
-		allowedExpirationsI32,
-	)
+	secretService := service.NewSecretService(repo, service.Options{
+		MaxLength:           cfg.MaxLength,
+		ForceOneTimeSecrets: cfg.ForceOneTimeSecrets,
+		AllowedExpirations:  allowedExpirationsI32,
+		BinaryPayloads:      cfg.BinaryPayloads,
+		MaxViews:            cfg.MaxViews,
+		Notifier:            notifier,
+		Auditor:             auditor,
+	})
 
 	// 5. Setup handlers
 	secretHandler := handler.NewSecretHandler(secretService, logger)
 	configHandler := handler.NewConfigHandler(cfg, logger)
+	healthHandler := handler.NewHealthHandler(repo, logger)
 
 	// 6. Setup router
-	router := server.NewRouter(cfg, secretHandler, configHandler, registry)
+	router := server.NewRouter(cfg, secretHandler, configHandler, healthHandler, registry)
 
 	// 7. Start servers
 	srvManager := server.NewServer(cfg, logger, registry)
 	apiSrv := srvManager.Start(router)
-	metricsSrv := srvManager.StartMetrics()
+	metricsSrv := srvManager.StartMetrics(healthHandler)
 
 	// 8. Wait for termination signal or context cancellation
 	<-ctx.Done()
@@ -79,3 +134,26 @@ func run(ctx context.Context, cfg *config.Config, logger *zap.Logger, registry *
 	logger.Info("Server gracefully stopped")
 	return nil
 }
+
+// waitForDatabase pings repo until it answers or timeout has passed, so the
+// server doesn't start serving while its database is still coming up.
+func waitForDatabase(ctx context.Context, repo domain.Repository, timeout time.Duration, logger *zap.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(databaseRetryInterval)
+	defer ticker.Stop()
+	for {
+		err := repo.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		logger.Warn("Database is not reachable yet, retrying", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable within %s: %w", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"testing"
 	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/Khovanskiy5/yopass/internal/utils"
+	"github.com/spf13/viper"
+	"go.uber.org/zap/zaptest"
 )
 
 func TestRun(t *testing.T) {
+	settings := viper.New()
+	settings.Set("memcached", []string{"localhost:11211"})
 	cfg := &config.Config{
 		Address:            "127.0.0.1",
 		Port:               13371, // Use a specific port for testing
 		Database:           "memcached",
-		Memcached:          "localhost:11211",
+		Settings:           settings,
 		MaxLength:          1000,
 		AllowedExpirations: []int{3600},
 		AssetPath:          "../../public", // dummy path
@@ -58,3 +64,34 @@ func TestRun(t *testing.T) {
 		t.Error("Server did not shut down in time")
 	}
 }
+
+// flakyRepository becomes reachable after a number of failed pings.
+type flakyRepository struct {
+	domain.Repository
+	failures int
+}
+
+func (r *flakyRepository) Ping(ctx context.Context) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+
+	repo := &flakyRepository{failures: 1}
+	if err := waitForDatabase(context.Background(), repo, 5*time.Second, logger); err != nil {
+		t.Fatalf("waitForDatabase() error = %v", err)
+	}
+	if repo.failures != 0 {
+		t.Errorf("expected database to be pinged until it answered")
+	}
+
+	repo = &flakyRepository{failures: 100}
+	if err := waitForDatabase(context.Background(), repo, 100*time.Millisecond, logger); err == nil {
+		t.Fatal("expected error for an unreachable database")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Khovanskiy5/yopass/internal/audit"
+	"github.com/spf13/pflag"
+)
+
+// verifyAuditLog runs the audit-verify subcommand with args, checking the
+// audit log files given in order. It returns the exit code.
+func verifyAuditLog(args []string, stdout, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("audit-verify", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	v := &audit.Verifier{}
+	flags.BoolVar(&v.AllowRestarts, "allow-restarts", false, "accept new chains started by servers without a file sink")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: yopass-server audit-verify [--allow-restarts] FILE...")
+		fmt.Fprintln(stderr, "Verifies the hash chain of audit log files, oldest first.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	for _, path := range flags.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		err = v.Verify(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stdout, "OK: %d entries, last entry %d with hash %s\n", v.Entries, v.Seq, v.Hash)
+	if v.First != "" {
+		fmt.Fprintf(stdout, "The log continues a chain from hash %s\n", v.First)
+	}
+	if v.Restarts > 0 {
+		fmt.Fprintf(stdout, "New chains were started %d times\n", v.Restarts)
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Khovanskiy5/yopass/internal/audit"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := audit.New(audit.Options{Sinks: []string{"file:" + path}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a.Record(context.Background(), domain.Event{Type: domain.EventCreated, Key: "a"})
+	a.Record(context.Background(), domain.Event{Type: domain.EventDeleted, Key: "a"})
+	a.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := verifyAuditLog([]string{path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "OK: 2 entries") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(data, []byte(`"key":"a"`), []byte(`"key":"b"`), 1), 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := verifyAuditLog([]string{path}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1 for a tampered log, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "line 1: audit log chain broken: hash mismatch") {
+		t.Errorf("unexpected error output %q", stderr.String())
+	}
+
+	if code := verifyAuditLog(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 without files, got %d", code)
+	}
+}
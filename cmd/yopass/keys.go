@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// gpgExport returns the public key with fingerprint from the GnuPG
+// keyring, or nothing if there is none.
+var gpgExport = func(fingerprint string) ([]byte, error) {
+	out, err := exec.Command("gpg", "--batch", "--export", fingerprint).Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg --export failed: %w", err)
+	}
+	return out, nil
+}
+
+// recipients returns the public keys given with --recipient.
+func recipients() ([]*openpgp.Entity, error) {
+	var entities []*openpgp.Entity
+	for _, r := range viper.GetStringSlice("recipient") {
+		e, err := readPublicKey(r)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recipient %s: %w", r, err)
+		}
+		entities = append(entities, e)
+	}
+	return entities, nil
+}
+
+// readPublicKey reads the public key in the file s or, if s is the
+// fingerprint of a key rather than a file, exports it from the GnuPG
+// keyring.
+func readPublicKey(s string) (*openpgp.Entity, error) {
+	b, err := os.ReadFile(s)
+	fingerprint, ok := parseFingerprint(s)
+	if errors.Is(err, os.ErrNotExist) && ok {
+		if b, err = gpgExport(fingerprint); err == nil && len(b) == 0 {
+			err = fmt.Errorf("no key with fingerprint %s in the GnuPG keyring", fingerprint)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, err := crypto.ReadPublicKey(string(b))
+	if err != nil {
+		return nil, err
+	}
+	if ok && !hasFingerprint(e, fingerprint) {
+		return nil, fmt.Errorf("GnuPG returned a key without fingerprint %s", fingerprint)
+	}
+	return e, nil
+}
+
+// parseFingerprint returns s as an upper case hex fingerprint, ignoring
+// spaces and a 0x prefix, if it is one.
+func parseFingerprint(s string) (string, bool) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	s = strings.TrimPrefix(s, "0X")
+	if len(s) != 40 || strings.Trim(s, "0123456789ABCDEF") != "" {
+		return "", false
+	}
+	return s, true
+}
+
+// hasFingerprint tells whether the primary key or a subkey of e has
+// fingerprint.
+func hasFingerprint(e *openpgp.Entity, fingerprint string) bool {
+	if fmt.Sprintf("%X", e.PrimaryKey.Fingerprint) == fingerprint {
+		return true
+	}
+	for _, subkey := range e.Subkeys {
+		if fmt.Sprintf("%X", subkey.PublicKey.Fingerprint) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// privateKeys returns the keyring given with --private-key, or nil if
+// there is none. Passphrases of its keys are asked for on the terminal.
+func privateKeys() (*crypto.Keyring, error) {
+	file := viper.GetString("private-key")
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read private key: %w", err)
+	}
+	keyring, err := crypto.ReadKeyring(b, askPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read private key: %w", err)
+	}
+	return keyring, nil
+}
+
+// askPassphrase asks for the passphrase of key on the terminal without
+// echoing it.
+func askPassphrase(key openpgp.Key) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("the private key is protected by a passphrase, which can only be entered on a terminal")
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for key %X: ", key.PublicKey.Fingerprint)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
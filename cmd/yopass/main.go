@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/client"
 	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
@@ -35,9 +36,42 @@ Examples:
       # Share secret multiple time a whole day
       cat secret-notes.md | yopass --expiration=1d --one-time=false
 
+      # Share secret to be viewed at most three times
+      printf 'secret message' | yopass --max-views=3
+
+      # Get notified when the secret is viewed or expires unread
+      printf 'secret message' | yopass --notify-url=https://hooks.example.com/yopass
+
+      # Encrypt secret to the public keys of its recipients, by key file or
+      # by fingerprint from the GnuPG keyring
+      printf 'secret message' | yopass --recipient=alice.asc --recipient=8B02...2996
+
       # Decrypt secret to stdout
       yopass --decrypt https://yopass.se/#/...
 
+      # Decrypt secret encrypted to your public key
+      yopass --decrypt https://yopass.se/#/... --private-key=private.asc
+
+      # Check whether a secret has been viewed, with the management token
+      # printed when it was shared
+      yopass --status https://yopass.se/#/... --token=...
+
+      # Keep an unviewed secret for a whole day from now
+      yopass --extend https://yopass.se/#/... --token=... --expiration=1d
+
+      # Delete a secret before it is viewed
+      yopass --delete https://yopass.se/#/... --token=...
+
+      # Ask someone for a secret with a request link, printing the request
+      # token, optionally encrypted to your public key
+      yopass request --expiration=1d [--public-key=key.asc]
+
+      # Send the secret asked for by a request link
+      printf 'secret message' | yopass fulfil https://yopass.se/#/r/...
+
+      # Get the secret sent for a request
+      yopass request https://yopass.se/#/r/... --token=...
+
 Website: %s
 `
 
@@ -73,10 +107,19 @@ func init() {
 	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
 	pflag.String("api", viper.GetString("api"), "Yopass API server location")
 	pflag.String("decrypt", viper.GetString("decrypt"), "Decrypt secret URL")
+	pflag.String("delete", viper.GetString("delete"), "Delete secret URL, requires --token")
 	pflag.String("expiration", viper.GetString("expiration"), "Duration after which secret will be deleted [1h, 1d, 1w]")
+	pflag.String("extend", viper.GetString("extend"), "Extend secret URL to expire after --expiration from now, requires --token")
 	pflag.String("file", viper.GetString("file"), "Read secret from file instead of stdin")
 	pflag.String("key", viper.GetString("key"), "Manual encryption/decryption key")
 	pflag.Bool("one-time", viper.GetBool("one-time"), "One-time download")
+	pflag.Int("max-views", viper.GetInt("max-views"), "Number of times the secret can be viewed, overrides --one-time")
+	pflag.String("notify-url", viper.GetString("notify-url"), "URL notified when the secret is viewed, deleted or expires unread")
+	pflag.String("private-key", viper.GetString("private-key"), "Private key file to decrypt secrets encrypted to its public key with")
+	pflag.String("public-key", viper.GetString("public-key"), "Public key file or fingerprint to have the requested secret encrypted to")
+	pflag.StringArray("recipient", viper.GetStringSlice("recipient"), "Public key file or fingerprint to encrypt to, repeatable, combined with --key if set")
+	pflag.String("status", viper.GetString("status"), "Show whether secret URL has been viewed, requires --token")
+	pflag.String("token", viper.GetString("token"), "Management token of the secret to check, extend or delete, or token of the request to get the secret for")
 	pflag.String("url", viper.GetString("url"), "Yopass public URL")
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
 		_, err := fmt.Fprintln(os.Stderr, "Unable to bind flags:", err)
@@ -93,9 +136,22 @@ func main() {
 	}
 
 	var err error
-	if viper.IsSet("decrypt") {
+	switch {
+	case pflag.Arg(0) == "request":
+		err = request(pflag.Args()[1:], os.Stdout)
+	case pflag.Arg(0) == "fulfil":
+		err = fulfil(pflag.Args()[1:], os.Stdin, os.Stdout)
+	case pflag.NArg() > 0:
+		err = fmt.Errorf("Unknown command %q, expected request or fulfil", pflag.Arg(0))
+	case viper.IsSet("decrypt"):
 		err = decrypt(os.Stdout)
-	} else {
+	case viper.IsSet("status"):
+		err = status(os.Stdout)
+	case viper.IsSet("extend"):
+		err = extend(os.Stdout)
+	case viper.IsSet("delete"):
+		err = remove()
+	default:
 		err = encryptStdinOrFile(os.Stdin, os.Stdout)
 	}
 
@@ -118,9 +174,13 @@ func decrypt(out io.Writer) error {
 		return fmt.Errorf("Invalid yopass decrypt URL: %w", err)
 	}
 
+	keyring, err := privateKeys()
+	if err != nil {
+		return err
+	}
 	if keyOpt || key == "" {
-		if !viper.IsSet("key") {
-			return fmt.Errorf("Manual decryption key required, set --key")
+		if !viper.IsSet("key") && keyring == nil {
+			return fmt.Errorf("Manual decryption key required, set --key or --private-key")
 		}
 		key = viper.GetString("key")
 	}
@@ -130,7 +190,7 @@ func decrypt(out io.Writer) error {
 		return fmt.Errorf("Failed to fetch secret: %w", err)
 	}
 
-	pt, _, err := crypto.Decrypt(strings.NewReader(msg), key)
+	pt, _, err := crypto.Decrypt(strings.NewReader(msg), key, keyring)
 	if err != nil {
 		return fmt.Errorf("Failed to decrypt secret: %w", err)
 	}
@@ -142,6 +202,79 @@ func decrypt(out io.Writer) error {
 	return err
 }
 
+func status(out io.Writer) error {
+	id, token, err := managedSecret("status")
+	if err != nil {
+		return err
+	}
+	s, err := client.Info(viper.GetString("api"), id, token)
+	if err != nil {
+		return fmt.Errorf("Failed to get secret status: %w", err)
+	}
+	return printStatus(out, s)
+}
+
+func extend(out io.Writer) error {
+	exp := expiration(viper.GetString("expiration"))
+	if exp == 0 {
+		return fmt.Errorf("Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)")
+	}
+	id, token, err := managedSecret("extend")
+	if err != nil {
+		return err
+	}
+	s, err := client.Extend(viper.GetString("api"), id, token, exp)
+	if err != nil {
+		return fmt.Errorf("Failed to extend secret: %w", err)
+	}
+	return printStatus(out, s)
+}
+
+func remove() error {
+	id, token, err := managedSecret("delete")
+	if err != nil {
+		return err
+	}
+	if err := client.Delete(viper.GetString("api"), id, token); err != nil {
+		return fmt.Errorf("Failed to delete secret: %w", err)
+	}
+	return nil
+}
+
+// managedSecret returns the ID of the secret URL given in flag and the
+// management token to manage it with.
+func managedSecret(flag string) (id, token string, err error) {
+	if !strings.HasPrefix(viper.GetString(flag), viper.GetString("url")) {
+		return "", "", fmt.Errorf("Unconfigured yopass secret URL, set --api and --url")
+	}
+	if id, _, _, _, err = utils.ParseURL(viper.GetString(flag)); err != nil {
+		return "", "", fmt.Errorf("Invalid yopass secret URL: %w", err)
+	}
+	if viper.GetString("token") == "" {
+		return "", "", fmt.Errorf("Management token required, set --token")
+	}
+	return id, viper.GetString("token"), nil
+}
+
+func printStatus(out io.Writer, s client.Status) error {
+	viewed := "no"
+	if s.Viewed {
+		viewed = "yes"
+		if !s.ViewedAt.IsZero() {
+			viewed += ", at " + s.ViewedAt.Format(time.RFC3339)
+		}
+	}
+	lines := []string{"Viewed: " + viewed}
+	if s.MaxViews > 0 {
+		lines = append(lines, fmt.Sprintf("Remaining views: %d of %d", s.RemainingViews, s.MaxViews))
+	}
+	if !s.ExpiresAt.IsZero() {
+		lines = append(lines, "Expires: "+s.ExpiresAt.Format(time.RFC3339))
+	}
+	_, err := fmt.Fprintln(out, strings.Join(lines, "\n"))
+	return err
+}
+
 func encryptStdinOrFile(in *os.File, out io.Writer) error {
 	if viper.IsSet("file") {
 		return encryptFileByName(viper.GetString("file"), out)
@@ -174,28 +307,49 @@ func encrypt(in io.ReadCloser, out io.Writer) error {
 	if exp == 0 {
 		return fmt.Errorf("Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)")
 	}
+	maxViews := viper.GetInt("max-views")
+	if maxViews < 0 {
+		return fmt.Errorf("Max views can't be negative")
+	}
 
-	key, err := encryptionKey(viper.GetString("key"))
+	recipients, err := recipients()
 	if err != nil {
-		return fmt.Errorf("Failed to generate encryption key: %w", err)
+		return err
+	}
+	// Secrets for recipients only get a key if one is given.
+	var key string
+	if len(recipients) == 0 || viper.IsSet("key") {
+		if key, err = encryptionKey(viper.GetString("key")); err != nil {
+			return fmt.Errorf("Failed to generate encryption key: %w", err)
+		}
 	}
 
-	msg, err := crypto.Encrypt(in, key)
+	msg, err := crypto.Encrypt(in, key, recipients...)
 	if err != nil {
 		return fmt.Errorf("Failed to encrypt secret: %w", err)
 	}
 
-	id, err := client.Store(viper.GetString("api"), domain.Secret{
+	secret := domain.Secret{
 		Expiration: exp,
 		Message:    msg,
 		OneTime:    viper.GetBool("one-time"),
-	})
+		NotifyURL:  viper.GetString("notify-url"),
+	}
+	if maxViews > 0 {
+		secret.OneTime = maxViews == 1
+		secret.MaxViews = maxViews
+	}
+	created, err := client.Create(viper.GetString("api"), secret)
 	if err != nil {
 		return fmt.Errorf("Failed to store secret: %w", err)
 	}
+	// The token goes to stderr, so only the URL is piped on.
+	if created.Token != "" {
+		fmt.Fprintln(os.Stderr, "Management token (see --status, --extend and --delete):", created.Token)
+	}
 
 	url := viper.GetString("url")
-	_, err = fmt.Fprintln(out, utils.SecretURL(url, id, key, viper.IsSet("file"), viper.IsSet("key")))
+	_, err = fmt.Fprintln(out, utils.SecretURL(url, created.ID, key, viper.IsSet("file"), key == "" || viper.IsSet("key")))
 	return err
 }
 
@@ -2,11 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/spf13/viper"
 )
 
@@ -56,6 +64,343 @@ func TestInvalidExpiration(t *testing.T) {
 	}
 }
 
+func TestInvalidMaxViews(t *testing.T) {
+	viper.Set("max-views", -1)
+	err := encrypt(nil, nil)
+	viper.Set("max-views", 0) // reset value
+	if err == nil {
+		t.Fatal("expected max views validation error, got none")
+	}
+	want := "Max views can't be negative"
+	if err.Error() != want {
+		t.Fatalf("expected %s, got %s", want, err.Error())
+	}
+}
+
+func TestMaxViews(t *testing.T) {
+	var stored domain.Secret
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&stored); err != nil {
+			t.Errorf("failed to decode secret: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "stored-id"})
+	}))
+	defer ts.Close()
+
+	api := viper.GetString("api")
+	viper.Set("api", ts.URL)
+	defer viper.Set("api", api)
+	defer viper.Set("max-views", 0)
+
+	for _, tc := range []struct {
+		maxViews int
+		oneTime  bool
+	}{
+		{maxViews: 3, oneTime: false},
+		{maxViews: 1, oneTime: true},
+	} {
+		viper.Set("max-views", tc.maxViews)
+		out := bytes.Buffer{}
+		if err := encrypt(io.NopCloser(strings.NewReader("secret")), &out); err != nil {
+			t.Fatalf("expected no encryption error, got %q", err)
+		}
+		if stored.MaxViews != tc.maxViews || stored.OneTime != tc.oneTime {
+			t.Errorf("expected %d views (one-time %t), got %+v", tc.maxViews, tc.oneTime, stored)
+		}
+	}
+
+	viper.Set("notify-url", "https://hooks.example.com/yopass")
+	defer viper.Set("notify-url", "")
+	if err := encrypt(io.NopCloser(strings.NewReader("secret")), &bytes.Buffer{}); err != nil {
+		t.Fatalf("expected no encryption error, got %q", err)
+	}
+	if stored.NotifyURL != "https://hooks.example.com/yopass" {
+		t.Errorf("expected the notification URL to be sent, got %+v", stored)
+	}
+}
+
+func TestManageSecret(t *testing.T) {
+	var methods []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secret/6ba7b810-9dad-11d1-80b4-00c04fd430c8/manage" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected management token, got %q", r.Header.Get("Authorization"))
+		}
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"oneTime":   true,
+			"expiresAt": "2024-05-02T12:00:00Z",
+			"viewed":    true,
+			"viewedAt":  "2024-05-01T12:10:00Z",
+		})
+	}))
+	defer ts.Close()
+
+	api, url := viper.GetString("api"), viper.GetString("url")
+	viper.Set("api", ts.URL)
+	viper.Set("url", "https://yopass.example")
+	defer viper.Set("api", api)
+	defer viper.Set("url", url)
+	secretURL := "https://yopass.example/#/s/6ba7b810-9dad-11d1-80b4-00c04fd430c8/key"
+	for _, flag := range []string{"status", "extend", "delete"} {
+		viper.Set(flag, secretURL)
+		defer viper.Set(flag, "")
+	}
+
+	if err := status(&bytes.Buffer{}); err == nil || err.Error() != "Management token required, set --token" {
+		t.Fatalf("expected missing token error, got %v", err)
+	}
+	viper.Set("token", "token")
+	defer viper.Set("token", "")
+
+	out := bytes.Buffer{}
+	if err := status(&out); err != nil {
+		t.Fatalf("expected no status error, got %q", err)
+	}
+	want := "Viewed: yes, at 2024-05-01T12:10:00Z\nExpires: 2024-05-02T12:00:00Z\n"
+	if out.String() != want {
+		t.Errorf("expected status %q, got %q", want, out.String())
+	}
+	if err := extend(&bytes.Buffer{}); err != nil {
+		t.Fatalf("expected no extend error, got %q", err)
+	}
+	if err := remove(); err != nil {
+		t.Fatalf("expected no delete error, got %q", err)
+	}
+	if strings.Join(methods, " ") != "GET PATCH DELETE" {
+		t.Errorf("expected GET, PATCH and DELETE requests, got %v", methods)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	var stored domain.Request
+	var sent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /request":
+			json.NewDecoder(r.Body).Decode(&stored)
+			json.NewEncoder(w).Encode(map[string]string{"message": id, "token": "token"})
+		case "GET /request/" + id:
+			json.NewEncoder(w).Encode(map[string]any{"publicKey": stored.PublicKey})
+		case "POST /request/" + id:
+			var secret domain.Secret
+			json.NewDecoder(r.Body).Decode(&secret)
+			sent = secret.Message
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /request/" + id + "/secret":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("expected request token, got %q", r.Header.Get("Authorization"))
+			}
+			json.NewEncoder(w).Encode(map[string]string{"message": sent})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	api, url := viper.GetString("api"), viper.GetString("url")
+	viper.Set("api", ts.URL)
+	viper.Set("url", "https://yopass.example")
+	defer viper.Set("api", api)
+	defer viper.Set("url", url)
+
+	msg := "yopass CLI request test message"
+	fulfilFrom := func(requestURL string) {
+		t.Helper()
+		in, err := tempFile(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(in.Name())
+		defer in.Close()
+		if err := fulfil([]string{requestURL}, in, &bytes.Buffer{}); err != nil {
+			t.Fatalf("expected no fulfil error, got %q", err)
+		}
+	}
+
+	out := bytes.Buffer{}
+	if err := request(nil, &out); err != nil {
+		t.Fatalf("expected no request error, got %q", err)
+	}
+	requestURL := strings.TrimSpace(out.String())
+	if !strings.HasPrefix(requestURL, "https://yopass.example/#/r/"+id+"/") || stored.PublicKey != "" || stored.Expiration != 3600 {
+		t.Fatalf("expected request URL with key, got %q for %+v", requestURL, stored)
+	}
+	fulfilFrom(requestURL)
+	if err := request([]string{requestURL}, &bytes.Buffer{}); err == nil || err.Error() != "Request token required, set --token" {
+		t.Fatalf("expected missing token error, got %v", err)
+	}
+	viper.Set("token", "token")
+	defer viper.Set("token", "")
+	out.Reset()
+	if err := request([]string{requestURL}, &out); err != nil {
+		t.Fatalf("expected no fetch error, got %q", err)
+	}
+	if out.String() != msg {
+		t.Errorf("expected secret to match original %q, got %q", msg, out.String())
+	}
+
+	// Secrets requested with a public key are encrypted to it and left to
+	// the private key to decrypt.
+	entity, err := openpgp.NewEntity("Helpdesk", "", "helpdesk@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("public-key", writeKey(t, entity, false))
+	defer viper.Set("public-key", "")
+
+	out.Reset()
+	if err := request(nil, &out); err != nil {
+		t.Fatalf("expected no request error, got %q", err)
+	}
+	requestURL = strings.TrimSpace(out.String())
+	if requestURL != "https://yopass.example/#/r/"+id || !strings.Contains(stored.PublicKey, "PGP PUBLIC KEY BLOCK") {
+		t.Fatalf("expected request URL without key, got %q for %+v", requestURL, stored)
+	}
+	fulfilFrom(requestURL)
+	out.Reset()
+	if err := request([]string{requestURL}, &out); err != nil {
+		t.Fatalf("expected no fetch error, got %q", err)
+	}
+	block, err := armor.Decode(&out)
+	if err != nil {
+		t.Fatalf("expected an armored message, got %v", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("expected the secret to be encrypted to the public key, got %v", err)
+	}
+	if pt, _ := io.ReadAll(md.UnverifiedBody); string(pt) != msg {
+		t.Errorf("expected secret to match original %q, got %q", msg, pt)
+	}
+	viper.Set("private-key", writeKey(t, entity, true))
+	defer viper.Set("private-key", "")
+	out.Reset()
+	if err := request([]string{requestURL}, &out); err != nil {
+		t.Fatalf("expected no fetch error, got %q", err)
+	}
+	if out.String() != msg {
+		t.Errorf("expected the private key to decrypt the secret, got %q", out.String())
+	}
+
+	if err := fulfil(nil, nil, nil); err == nil {
+		t.Error("expected missing request URL error, got none")
+	}
+	if err := request([]string{"https://yopass.example/#/s/" + id + "/key"}, nil); err == nil || !strings.HasPrefix(err.Error(), "Invalid yopass request URL") {
+		t.Errorf("expected invalid request URL error, got %v", err)
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	var stored domain.Secret
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&stored)
+			json.NewEncoder(w).Encode(map[string]string{"message": id})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": stored.Message})
+	}))
+	defer ts.Close()
+
+	api, url := viper.GetString("api"), viper.GetString("url")
+	viper.Set("api", ts.URL)
+	viper.Set("url", "https://yopass.example")
+	defer viper.Set("api", api)
+	defer viper.Set("url", url)
+
+	alice, err := openpgp.NewEntity("Alice", "", "alice@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := openpgp.NewEntity("Bob", "", "bob@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bob's key comes from the GnuPG keyring.
+	bobFingerprint := fmt.Sprintf("%X", bob.PrimaryKey.Fingerprint)
+	defer func(export func(string) ([]byte, error)) { gpgExport = export }(gpgExport)
+	gpgExport = func(fingerprint string) ([]byte, error) {
+		if fingerprint != bobFingerprint {
+			return nil, nil
+		}
+		return os.ReadFile(writeKey(t, bob, false))
+	}
+
+	msg := "yopass CLI recipient test message"
+	viper.Set("recipient", []string{writeKey(t, alice, false), "0x" + strings.ToLower(bobFingerprint)})
+	defer viper.Set("recipient", nil)
+	out := bytes.Buffer{}
+	if err := encrypt(io.NopCloser(strings.NewReader(msg)), &out); err != nil {
+		t.Fatalf("expected no encryption error, got %q", err)
+	}
+	secretURL := strings.TrimSpace(out.String())
+	if secretURL != "https://yopass.example/#/s/"+id {
+		t.Fatalf("expected secret URL without key, got %q", secretURL)
+	}
+
+	viper.Set("decrypt", secretURL)
+	defer viper.Set("decrypt", "")
+	if err := decrypt(&bytes.Buffer{}); err == nil || err.Error() != "Manual decryption key required, set --key or --private-key" {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+	defer viper.Set("private-key", "")
+	for name, e := range map[string]*openpgp.Entity{"alice": alice, "bob": bob} {
+		viper.Set("private-key", writeKey(t, e, true))
+		out.Reset()
+		if err := decrypt(&out); err != nil {
+			t.Fatalf("expected no decryption error for %s, got %q", name, err)
+		}
+		if out.String() != msg {
+			t.Errorf("expected %s to decrypt %q, got %q", name, msg, out.String())
+		}
+	}
+
+	viper.Set("recipient", []string{"0x" + strings.Repeat("AB", 20)})
+	if err := encrypt(io.NopCloser(strings.NewReader(msg)), &out); err == nil || !strings.Contains(err.Error(), "no key with fingerprint") {
+		t.Errorf("expected unknown fingerprint error, got %v", err)
+	}
+	viper.Set("recipient", []string{"missing.asc"})
+	if err := encrypt(io.NopCloser(strings.NewReader(msg)), &out); err == nil || !strings.HasPrefix(err.Error(), "Invalid recipient missing.asc") {
+		t.Errorf("expected missing key file error, got %v", err)
+	}
+}
+
+// writeKey writes the armored public or, if private is set, private key of
+// e to a file and returns its name.
+func writeKey(t *testing.T, e *openpgp.Entity, private bool) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "key.asc")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	blockType, serialize := openpgp.PublicKeyType, e.Serialize
+	if private {
+		blockType, serialize = openpgp.PrivateKeyType, func(w io.Writer) error { return e.SerializePrivate(w, nil) }
+	}
+	w, err := armor.Encode(f, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
 func TestMissingFileEncryption(t *testing.T) {
 	viper.Set("file", "xyz")
 	err := encryptStdinOrFile(nil, nil)
@@ -132,7 +477,7 @@ func TestDecryptWithoutCustomKey(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected missing key error, got none")
 	}
-	want := "Manual decryption key required, set --key"
+	want := "Manual decryption key required, set --key or --private-key"
 	if err.Error() != want {
 		t.Fatalf("expected %s, got %s", want, err.Error())
 	}
@@ -222,6 +567,11 @@ func TestCLIParse(t *testing.T) {
 			exit:   -1,
 			output: "",
 		},
+		{
+			args:   []string{"request", "--expiration=1d"},
+			exit:   -1,
+			output: "",
+		},
 		{
 			args:   []string{"-h"},
 			exit:   0,
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/client"
+	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/utils"
+	"github.com/spf13/viper"
+)
+
+// request creates a request for a secret or, given the request URL, prints
+// the secret sent for it.
+func request(args []string, out io.Writer) error {
+	switch len(args) {
+	case 0:
+		return createRequest(out)
+	case 1:
+		return fetchRequested(args[0], out)
+	default:
+		return fmt.Errorf("Too many arguments, expected at most a request URL")
+	}
+}
+
+// createRequest prints the URL of a new request for a secret. The secret
+// is encrypted to the public key given with --public-key or else with a
+// key kept in the URL.
+func createRequest(out io.Writer) error {
+	exp := expiration(viper.GetString("expiration"))
+	if exp == 0 {
+		return fmt.Errorf("Expiration can only be 1 hour (1h), 1 day (1d), or 1 week (1w)")
+	}
+
+	r := domain.Request{Expiration: exp}
+	var key string
+	if publicKey := viper.GetString("public-key"); publicKey != "" {
+		e, err := readPublicKey(publicKey)
+		if err != nil {
+			return fmt.Errorf("Failed to read public key: %w", err)
+		}
+		// The server only takes armored keys.
+		if r.PublicKey, err = crypto.ArmorPublicKey(e); err != nil {
+			return fmt.Errorf("Failed to read public key: %w", err)
+		}
+	} else {
+		var err error
+		if key, err = encryptionKey(viper.GetString("key")); err != nil {
+			return fmt.Errorf("Failed to generate encryption key: %w", err)
+		}
+	}
+
+	created, err := client.CreateRequest(viper.GetString("api"), r)
+	if err != nil {
+		return fmt.Errorf("Failed to store request: %w", err)
+	}
+	// The token goes to stderr, so only the URL is piped on.
+	fmt.Fprintln(os.Stderr, "Request token (see yopass request <url> --token):", created.Token)
+
+	// Like for secrets, a manual key is left out of the URL.
+	if viper.IsSet("key") {
+		key = ""
+	}
+	_, err = fmt.Fprintln(out, utils.RequestURL(viper.GetString("url"), created.ID, key))
+	return err
+}
+
+// fetchRequested prints the secret sent for the request URL. Secrets
+// encrypted to a public key are decrypted with --private-key or else
+// printed as is, for the private key to decrypt.
+func fetchRequested(url string, out io.Writer) error {
+	id, key, err := requestURL(url)
+	if err != nil {
+		return err
+	}
+	if viper.GetString("token") == "" {
+		return fmt.Errorf("Request token required, set --token")
+	}
+	if key == "" {
+		key = viper.GetString("key")
+	}
+	keyring, err := privateKeys()
+	if err != nil {
+		return err
+	}
+
+	msg, err := client.FetchRequested(viper.GetString("api"), id, viper.GetString("token"))
+	if err != nil {
+		return fmt.Errorf("Failed to fetch secret: %w", err)
+	}
+	if key == "" && keyring == nil {
+		_, err = fmt.Fprint(out, msg)
+		return err
+	}
+	pt, _, err := crypto.Decrypt(strings.NewReader(msg), key, keyring)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt secret: %w", err)
+	}
+	_, err = fmt.Fprint(out, pt)
+	return err
+}
+
+// fulfil encrypts the secret read from --file or in the way the request
+// URL in args asks for and sends it.
+func fulfil(args []string, in *os.File, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Request URL required, e.g. yopass fulfil https://yopass.se/#/r/...")
+	}
+	id, key, err := requestURL(args[0])
+	if err != nil {
+		return err
+	}
+	r, err := client.GetRequest(viper.GetString("api"), id)
+	if err != nil {
+		return fmt.Errorf("Failed to get request: %w", err)
+	}
+
+	if viper.IsSet("file") {
+		f, err := os.Open(viper.GetString("file"))
+		if err != nil {
+			return fmt.Errorf("Failed to open file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	} else {
+		info, err := in.Stat()
+		if err != nil {
+			return fmt.Errorf("Failed to get file info: %w", err)
+		}
+		if info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("No filename or piped input to encrypt given")
+		}
+	}
+
+	var msg string
+	if r.PublicKey != "" {
+		publicKey, err := crypto.ReadPublicKey(r.PublicKey)
+		if err != nil {
+			return fmt.Errorf("Failed to read public key of request: %w", err)
+		}
+		msg, err = crypto.Encrypt(in, "", publicKey)
+		if err != nil {
+			return fmt.Errorf("Failed to encrypt secret: %w", err)
+		}
+	} else {
+		if key == "" {
+			key = viper.GetString("key")
+		}
+		if key == "" {
+			return fmt.Errorf("Manual encryption key required, set --key")
+		}
+		msg, err = crypto.Encrypt(in, key)
+		if err != nil {
+			return fmt.Errorf("Failed to encrypt secret: %w", err)
+		}
+	}
+
+	if err := client.Fulfil(viper.GetString("api"), id, domain.Secret{Message: msg}); err != nil {
+		return fmt.Errorf("Failed to send secret: %w", err)
+	}
+	_, err = fmt.Fprintln(out, "Secret sent")
+	return err
+}
+
+// requestURL returns the request ID and key of url.
+func requestURL(url string) (id, key string, err error) {
+	if !strings.HasPrefix(url, viper.GetString("url")) {
+		return "", "", fmt.Errorf("Unconfigured yopass request URL, set --api and --url")
+	}
+	if id, key, err = utils.ParseRequestURL(url); err != nil {
+		return "", "", fmt.Errorf("Invalid yopass request URL: %w", err)
+	}
+	return id, key, nil
+}
@@ -0,0 +1,314 @@
+// Package audit keeps a tamper-evident log of the lifecycle of secrets:
+// when each one was created, viewed, extended, deleted, expired or, for
+// requests, fulfilled, and by which client IP. Entries describe secrets
+// without their content.
+//
+// Every entry carries a sequence number and the hash of the entry before
+// it, and ends with its own hash, so removing, reordering or changing an
+// entry breaks the chain. Verifier checks the chain offline. Anyone able
+// to rewrite the whole log can forge a new chain, so a copy should be
+// shipped off the host, e.g. to syslog.
+package audit
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/utils"
+	"go.uber.org/zap"
+)
+
+// defaultInterval is how often expired secrets are looked for unless
+// Options.Interval is set.
+const defaultInterval = time.Second
+
+// Options configures a Log.
+type Options struct {
+	// Sinks lists where entries are written to: "stdout", "syslog" or
+	// "file:<path>" for a file of JSON lines.
+	Sinks []string
+	// TruncateIPs keeps only the network of client IPs, i.e. the first 24
+	// bits of IPv4 and the first 48 bits of IPv6 addresses.
+	TruncateIPs bool
+	// Interval is how often expired secrets are looked for. It defaults to
+	// a second.
+	Interval time.Duration
+	Logger   *zap.Logger
+}
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	domain.Event
+	ClientIP string `json:"client_ip,omitempty"`
+	// Prev is the hash of the entry before, empty for the first entry of
+	// a chain.
+	Prev string `json:"prev,omitempty"`
+	// Hash is the hex SHA-256 of the entry encoded without it. It is
+	// always the last field of the line.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Log is a domain.Auditor writing a hash chain of entries to its sinks.
+//
+// Secrets are recorded as expired once their expiry passes before their
+// last view or deletion. Pending expiries are kept in memory and, if there
+// is a file sink, replayed from the first one on startup, together with the
+// head of the chain it continues. Without a file sink every start begins a
+// new chain.
+type Log struct {
+	opts  Options
+	sinks []Sink
+	now   func() time.Time
+
+	mu       sync.Mutex
+	seq      uint64
+	hash     string
+	pending  map[string]domain.Event
+	expiries expiryHeap
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New opens the sinks of opts and starts recording expired secrets until
+// Close is called.
+func New(opts Options) (*Log, error) {
+	if len(opts.Sinks) == 0 {
+		return nil, errors.New("no audit sinks specified")
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	l := &Log{
+		opts:    opts,
+		now:     time.Now,
+		pending: map[string]domain.Event{},
+		done:    make(chan struct{}),
+	}
+
+	replayed := false
+	for _, spec := range opts.Sinks {
+		if path, ok := strings.CutPrefix(spec, "file:"); ok && !replayed {
+			if err := l.replay(path); err != nil {
+				l.closeSinks()
+				return nil, fmt.Errorf("failed to replay audit log %s: %w", path, err)
+			}
+			replayed = true
+		}
+		sink, err := OpenSink(spec)
+		if err != nil {
+			l.closeSinks()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+
+	l.wg.Add(1)
+	go l.run()
+	return l, nil
+}
+
+// Record writes an entry of event for the client IP carried by ctx.
+func (l *Log) Record(ctx context.Context, event domain.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.track(event)
+	l.write(event, l.clientIP(utils.ClientIP(ctx)))
+}
+
+// Close stops recording expired secrets and closes the sinks.
+func (l *Log) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		err = l.closeSinks()
+	})
+	return err
+}
+
+func (l *Log) closeSinks() error {
+	var errs []error
+	for _, sink := range l.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (l *Log) run() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.expire(l.now())
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// expire records the secrets whose expiry has passed at now.
+func (l *Log) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.expiries) > 0 && !l.expiries[0].at.After(now) {
+		next := heap.Pop(&l.expiries).(expiry)
+		event, ok := l.pending[next.key]
+		// Extended secrets are still in the heap with their old expiry.
+		if !ok || !event.ExpiresAt.Equal(next.at) {
+			continue
+		}
+		event.Type = domain.EventExpired
+		event.Time = event.ExpiresAt
+		l.track(event)
+		l.write(event, "")
+	}
+}
+
+// track keeps the expiries of secrets that can still be read up to date.
+// The caller must hold l.mu.
+func (l *Log) track(event domain.Event) {
+	switch event.Type {
+	case domain.EventCreated, domain.EventExtended:
+		if event.ExpiresAt.IsZero() {
+			return
+		}
+		l.pending[event.Key] = event
+		heap.Push(&l.expiries, expiry{at: event.ExpiresAt, key: event.Key})
+	case domain.EventViewed:
+		pending, ok := l.pending[event.Key]
+		switch {
+		case !ok:
+		case event.Consumed():
+			delete(l.pending, event.Key)
+		default:
+			pending.RemainingViews = event.RemainingViews
+			l.pending[event.Key] = pending
+		}
+	case domain.EventDeleted, domain.EventExpired, domain.EventFulfilled:
+		delete(l.pending, event.Key)
+	}
+}
+
+// write appends an entry of event to the chain and writes it to all sinks.
+// Failing sinks are logged, the others still get the entry. The caller
+// must hold l.mu.
+func (l *Log) write(event domain.Event, ip string) {
+	l.seq++
+	line, hash, err := encode(Entry{Seq: l.seq, Event: event, ClientIP: ip, Prev: l.hash})
+	if err != nil {
+		l.seq--
+		l.opts.Logger.Error("Failed to encode audit entry", zap.String("event", event.Type), zap.Error(err))
+		return
+	}
+	l.hash = hash
+	for _, sink := range l.sinks {
+		if err := sink.Write(line); err != nil {
+			l.opts.Logger.Error("Failed to write audit entry", zap.Uint64("seq", l.seq), zap.Error(err))
+		}
+	}
+}
+
+// clientIP returns ip, truncated if configured. Truncation drops anything
+// that isn't an IP.
+func (l *Log) clientIP(ip string) string {
+	if !l.opts.TruncateIPs || ip == "" {
+		return ip
+	}
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return parsed.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// replay continues the chain of the audit log file at path and picks up
+// the expiries of the secrets it records. A missing file starts a new
+// chain. Lines that can't be decoded, e.g. cut short by a crash, are
+// skipped; Verifier reports them.
+func (l *Log) replay(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineLength)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Hash == "" {
+			l.opts.Logger.Warn("Skipping corrupt audit entry", zap.String("path", path), zap.Uint64("after", l.seq))
+			continue
+		}
+		l.seq, l.hash = e.Seq, e.Hash
+		l.track(e.Event)
+	}
+	return scanner.Err()
+}
+
+// encode returns the line of e and its hash. The hash covers the JSON
+// encoding of e without it, and is appended as the last field.
+func encode(e Entry) ([]byte, string, error) {
+	e.Hash = ""
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	line := make([]byte, 0, len(body)+len(hashField)+len(hash)+2)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, `"}`...)
+	return line, hash, nil
+}
+
+// expiry is a pending expiry of the secret stored under key.
+type expiry struct {
+	at  time.Time
+	key string
+}
+
+// expiryHeap orders expiries by time, the next one first.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(expiry)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,330 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/utils"
+)
+
+// newTestLog returns a log writing to a file in a temporary directory.
+// Expiries are only recorded by calling expire.
+func newTestLog(t *testing.T, path string, truncate bool) *Log {
+	t.Helper()
+	l, err := New(Options{Sinks: []string{"file:" + path}, TruncateIPs: truncate, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var entries []Entry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unexpected error %v decoding %q", err, line)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func verify(t *testing.T, v *Verifier, paths ...string) error {
+	t.Helper()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		err = v.Verify(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	created = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires = created.Add(time.Hour)
+)
+
+func event(typ, key string, remaining int) domain.Event {
+	return domain.Event{Type: typ, Key: key, Time: created, MaxViews: 2, RemainingViews: remaining, ExpiresAt: expires}
+}
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newTestLog(t, path, false)
+
+	l.Record(utils.WithClientIP(context.Background(), "1.2.3.4"), event(domain.EventCreated, "a", 2))
+	l.Record(context.Background(), event(domain.EventViewed, "a", 1))
+	l.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if first.Seq != 1 || first.Prev != "" || first.Type != domain.EventCreated || first.Key != "a" {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	if first.ClientIP != "1.2.3.4" || !first.ExpiresAt.Equal(expires) || first.MaxViews != 2 {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	if second.Seq != 2 || second.Prev != first.Hash || second.ClientIP != "" {
+		t.Errorf("unexpected second entry %+v", second)
+	}
+
+	v := &Verifier{}
+	if err := verify(t, v, path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if v.Entries != 2 || v.Seq != 2 || v.Hash != second.Hash || v.First != "" {
+		t.Errorf("unexpected verifier state %+v", v)
+	}
+}
+
+func TestTruncateIPs(t *testing.T) {
+	tt := []struct {
+		ip       string
+		expected string
+	}{
+		{ip: "1.2.3.4", expected: "1.2.3.0"},
+		{ip: "2001:db8:1234:5678::1", expected: "2001:db8:1234::"},
+		{ip: "::ffff:1.2.3.4", expected: "1.2.3.0"},
+		{ip: "invalid", expected: ""},
+		{ip: "", expected: ""},
+	}
+	l := &Log{opts: Options{TruncateIPs: true}}
+	for _, tc := range tt {
+		if got := l.clientIP(tc.ip); got != tc.expected {
+			t.Errorf("expected %q truncated to %q, got %q", tc.ip, tc.expected, got)
+		}
+	}
+	l.opts.TruncateIPs = false
+	if got := l.clientIP("1.2.3.4"); got != "1.2.3.4" {
+		t.Errorf("expected untruncated IP, got %q", got)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newTestLog(t, path, false)
+	for _, key := range []string{"a", "b", "c"} {
+		l.Record(utils.WithClientIP(context.Background(), "1.2.3.4"), event(domain.EventCreated, key, 2))
+	}
+	l.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	tt := []struct {
+		name   string
+		log    string
+		line   string
+		reason string
+	}{
+		{
+			name:   "changed",
+			log:    strings.Replace(string(data), "1.2.3.4", "1.2.3.5", 1),
+			line:   "line 1:",
+			reason: "hash mismatch",
+		},
+		{
+			name:   "removed",
+			log:    lines[0] + lines[2],
+			line:   "line 2:",
+			reason: "entry 3 follows entry 1",
+		},
+		{
+			name:   "reordered",
+			log:    lines[1] + lines[0] + lines[2],
+			line:   "line 2:",
+			reason: "new chain started after entry 2",
+		},
+		{
+			name:   "truncated",
+			log:    lines[0] + lines[1][:20] + "\n" + lines[2],
+			line:   "line 2:",
+			reason: "malformed entry",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := (&Verifier{}).Verify(strings.NewReader(tc.log))
+			if !errors.Is(err, ErrChainBroken) {
+				t.Fatalf("expected ErrChainBroken, got %v", err)
+			}
+			if !strings.HasPrefix(err.Error(), tc.line) || !strings.Contains(err.Error(), tc.reason) {
+				t.Errorf("expected error at %s about %q, got %v", tc.line, tc.reason, err)
+			}
+		})
+	}
+
+	// Logs rotated away before the first file are fine.
+	v := &Verifier{}
+	if err := v.Verify(strings.NewReader(lines[1] + lines[2])); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if first := readEntries(t, path)[0]; v.First != first.Hash || v.Entries != 2 {
+		t.Errorf("expected the chain to continue from %s, got %+v", first.Hash, v)
+	}
+}
+
+func TestVerifyRestarts(t *testing.T) {
+	dir := t.TempDir()
+	var log bytes.Buffer
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, "audit.log")
+		l := newTestLog(t, path, false)
+		l.Record(context.Background(), event(domain.EventCreated, "a", 2))
+		l.Close()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		log.Write(data)
+		os.Remove(path)
+	}
+
+	if err := (&Verifier{}).Verify(bytes.NewReader(log.Bytes())); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("expected ErrChainBroken for a restarted chain, got %v", err)
+	}
+	v := &Verifier{AllowRestarts: true}
+	if err := v.Verify(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if v.Entries != 2 || v.Restarts != 1 {
+		t.Errorf("expected 2 entries and a restart, got %+v", v)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	l := newTestLog(t, path, false)
+	l.Record(context.Background(), event(domain.EventCreated, "a", 2))
+	l.Record(context.Background(), event(domain.EventCreated, "b", 2))
+	l.Record(context.Background(), event(domain.EventDeleted, "b", 2))
+	l.Close()
+
+	// A line cut short by a crash is skipped, and the next entry starts on
+	// a new line.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	f.WriteString(`{"seq":4,"event":"secret.cre`)
+	f.Close()
+
+	l = newTestLog(t, path, false)
+	l.Record(context.Background(), event(domain.EventViewed, "a", 1))
+	l.expire(expires)
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got %d", len(lines))
+	}
+	repaired := strings.Join(append(lines[:3:3], lines[4:]...), "\n") + "\n"
+	if err := os.WriteFile(path, []byte(repaired), 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	entries := readEntries(t, path)
+	viewed, expired := entries[3], entries[4]
+	if viewed.Seq != 4 || viewed.Prev != entries[2].Hash {
+		t.Errorf("expected the chain to be resumed, got %+v", viewed)
+	}
+	if expired.Type != domain.EventExpired || expired.Key != "a" || !expired.Time.Equal(expires) {
+		t.Errorf("unexpected expired entry %+v", expired)
+	}
+	if expired.MaxViews != 2 || expired.RemainingViews != 1 || expired.ClientIP != "" {
+		t.Errorf("unexpected expired entry %+v", expired)
+	}
+	if err := verify(t, &Verifier{}, path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newTestLog(t, path, false)
+	ctx := context.Background()
+
+	l.Record(ctx, event(domain.EventCreated, "viewed", 1))
+	l.Record(ctx, event(domain.EventViewed, "viewed", 0))
+	l.Record(ctx, event(domain.EventCreated, "deleted", 2))
+	l.Record(ctx, event(domain.EventDeleted, "deleted", 2))
+	l.Record(ctx, event(domain.EventCreated, "extended", 2))
+	extended := event(domain.EventExtended, "extended", 2)
+	extended.ExpiresAt = expires.Add(time.Hour)
+	l.Record(ctx, extended)
+	l.Record(ctx, event(domain.EventCreated, "expired", 2))
+
+	l.expire(expires.Add(-time.Second))
+	l.expire(expires)
+	l.expire(expires.Add(time.Hour))
+	l.expire(expires.Add(2 * time.Hour))
+	l.Close()
+
+	var got []string
+	for _, e := range readEntries(t, path) {
+		if e.Type == domain.EventExpired {
+			got = append(got, e.Key+"@"+e.Time.Sub(expires).String())
+		}
+	}
+	if strings.Join(got, ",") != "expired@0s,extended@1h0m0s" {
+		t.Errorf("unexpected expired entries %v", got)
+	}
+}
+
+func TestOpenSink(t *testing.T) {
+	for _, spec := range []string{"", "file:", "file", "kafka"} {
+		if _, err := OpenSink(spec); err == nil {
+			t.Errorf("expected error for sink %q", spec)
+		}
+	}
+	if _, err := New(Options{}); err == nil {
+		t.Error("expected error without sinks")
+	}
+	if _, err := New(Options{Sinks: []string{"stdout", "invalid"}}); err == nil {
+		t.Error("expected error for an invalid sink")
+	}
+
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+	sink, err := OpenSink("file:" + path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer sink.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Sink receives the lines of the audit log.
+type Sink interface {
+	// Write writes a line, given without a trailing newline
+	Write(line []byte) error
+	Close() error
+}
+
+// OpenSink opens the sink described by spec: "stdout", "syslog" or
+// "file:<path>".
+func OpenSink(spec string) (Sink, error) {
+	switch {
+	case spec == "stdout":
+		return &writerSink{w: os.Stdout}, nil
+	case spec == "syslog":
+		return newSyslogSink()
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("invalid audit sink %q: missing path", spec)
+		}
+		return newFileSink(path)
+	default:
+		return nil, fmt.Errorf("invalid audit sink %q", spec)
+	}
+}
+
+// writerSink writes lines to an io.Writer it doesn't own.
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) Write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(append(line[:len(line):len(line)], '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// fileSink appends lines to a file readable only by its owner.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	// A crash may have cut the last line short; start on a line of our own.
+	info, err := f.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = f.Write([]byte{'\n'})
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(line []byte) error {
+	_, err := s.f.Write(append(line[:len(line):len(line)], '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error { return s.f.Close() }
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxLineLength bounds the length of an audit log line.
+const maxLineLength = 1 << 20
+
+// hashField starts the hash, the last field of every line.
+const hashField = `,"hash":"`
+
+// ErrChainBroken is returned by Verifier for logs that were tampered with
+// or are incomplete.
+var ErrChainBroken = errors.New("audit log chain broken")
+
+// Verifier checks the hash chain of audit logs. Files rotated out of a log
+// are verified in order by passing them to the same Verifier.
+type Verifier struct {
+	// AllowRestarts accepts new chains starting within the log, as written
+	// by servers without a file sink on every start.
+	AllowRestarts bool
+
+	// Entries is the number of entries verified so far.
+	Entries int
+	// Restarts is the number of new chains started within the log.
+	Restarts int
+	// First is the hash the first entry continues from, empty if the log
+	// starts a chain. It isn't empty for logs whose earlier files were
+	// rotated away.
+	First string
+	// Seq and Hash belong to the last verified entry.
+	Seq  uint64
+	Hash string
+}
+
+// Verify reads the lines of r and checks that they continue the chain.
+// The error tells the line of r the chain breaks at.
+func (v *Verifier) Verify(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineLength)
+	for n := 1; scanner.Scan(); n++ {
+		if err := v.verifyLine(scanner.Bytes()); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (v *Verifier) verifyLine(line []byte) error {
+	i := bytes.LastIndex(line, []byte(hashField))
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return fmt.Errorf("%w: malformed entry", ErrChainBroken)
+	}
+	hash := string(line[i+len(hashField) : len(line)-2])
+	body := append(line[:i:i], '}')
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != hash {
+		return fmt.Errorf("%w: hash mismatch", ErrChainBroken)
+	}
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil || e.Hash != hash {
+		return fmt.Errorf("%w: malformed entry", ErrChainBroken)
+	}
+
+	switch {
+	case v.Entries == 0 && e.Prev == "" && e.Seq != 1:
+		return fmt.Errorf("%w: chain starts at entry %d", ErrChainBroken, e.Seq)
+	case v.Entries == 0:
+		v.First = e.Prev
+	case e.Seq == 1 && e.Prev == "":
+		if !v.AllowRestarts {
+			return fmt.Errorf("%w: new chain started after entry %d", ErrChainBroken, v.Seq)
+		}
+		v.Restarts++
+	case e.Seq != v.Seq+1:
+		return fmt.Errorf("%w: entry %d follows entry %d", ErrChainBroken, e.Seq, v.Seq)
+	case e.Prev != v.Hash:
+		return fmt.Errorf("%w: entry %d doesn't link to entry %d", ErrChainBroken, e.Seq, v.Seq)
+	}
+	v.Entries++
+	v.Seq, v.Hash = e.Seq, e.Hash
+	return nil
+}
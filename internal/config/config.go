@@ -2,43 +2,71 @@ package config
 
 import (
 	"strings"
+	"time"
 
+	"github.com/Khovanskiy5/yopass/pkg/driver"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Address             string
-	Port                int
-	Database            string
-	AssetPath           string
-	MaxLength           int
-	Memcached           string
-	MetricsPort         int
-	Redis               string
-	TLSCert             string
-	TLSKey              string
-	ForceOneTimeSecrets bool
-	CORSAllowOrigin     string
-	DisableUpload       bool
-	PrefetchSecret      bool
-	DisableFeatures     bool
-	NoLanguageSwitcher  bool
-	TrustedProxies      []string
-	PrivacyNoticeURL    string
-	ImprintURL          string
-	AllowedExpirations  []int
+	Address                 string
+	Port                    int
+	CircuitBreakerCooldown  time.Duration
+	CircuitBreakerThreshold int
+	Database                string
+	DatabaseRetries         int
+	DatabaseRetryBackoff    time.Duration
+	DatabaseTimeout         time.Duration
+	AssetPath               string
+	Keyring                 string
+	MaxLength               int
+	MetricsPort             int
+	MigrationMode           string
+	SecondaryDatabase       string
+	TLSCert                 string
+	TLSKey                  string
+	ForceOneTimeSecrets     bool
+	CORSAllowOrigin         string
+	DisableUpload           bool
+	PrefetchSecret          bool
+	DisableFeatures         bool
+	NoLanguageSwitcher      bool
+	TrustedProxies          []string
+	WaitForDatabase         time.Duration
+	PrivacyNoticeURL        string
+	ImprintURL              string
+	AllowedExpirations      []int
+	BinaryPayloads          bool
+	MaxViews                int
+	WebhookAllowlist        []string
+	WebhookSecret           string
+	WebhookQueue            string
+	WebhookMaxAttempts      int
+	AuditSinks              []string
+	AuditTruncateIPs        bool
+
+	// Settings holds the values of the flags registered by database
+	// drivers.
+	Settings driver.Settings
 }
 
 func Load() (*Config, error) {
 	pflag.String("address", "", "listen address (default 0.0.0.0)")
 	pflag.Int("port", 1337, "listen port")
-	pflag.String("database", "memcached", "database backend ('memcached' or 'redis')")
+	pflag.String("database", "memcached", "database backend ("+strings.Join(driverNames(), ", ")+")")
+	pflag.Duration("database-timeout", 5*time.Second, "timeout of a single database operation (0 to disable)")
+	pflag.Int("database-retries", 2, "number of retries of a failed database write or status check")
+	pflag.Duration("database-retry-backoff", 50*time.Millisecond, "base backoff between database retries")
+	pflag.Int("circuit-breaker-threshold", 5, "consecutive database failures that open the circuit breaker (0 to disable)")
+	pflag.Duration("circuit-breaker-cooldown", 30*time.Second, "how long the circuit breaker stays open before the database is tried again")
+	pflag.Duration("wait-for-database", 0, "how long to wait for the database to become reachable on startup (0 to start right away)")
 	pflag.String("asset-path", "public", "path to the assets folder")
+	pflag.String("keyring", "", "path to a keyring file enabling encryption of secrets at rest")
 	pflag.Int("max-length", 5242880, "max length of encrypted secret")
-	pflag.String("memcached", "localhost:11211", "memcached address")
 	pflag.Int("metrics-port", -1, "metrics server listen port")
-	pflag.String("redis", "redis://localhost:6379/0", "Redis URL")
+	pflag.String("secondary-database", "", "second database backend used while migrating between databases")
+	pflag.String("migration-mode", "fallback", "how secrets are spread over the secondary database ('fallback' or 'mirror')")
 	pflag.String("tls-cert", "", "path to TLS certificate")
 	pflag.String("tls-key", "", "path to TLS key")
 	pflag.Bool("force-onetime-secrets", false, "reject non onetime secrets from being created")
@@ -51,6 +79,20 @@ func Load() (*Config, error) {
 	pflag.String("privacy-notice-url", "", "URL to privacy notice page")
 	pflag.String("imprint-url", "", "URL to imprint/legal notice page")
 	pflag.IntSlice("allowed-expirations", []int{3600, 86400, 604800}, "allowed expiration times in seconds")
+	pflag.Bool("binary-payloads", true, "store secrets as binary OpenPGP packets instead of ASCII armor")
+	pflag.Int("max-views", 10, "max number of views a secret can be created with (1 to allow one-time secrets only)")
+	pflag.StringSlice("webhook-allowlist", []string{}, "URL prefixes secrets may send notifications to (empty to disable notifications)")
+	pflag.String("webhook-secret", "", "key the notification webhooks are signed with")
+	pflag.String("webhook-queue", "webhooks.db", "path of the file queuing notification webhooks")
+	pflag.Int("webhook-max-attempts", 10, "how often a notification webhook is tried before it is dropped")
+	pflag.StringSlice("audit-sinks", []string{}, "where the audit log is written to: stdout, syslog or file:<path> (empty to disable the audit log)")
+	pflag.Bool("audit-truncate-ips", false, "log only the network of client IPs (/24 for IPv4, /48 for IPv6) in the audit log")
+
+	for _, d := range driver.Drivers() {
+		if d.Flags != nil {
+			d.Flags(pflag.CommandLine)
+		}
+	}
 
 	viper.SetEnvPrefix("yopass")
 	viper.AutomaticEnv()
@@ -62,25 +104,73 @@ func Load() (*Config, error) {
 	pflag.Parse()
 
 	return &Config{
-		Address:             viper.GetString("address"),
-		Port:                viper.GetInt("port"),
-		Database:            viper.GetString("database"),
-		AssetPath:           viper.GetString("asset-path"),
-		MaxLength:           viper.GetInt("max-length"),
-		Memcached:           viper.GetString("memcached"),
-		MetricsPort:         viper.GetInt("metrics-port"),
-		Redis:               viper.GetString("redis"),
-		TLSCert:             viper.GetString("tls-cert"),
-		TLSKey:              viper.GetString("tls-key"),
-		ForceOneTimeSecrets: viper.GetBool("force-onetime-secrets"),
-		CORSAllowOrigin:     viper.GetString("cors-allow-origin"),
-		DisableUpload:       viper.GetBool("disable-upload"),
-		PrefetchSecret:      viper.GetBool("prefetch-secret"),
-		DisableFeatures:     viper.GetBool("disable-features"),
-		NoLanguageSwitcher:  viper.GetBool("no-language-switcher"),
-		TrustedProxies:      viper.GetStringSlice("trusted-proxies"),
-		PrivacyNoticeURL:    viper.GetString("privacy-notice-url"),
-		ImprintURL:          viper.GetString("imprint-url"),
-		AllowedExpirations:  viper.GetIntSlice("allowed-expirations"),
+		Address:                 viper.GetString("address"),
+		Port:                    viper.GetInt("port"),
+		CircuitBreakerCooldown:  viper.GetDuration("circuit-breaker-cooldown"),
+		CircuitBreakerThreshold: viper.GetInt("circuit-breaker-threshold"),
+		Database:                viper.GetString("database"),
+		DatabaseRetries:         viper.GetInt("database-retries"),
+		DatabaseRetryBackoff:    viper.GetDuration("database-retry-backoff"),
+		DatabaseTimeout:         viper.GetDuration("database-timeout"),
+		AssetPath:               viper.GetString("asset-path"),
+		Keyring:                 viper.GetString("keyring"),
+		MaxLength:               viper.GetInt("max-length"),
+		MetricsPort:             viper.GetInt("metrics-port"),
+		MigrationMode:           viper.GetString("migration-mode"),
+		SecondaryDatabase:       viper.GetString("secondary-database"),
+		TLSCert:                 viper.GetString("tls-cert"),
+		TLSKey:                  viper.GetString("tls-key"),
+		ForceOneTimeSecrets:     viper.GetBool("force-onetime-secrets"),
+		CORSAllowOrigin:         viper.GetString("cors-allow-origin"),
+		DisableUpload:           viper.GetBool("disable-upload"),
+		PrefetchSecret:          viper.GetBool("prefetch-secret"),
+		DisableFeatures:         viper.GetBool("disable-features"),
+		NoLanguageSwitcher:      viper.GetBool("no-language-switcher"),
+		TrustedProxies:          viper.GetStringSlice("trusted-proxies"),
+		WaitForDatabase:         viper.GetDuration("wait-for-database"),
+		PrivacyNoticeURL:        viper.GetString("privacy-notice-url"),
+		ImprintURL:              viper.GetString("imprint-url"),
+		AllowedExpirations:      viper.GetIntSlice("allowed-expirations"),
+		BinaryPayloads:          viper.GetBool("binary-payloads"),
+		MaxViews:                viper.GetInt("max-views"),
+		WebhookAllowlist:        splitList(viper.GetStringSlice("webhook-allowlist")),
+		WebhookSecret:           viper.GetString("webhook-secret"),
+		WebhookQueue:            viper.GetString("webhook-queue"),
+		WebhookMaxAttempts:      viper.GetInt("webhook-max-attempts"),
+		AuditSinks:              splitList(viper.GetStringSlice("audit-sinks")),
+		AuditTruncateIPs:        viper.GetBool("audit-truncate-ips"),
+		Settings:                settings{viper.GetViper()},
 	}, nil
 }
+
+// settings exposes the driver flags. pflag splits lists given on the
+// command line, but environment variables arrive as a single comma
+// separated string, so lists are split once more.
+type settings struct {
+	*viper.Viper
+}
+
+func (s settings) GetStringSlice(key string) []string {
+	return splitList(s.Viper.GetStringSlice(key))
+}
+
+// driverNames lists the registered database drivers for the help text.
+func driverNames() []string {
+	var names []string
+	for _, d := range driver.Drivers() {
+		names = append(names, "'"+d.Name+"'")
+	}
+	return names
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
@@ -46,8 +46,8 @@ func TestConfigDefaults(t *testing.T) {
 	if cfg.Address != "" {
 		t.Errorf("Expected empty default address, got %s", cfg.Address)
 	}
-	if cfg.Redis != "redis://localhost:6379/0" {
-		t.Errorf("Expected default redis URL, got %s", cfg.Redis)
+	if redis := cfg.Settings.GetString("redis"); redis != "redis://localhost:6379/0" {
+		t.Errorf("Expected default redis URL, got %s", redis)
 	}
 	if cfg.CORSAllowOrigin != "*" {
 		t.Errorf("Expected default CORS allow origin *, got %s", cfg.CORSAllowOrigin)
@@ -56,3 +56,64 @@ func TestConfigDefaults(t *testing.T) {
 		t.Error("Expected PrefetchSecret to be true by default")
 	}
 }
+
+func TestLoadMemcachedNodesFromEnv(t *testing.T) {
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	viper.Reset()
+
+	os.Setenv("YOPASS_MEMCACHED", "memcached-1:11211, memcached-2:11211")
+	defer os.Unsetenv("YOPASS_MEMCACHED")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := []string{"memcached-1:11211", "memcached-2:11211"}
+	memcached := cfg.Settings.GetStringSlice("memcached")
+	if len(memcached) != len(want) || memcached[0] != want[0] || memcached[1] != want[1] {
+		t.Errorf("Expected Memcached %v, got %v", want, memcached)
+	}
+}
+
+func TestLoadWebhookAllowlistFromEnv(t *testing.T) {
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	viper.Reset()
+
+	os.Setenv("YOPASS_WEBHOOK_ALLOWLIST", "https://hooks.example.com/yopass/, https://chat.example.com/hooks/")
+	defer os.Unsetenv("YOPASS_WEBHOOK_ALLOWLIST")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := []string{"https://hooks.example.com/yopass/", "https://chat.example.com/hooks/"}
+	if len(cfg.WebhookAllowlist) != len(want) || cfg.WebhookAllowlist[0] != want[0] || cfg.WebhookAllowlist[1] != want[1] {
+		t.Errorf("Expected webhook allowlist %v, got %v", want, cfg.WebhookAllowlist)
+	}
+	if cfg.WebhookMaxAttempts != 10 {
+		t.Errorf("Expected default webhook max attempts 10, got %d", cfg.WebhookMaxAttempts)
+	}
+}
+
+func TestLoadAuditSinksFromEnv(t *testing.T) {
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	viper.Reset()
+
+	os.Setenv("YOPASS_AUDIT_SINKS", "file:/var/log/yopass/audit.log, syslog")
+	defer os.Unsetenv("YOPASS_AUDIT_SINKS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := []string{"file:/var/log/yopass/audit.log", "syslog"}
+	if len(cfg.AuditSinks) != len(want) || cfg.AuditSinks[0] != want[0] || cfg.AuditSinks[1] != want[1] {
+		t.Errorf("Expected audit sinks %v, got %v", want, cfg.AuditSinks)
+	}
+	if cfg.AuditTruncateIPs {
+		t.Error("Expected client IPs not to be truncated by default")
+	}
+}
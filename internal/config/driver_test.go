@@ -0,0 +1,55 @@
+package config_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/pkg/driver"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	// Registers the built-in database drivers, like the server does.
+	_ "github.com/Khovanskiy5/yopass/internal/repository"
+)
+
+func init() {
+	driver.Register(driver.Driver{
+		Name: "in-house",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("in-house-url", "in-house://localhost", "in-house database URL")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			return nil, nil
+		},
+	})
+}
+
+func TestLoadDriverFlags(t *testing.T) {
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	viper.Reset()
+
+	os.Setenv("YOPASS_IN_HOUSE_URL", "in-house://db.example.com")
+	defer os.Unsetenv("YOPASS_IN_HOUSE_URL")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if url := cfg.Settings.GetString("in-house-url"); url != "in-house://db.example.com" {
+		t.Errorf("Expected driver flag from environment, got %s", url)
+	}
+	if pflag.Lookup("memcached-timeout") == nil {
+		t.Error("Expected flags of built-in drivers to be registered")
+	}
+
+	usage := pflag.Lookup("database").Usage
+	for _, name := range []string{"dynamodb", "file", "in-house", "memcached", "memory", "postgres", "redis"} {
+		if !strings.Contains(usage, "'"+name+"'") {
+			t.Errorf("Expected --database help to list %s, got %q", name, usage)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/constants"
+	"github.com/Khovanskiy5/yopass/internal/utils"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -21,6 +22,18 @@ func CORS(allowOrigin string) mux.MiddlewareFunc {
 	}
 }
 
+// ClientIP returns a middleware which puts the IP of the client into the
+// request context, taken from X-Forwarded-For for requests from one of
+// trustedProxies.
+func ClientIP(trustedProxies []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := utils.GetRealClientIP(r, trustedProxies)
+			next.ServeHTTP(w, r.WithContext(utils.WithClientIP(r.Context(), ip)))
+		})
+	}
+}
+
 // SecurityHeaders returns a middleware which sets common security
 // HTTP headers on the response to mitigate common web vulnerabilities.
 func SecurityHeaders(next http.Handler) http.Handler {
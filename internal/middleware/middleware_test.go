@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/Khovanskiy5/yopass/internal/utils"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -25,6 +26,27 @@ func TestCORS(t *testing.T) {
 	}
 }
 
+func TestClientIP(t *testing.T) {
+	var got string
+	handler := ClientIP([]string{"10.0.0.0/8"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = utils.ClientIP(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "1.2.3.4" {
+		t.Errorf("expected client IP 1.2.3.4 from the trusted proxy, got %q", got)
+	}
+
+	req.RemoteAddr = "5.6.7.8:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "5.6.7.8" {
+		t.Errorf("expected client IP 5.6.7.8, got %q", got)
+	}
+}
+
 func TestSecurityHeaders(t *testing.T) {
 	handler := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
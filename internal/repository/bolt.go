@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltCompactionInterval is how often expired secrets are purged from disk.
+const boltCompactionInterval = time.Minute
+
+var (
+	// boltSecrets maps secret keys to their JSON encoded boltRecord.
+	boltSecrets = []byte("secrets")
+	// boltExpiry indexes secrets by expiration time. Its keys are the
+	// big-endian expiration timestamp followed by the secret key, so expired
+	// secrets can be found with a single ordered scan.
+	boltExpiry = []byte("expiry")
+)
+
+// boltRecord keeps the message as bytes in Payload, since JSON can't
+// carry binary strings, and everything else in Metadata.
+type boltRecord struct {
+	Payload   []byte          `json:"payload,omitempty"`
+	Metadata  domain.Metadata `json:"metadata"`
+	ExpiresAt int64           `json:"expires_at,omitempty"`
+}
+
+func newBoltRecord(secret domain.Secret, now time.Time) boltRecord {
+	r := boltRecord{Payload: []byte(secret.Message), Metadata: domain.NewMetadata(secret, now)}
+	if secret.Expiration > 0 {
+		r.ExpiresAt = now.Unix() + int64(secret.Expiration)
+	}
+	return r
+}
+
+func (r boltRecord) expired(now time.Time) bool {
+	return r.ExpiresAt != 0 && now.Unix() >= r.ExpiresAt
+}
+
+// Bolt stores secrets in an embedded bbolt database on local disk, so they
+// survive restarts. Every write is a transaction that is synced to disk
+// before it returns. Transactions can't be interrupted, so the context is
+// only checked before one is started.
+type Bolt struct {
+	db  *bolt.DB
+	now func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBolt opens, or creates, the database in dataDir and starts a background
+// compaction of expired secrets until Close is called.
+func NewBolt(dataDir string) (domain.Repository, error) {
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	db, err := bolt.Open(filepath.Join(dataDir, "yopass.db"), 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltSecrets, boltExpiry} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	b := &Bolt{db: db, now: time.Now, done: make(chan struct{})}
+	go runJanitor(boltCompactionInterval, b.done, func() { _ = b.compact() })
+	return b, nil
+}
+
+func (b *Bolt) Get(ctx context.Context, key string) (domain.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.Secret{}, err
+	}
+	var s domain.Secret
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		r, err := b.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		if r.Metadata.Consumed() {
+			return domain.ErrNotFound
+		}
+		meta, last := r.Metadata.Viewed(b.now())
+		s = meta.Secret(string(r.Payload))
+		switch {
+		case last && !meta.KeepReceipt():
+			return b.remove(tx, key, r)
+		case last:
+			r.Payload = nil
+		case meta == r.Metadata:
+			return nil
+		}
+		r.Metadata = meta
+		return b.store(tx, key, r)
+	})
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	return s, nil
+}
+
+func (b *Bolt) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.Secret{}, err
+	}
+	var s domain.Secret
+	err := b.db.View(func(tx *bolt.Tx) error {
+		r, err := b.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		if r.Metadata.Consumed() {
+			return domain.ErrNotFound
+		}
+		s = r.Metadata.Secret(string(r.Payload))
+		return nil
+	})
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	return s, nil
+}
+
+func (b *Bolt) Put(ctx context.Context, key string, secret domain.Secret) error {
+	r := newBoltRecord(secret, b.now())
+	data, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// The old record may have expired without being compacted yet, its
+		// index entry must go all the same.
+		if old, err := b.record(tx, key); err == nil {
+			if err := tx.Bucket(boltExpiry).Delete(expiryKey(old.ExpiresAt, key)); err != nil {
+				return err
+			}
+		} else if err != domain.ErrNotFound {
+			return err
+		}
+		if err := tx.Bucket(boltSecrets).Put([]byte(key), data); err != nil {
+			return err
+		}
+		if r.ExpiresAt == 0 {
+			return nil
+		}
+		return tx.Bucket(boltExpiry).Put(expiryKey(r.ExpiresAt, key), nil)
+	})
+}
+
+func (b *Bolt) Delete(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	deleted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		r, err := b.lookup(tx, key)
+		if err == domain.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deleted = true
+		return b.remove(tx, key, r)
+	})
+	return deleted, err
+}
+
+func (b *Bolt) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return domain.Metadata{}, err
+	}
+	var meta domain.Metadata
+	err := b.db.View(func(tx *bolt.Tx) error {
+		r, err := b.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		meta = r.Metadata
+		return nil
+	})
+	return meta, err
+}
+
+func (b *Bolt) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		r, err := b.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		if r.Metadata.Consumed() {
+			return domain.ErrNotFound
+		}
+		if r.ExpiresAt != 0 {
+			if err := tx.Bucket(boltExpiry).Delete(expiryKey(r.ExpiresAt, key)); err != nil {
+				return err
+			}
+		}
+		r.Metadata.ExpiresAt = expiresAt.UTC().Truncate(time.Second)
+		r.ExpiresAt = r.Metadata.ExpiresAt.Unix()
+		if err := tx.Bucket(boltExpiry).Put(expiryKey(r.ExpiresAt, key), nil); err != nil {
+			return err
+		}
+		return b.store(tx, key, r)
+	})
+}
+
+// Ping checks that the database is still open.
+func (b *Bolt) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Close stops the background compaction and closes the database.
+func (b *Bolt) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.db.Close()
+	})
+	return err
+}
+
+// lookup decodes the record stored for key. Expired records are reported
+// as missing, they are removed by the next compaction.
+func (b *Bolt) lookup(tx *bolt.Tx, key string) (boltRecord, error) {
+	r, err := b.record(tx, key)
+	if err != nil {
+		return r, err
+	}
+	if r.expired(b.now()) {
+		return r, domain.ErrNotFound
+	}
+	return r, nil
+}
+
+// record decodes the record stored for key, whether it has expired or not.
+func (b *Bolt) record(tx *bolt.Tx, key string) (boltRecord, error) {
+	var r boltRecord
+	data := tx.Bucket(boltSecrets).Get([]byte(key))
+	if data == nil {
+		return r, domain.ErrNotFound
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// store replaces the record of key, keeping its expiry index entry.
+func (b *Bolt) store(tx *bolt.Tx, key string, r boltRecord) error {
+	data, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltSecrets).Put([]byte(key), data)
+}
+
+func (b *Bolt) remove(tx *bolt.Tx, key string, r boltRecord) error {
+	if err := tx.Bucket(boltSecrets).Delete([]byte(key)); err != nil {
+		return err
+	}
+	if r.ExpiresAt == 0 {
+		return nil
+	}
+	return tx.Bucket(boltExpiry).Delete(expiryKey(r.ExpiresAt, key))
+}
+
+// compact removes every secret whose expiration has passed.
+func (b *Bolt) compact() error {
+	limit := expiryKey(b.now().Unix()+1, "")
+	return b.db.Update(func(tx *bolt.Tx) error {
+		secrets, expiry := tx.Bucket(boltSecrets), tx.Bucket(boltExpiry)
+
+		// Deleting while iterating can make a bbolt cursor skip entries,
+		// so collect the expired index keys first.
+		var expired [][]byte
+		c := expiry.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.Next() {
+			expired = append(expired, bytes.Clone(k))
+		}
+		for _, k := range expired {
+			// A stale index entry must not take a newer secret stored
+			// under the same key with it. A record that can't be decoded
+			// can't be read either, so it goes rather than failing every
+			// compaction from now on.
+			r, err := b.record(tx, string(k[8:]))
+			if err != domain.ErrNotFound && (err != nil || r.ExpiresAt == int64(binary.BigEndian.Uint64(k))) {
+				if err := secrets.Delete(k[8:]); err != nil {
+					return err
+				}
+			}
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func expiryKey(expiresAt int64, key string) []byte {
+	k := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expiresAt))
+	copy(k[8:], key)
+	return k
+}
@@ -0,0 +1,419 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBolt(t *testing.T, dir string) *Bolt {
+	t.Helper()
+	db, err := NewBolt(dir)
+	if err != nil {
+		t.Fatalf("error in NewBolt(): %v", err)
+	}
+	b := db.(*Bolt)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestBolt(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
+	secret := domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}
+
+	if err := b.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	meta, err := b.Status(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if !meta.OneTime {
+		t.Fatal("expected OneTime to be true")
+	}
+
+	storedSecret, err := b.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if storedSecret.Message != secret.Message {
+		t.Fatalf("expected value %s, got %s", secret.Message, storedSecret.Message)
+	}
+
+	if _, err := b.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get() after one-time read, got %v", err)
+	}
+}
+
+func TestBoltDelete(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	key := "test-delete"
+	if err := b.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	deleted, err := b.Delete(ctx, key)
+	if err != nil || !deleted {
+		t.Fatalf("expected Delete() to remove the secret, got %v, %v", deleted, err)
+	}
+	deleted, err = b.Delete(ctx, key)
+	if err != nil || deleted {
+		t.Fatalf("expected second Delete() to report nothing deleted, got %v, %v", deleted, err)
+	}
+	if _, err := b.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status() after Delete(), got %v", err)
+	}
+}
+
+func TestBoltPersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b := newTestBolt(t, dir)
+	if err := b.Put(ctx, "persistent", domain.Secret{Message: "foo", Expiration: 604800}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("error in Close(): %v", err)
+	}
+
+	b = newTestBolt(t, dir)
+	s, err := b.Get(ctx, "persistent")
+	if err != nil {
+		t.Fatalf("error in Get() after reopening: %v", err)
+	}
+	if s.Message != "foo" {
+		t.Fatalf("expected value foo, got %s", s.Message)
+	}
+}
+
+func TestBoltExpiration(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	if err := b.Put(ctx, "expiring", domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	now = now.Add(59 * time.Second)
+	if _, err := b.Get(ctx, "expiring"); err != nil {
+		t.Fatalf("expected secret to be readable before expiration, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := b.Get(ctx, "expiring"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after expiration, got %v", err)
+	}
+	if _, err := b.Status(ctx, "expiring"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status() after expiration, got %v", err)
+	}
+}
+
+func TestBoltCompact(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := b.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+	}
+	if err := b.Put(ctx, "d", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := b.Put(ctx, "e", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	// Overwriting a secret must drop its previous expiry index entry.
+	if err := b.Put(ctx, "a", domain.Secret{Message: "bar", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := b.compact(); err != nil {
+		t.Fatalf("error in compact(): %v", err)
+	}
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(boltSecrets).Stats().KeyN; n != 3 {
+			t.Errorf("expected 3 secrets after compaction, got %d", n)
+		}
+		if n := tx.Bucket(boltExpiry).Stats().KeyN; n != 2 {
+			t.Errorf("expected 2 expiry index entries after compaction, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "d", "e"} {
+		if _, err := b.Get(ctx, key); err != nil {
+			t.Errorf("expected %s to survive compaction, got %v", key, err)
+		}
+	}
+}
+
+func TestBoltCompactReplacedExpired(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	if err := b.Put(ctx, "a", domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	// The secret expires, but is replaced before it is compacted.
+	now = now.Add(2 * time.Minute)
+	if err := b.Put(ctx, "a", domain.Secret{Message: "bar", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := b.compact(); err != nil {
+		t.Fatalf("error in compact(): %v", err)
+	}
+
+	s, err := b.Get(ctx, "a")
+	if err != nil {
+		t.Fatalf("expected the new secret to survive compaction, got %v", err)
+	}
+	if s.Message != "bar" {
+		t.Errorf("expected message bar, got %q", s.Message)
+	}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(boltExpiry).Stats().KeyN; n != 1 {
+			t.Errorf("expected 1 expiry index entry, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compaction also leaves the secret alone if a stale entry is left.
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltExpiry).Put(expiryKey(now.Unix()-60, "a"), nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.compact(); err != nil {
+		t.Fatalf("error in compact(): %v", err)
+	}
+	if _, err := b.Status(ctx, "a"); err != nil {
+		t.Errorf("expected the secret to survive a stale index entry, got %v", err)
+	}
+}
+
+func TestBoltCompactCorruptRecord(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	for _, key := range []string{"corrupt", "expired"} {
+		if err := b.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltSecrets).Put([]byte("corrupt"), []byte("{not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := b.compact(); err != nil {
+		t.Fatalf("error in compact(): %v", err)
+	}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(boltSecrets).Stats().KeyN; n != 0 {
+			t.Errorf("expected the corrupt and expired secrets to be removed, got %d secrets", n)
+		}
+		if n := tx.Bucket(boltExpiry).Stats().KeyN; n != 0 {
+			t.Errorf("expected no expiry index entries, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltConcurrentOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	const readers = 50
+	for i := 0; i < 5; i++ {
+		key := "test-concurrent-onetime"
+		if err := b.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+
+		var wins int32
+		var wg sync.WaitGroup
+		for j := 0; j < readers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := b.Get(ctx, key); err == nil {
+					atomic.AddInt32(&wins, 1)
+				} else if err != domain.ErrNotFound {
+					t.Errorf("unexpected error in Get(): %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+		}
+	}
+}
+
+func TestBoltMaxViews(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	key := "test-max-views"
+	if err := b.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := b.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		if meta, err := b.Status(ctx, key); err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+	}
+	if _, err := b.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestBoltConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := b.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestBoltBinaryMessage(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	message := "yopass:binary:\x00\xff\xfe packets"
+	if err := b.Put(ctx, "binary", domain.Secret{Message: message, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	s, err := b.Get(ctx, "binary")
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != message {
+		t.Fatalf("expected binary message to be kept intact, got %q", s.Message)
+	}
+}
+
+func TestBoltStatus(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	if err := b.Put(ctx, "key", domain.Secret{Message: "foo", OneTime: true, Expiration: 3600, Kind: domain.KindFile}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	meta, err := b.Status(ctx, "key")
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	want := domain.Metadata{OneTime: true, Size: 3, Kind: domain.KindFile, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if meta != want {
+		t.Fatalf("expected %+v, got %+v", want, meta)
+	}
+}
+
+func TestBoltContextCanceled(t *testing.T) {
+	b := newTestBolt(t, t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := b.Put(ctx, "key", domain.Secret{Message: "foo"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Put() to honor a canceled context, got %v", err)
+	}
+}
+
+func TestBoltPing(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBolt(t, t.TempDir())
+
+	if err := b.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+	b.Close()
+	if err := b.Ping(ctx); err == nil {
+		t.Fatal("expected Ping() to fail after Close()")
+	}
+}
+
+func TestBoltReceipt(t *testing.T) {
+	testRepositoryReceipt(t, func(t *testing.T) domain.Repository {
+		return newTestBolt(t, t.TempDir())
+	})
+}
+
+func TestBoltExtend(t *testing.T) {
+	testRepositoryExtend(t, newTestBolt(t, t.TempDir()))
+}
+
+func TestBoltPeek(t *testing.T) {
+	testRepositoryPeek(t, newTestBolt(t, t.TempDir()))
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/pkg/driver"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// init registers the built-in database backends.
+func init() {
+	driver.Register(driver.Driver{
+		Name: "memcached",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.StringSlice("memcached", []string{"localhost:11211"}, "memcached node addresses")
+			fs.Duration("memcached-timeout", 100*time.Millisecond, "memcached connect, read and write timeout")
+			fs.Int("memcached-max-idle-conns", 2, "max idle connections kept per memcached node")
+			fs.Duration("memcached-retry-interval", 10*time.Second, "how long a failed memcached node is skipped before it is retried")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			nodes := s.GetStringSlice("memcached")
+			opts.Logger.Debug("Configuring Memcached", zap.Strings("nodes", nodes))
+			return NewMemcached(nodes, MemcachedOptions{
+				Timeout:       s.GetDuration("memcached-timeout"),
+				MaxIdleConns:  s.GetInt("memcached-max-idle-conns"),
+				RetryInterval: s.GetDuration("memcached-retry-interval"),
+				Logger:        opts.Logger,
+				Registerer:    opts.Registerer,
+			})
+		},
+	})
+
+	driver.Register(driver.Driver{
+		Name: "redis",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("redis", "redis://localhost:6379/0", "Redis URL")
+			fs.String("redis-mode", "standalone", "Redis deployment ('standalone', 'sentinel' or 'cluster')")
+			fs.StringSlice("redis-addrs", []string{}, "Redis sentinel or cluster node addresses")
+			fs.String("redis-master-name", "", "Redis sentinel master name")
+		},
+		Open: openRedis,
+	})
+
+	driver.Register(driver.Driver{
+		Name: "postgres",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("postgres", "postgres://localhost:5432/yopass", "PostgreSQL connection string (DSN)")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			opts.Logger.Debug("Configuring PostgreSQL")
+			return NewPostgres(s.GetString("postgres"))
+		},
+	})
+
+	driver.Register(driver.Driver{
+		Name: "dynamodb",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("dynamodb-table", "yopass", "DynamoDB table name")
+			fs.String("dynamodb-region", "", "DynamoDB region (default from the AWS environment)")
+			fs.String("dynamodb-endpoint", "", "DynamoDB endpoint URL, e.g. of DynamoDB Local")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			table := s.GetString("dynamodb-table")
+			opts.Logger.Debug("Configuring DynamoDB", zap.String("table", table))
+			return NewDynamo(table, DynamoOptions{
+				Region:   s.GetString("dynamodb-region"),
+				Endpoint: s.GetString("dynamodb-endpoint"),
+			})
+		},
+	})
+
+	driver.Register(driver.Driver{
+		Name: "memory",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.Int("memory-max-secrets", 0, "max number of secrets kept by the memory database (0 for unlimited)")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			maxSecrets := s.GetInt("memory-max-secrets")
+			opts.Logger.Debug("Configuring in-memory database", zap.Int("maxSecrets", maxSecrets))
+			return NewMemory(maxSecrets), nil
+		},
+	})
+
+	driver.Register(driver.Driver{
+		Name: "file",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("data-dir", "data", "directory of the file database")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			dataDir := s.GetString("data-dir")
+			opts.Logger.Debug("Configuring file database", zap.String("dataDir", dataDir))
+			return NewBolt(dataDir)
+		},
+	})
+}
+
+func openRedis(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+	url := s.GetString("redis")
+	addrs := s.GetStringSlice("redis-addrs")
+	switch mode := s.GetString("redis-mode"); mode {
+	case "", "standalone":
+		opts.Logger.Debug("Configuring Redis", zap.String("url", url))
+		return NewRedis(url)
+	case "sentinel":
+		master := s.GetString("redis-master-name")
+		opts.Logger.Debug("Configuring Redis Sentinel", zap.String("master", master), zap.Strings("sentinels", addrs))
+		return NewRedisSentinel(url, master, addrs)
+	case "cluster":
+		opts.Logger.Debug("Configuring Redis Cluster", zap.Strings("nodes", addrs))
+		return NewRedisCluster(url, addrs)
+	default:
+		return nil, fmt.Errorf("unsupported redis mode: %s", mode)
+	}
+}
@@ -0,0 +1,405 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dynamoLive restricts a condition to items that haven't expired yet.
+// DynamoDB removes items past their ttl lazily, often days later, so they
+// have to be filtered on every read.
+const dynamoLive = `(attribute_not_exists(#ttl) OR #ttl > :now)`
+
+// DynamoOptions configures a Dynamo repository.
+type DynamoOptions struct {
+	// Region overrides the region from the AWS environment.
+	Region string
+	// Endpoint overrides the DynamoDB endpoint, e.g. to use DynamoDB Local.
+	Endpoint string
+}
+
+// Dynamo stores secrets in a DynamoDB table with the string partition key
+// id. Expired items are removed by DynamoDB's time to live on the ttl
+// attribute, which has to be enabled on the table.
+type Dynamo struct {
+	table string
+	svc   *dynamodb.DynamoDB
+	now   func() time.Time
+}
+
+// NewDynamo returns a repository backed by table. Credentials and, unless
+// set in opts, the region are taken from the AWS environment.
+func NewDynamo(table string, opts DynamoOptions) (domain.Repository, error) {
+	if table == "" {
+		return nil, errors.New("no DynamoDB table specified")
+	}
+	cfg := aws.NewConfig()
+	if opts.Region != "" {
+		cfg = cfg.WithRegion(opts.Region)
+	}
+	if opts.Endpoint != "" {
+		cfg = cfg.WithEndpoint(opts.Endpoint)
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Dynamo{table: table, svc: dynamodb.New(sess), now: time.Now}, nil
+}
+
+// Get returns the secret stored under key and records its first view.
+// One-time secrets lose their message to a conditional update that returns
+// the old item, so of several concurrent readers only one gets the secret.
+// Views of multi-view secrets are counted down by a conditional update in
+// the same way. Consumed items are deleted, unless they stay as a receipt
+// of the view for the creator of the secret.
+func (d *Dynamo) Get(ctx context.Context, key string) (domain.Secret, error) {
+	out, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                           aws.String(d.table),
+		Key:                                 dynamoKey(key),
+		UpdateExpression:                    aws.String("SET viewed_at = :now REMOVE secret"),
+		ConditionExpression:                 aws.String("one_time = :true AND attribute_not_exists(viewed_at) AND " + dynamoLive),
+		ExpressionAttributeNames:            dynamoTTLName(),
+		ExpressionAttributeValues:           d.liveValues(map[string]*dynamodb.AttributeValue{":true": {BOOL: aws.Bool(true)}}),
+		ReturnValues:                        aws.String(dynamodb.ReturnValueAllOld),
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	})
+	if err == nil {
+		if !dynamoReceipt(out.Attributes) {
+			d.remove(ctx, key, "attribute_exists(viewed_at)", nil)
+		}
+		return dynamoSecret(out.Attributes), nil
+	}
+
+	// The condition fails for missing, expired, consumed, regular and
+	// multi-view secrets. Regular ones are served from the item attached
+	// to the error, multi-view ones once their view is counted.
+	var failed *dynamodb.ConditionalCheckFailedException
+	if !errors.As(err, &failed) {
+		return domain.Secret{}, err
+	}
+	if len(failed.Item) == 0 || d.expired(failed.Item) || dynamoConsumed(failed.Item) {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	s := dynamoSecret(failed.Item)
+	if s.OneTime {
+		// It expired between the condition check and now.
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	if s.MaxViews > 0 {
+		return d.view(ctx, key)
+	}
+	if _, ok := failed.Item["viewed_at"]; !ok {
+		_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(d.table),
+			Key:                       dynamoKey(key),
+			UpdateExpression:          aws.String("SET viewed_at = if_not_exists(viewed_at, :now)"),
+			ConditionExpression:       aws.String(dynamoLive),
+			ExpressionAttributeNames:  dynamoTTLName(),
+			ExpressionAttributeValues: d.liveValues(map[string]*dynamodb.AttributeValue{}),
+		})
+		if errors.As(err, &failed) {
+			return domain.Secret{}, domain.ErrNotFound
+		}
+		if err != nil {
+			return domain.Secret{}, err
+		}
+	}
+	return s, nil
+}
+
+// view counts a view of the multi-view secret stored under key. After its
+// last view the item is treated as consumed, and its message or the whole
+// item removed.
+func (d *Dynamo) view(ctx context.Context, key string) (domain.Secret, error) {
+	out, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       dynamoKey(key),
+		UpdateExpression:          aws.String("SET remaining_views = remaining_views - :one, viewed_at = if_not_exists(viewed_at, :now)"),
+		ConditionExpression:       aws.String("remaining_views > :zero AND " + dynamoLive),
+		ExpressionAttributeNames:  dynamoTTLName(),
+		ExpressionAttributeValues: d.liveValues(map[string]*dynamodb.AttributeValue{":one": dynamoNumber(1), ":zero": dynamoNumber(0)}),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		var failed *dynamodb.ConditionalCheckFailedException
+		if errors.As(err, &failed) {
+			return domain.Secret{}, domain.ErrNotFound
+		}
+		return domain.Secret{}, err
+	}
+
+	s := dynamoSecret(out.Attributes)
+	if s.RemainingViews == 0 {
+		zero := map[string]*dynamodb.AttributeValue{":zero": dynamoNumber(0)}
+		if dynamoReceipt(out.Attributes) {
+			// Best effort, the consumed item can't be read either way.
+			_, _ = d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(d.table),
+				Key:                       dynamoKey(key),
+				UpdateExpression:          aws.String("REMOVE secret"),
+				ConditionExpression:       aws.String("remaining_views = :zero"),
+				ExpressionAttributeValues: zero,
+			})
+		} else {
+			d.remove(ctx, key, "remaining_views = :zero", zero)
+		}
+	}
+	return s, nil
+}
+
+// remove deletes the consumed item stored under key if condition holds.
+// It is best effort, DynamoDB's time to live catches the item otherwise.
+func (d *Dynamo) remove(ctx context.Context, key, condition string, values map[string]*dynamodb.AttributeValue) {
+	_, _ = d.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       dynamoKey(key),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+}
+
+func (d *Dynamo) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	out, err := d.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            dynamoKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	if len(out.Item) == 0 || d.expired(out.Item) || dynamoConsumed(out.Item) {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	return dynamoSecret(out.Item), nil
+}
+
+func (d *Dynamo) Put(ctx context.Context, key string, secret domain.Secret) error {
+	meta := domain.NewMetadata(secret, d.now())
+	item := map[string]*dynamodb.AttributeValue{
+		"id":       {S: aws.String(key)},
+		"secret":   {B: []byte(secret.Message)},
+		"one_time": {BOOL: aws.Bool(secret.OneTime)},
+		"size":     dynamoNumber(int64(meta.Size)),
+		"kind":     {S: aws.String(meta.Kind)},
+		"created":  dynamoNumber(meta.CreatedAt.Unix()),
+	}
+	if meta.TokenHash != "" {
+		item["token_hash"] = &dynamodb.AttributeValue{S: aws.String(meta.TokenHash)}
+	}
+	if meta.NotifyURL != "" {
+		item["notify_url"] = &dynamodb.AttributeValue{S: aws.String(meta.NotifyURL)}
+	}
+	if meta.MaxViews > 0 {
+		item["max_views"] = dynamoNumber(int64(meta.MaxViews))
+		item["remaining_views"] = dynamoNumber(int64(meta.RemainingViews))
+	}
+	if secret.Expiration > 0 {
+		item["ttl"] = dynamoNumber(meta.ExpiresAt.Unix())
+	}
+	_, err := d.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	})
+	return err
+}
+
+func (d *Dynamo) Delete(ctx context.Context, key string) (bool, error) {
+	out, err := d.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(d.table),
+		Key:          dynamoKey(key),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(out.Attributes) > 0 && !d.expired(out.Attributes), nil
+}
+
+// Status reads the metadata attributes only, also of receipts left behind
+// by the last view of a secret. Items stored by earlier versions have no
+// size attribute, their size is read from the message.
+func (d *Dynamo) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	item, err := d.status(ctx, key, "one_time, #ttl, size, kind, created, max_views, remaining_views, token_hash, viewed_at, notify_url")
+	if err == nil {
+		if _, ok := item["size"]; !ok {
+			item, err = d.status(ctx, key, "one_time, #ttl, secret, max_views, remaining_views, token_hash, viewed_at, notify_url")
+		}
+	}
+	if err != nil {
+		return domain.Metadata{}, err
+	}
+	return dynamoMetadata(item), nil
+}
+
+func (d *Dynamo) status(ctx context.Context, key, projection string) (map[string]*dynamodb.AttributeValue, error) {
+	out, err := d.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      dynamoKey(key),
+		ProjectionExpression:     aws.String(projection),
+		ExpressionAttributeNames: dynamoTTLName(),
+		ConsistentRead:           aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 || d.expired(out.Item) {
+		return nil, domain.ErrNotFound
+	}
+	return out.Item, nil
+}
+
+// Extend moves the expiry of the secret stored under key, as long as it
+// hasn't been consumed.
+func (d *Dynamo) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       dynamoKey(key),
+		UpdateExpression:          aws.String("SET #ttl = :ttl"),
+		ConditionExpression:       aws.String("attribute_exists(secret) AND " + dynamoLive),
+		ExpressionAttributeNames:  dynamoTTLName(),
+		ExpressionAttributeValues: d.liveValues(map[string]*dynamodb.AttributeValue{":ttl": dynamoNumber(expiresAt.Unix())}),
+	})
+	var failed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return domain.ErrNotFound
+	}
+	return err
+}
+
+// Ping checks that the table exists and is reachable.
+func (d *Dynamo) Ping(ctx context.Context) error {
+	_, err := d.svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(d.table),
+	})
+	return err
+}
+
+// expired reports whether item has expired.
+func (d *Dynamo) expired(item map[string]*dynamodb.AttributeValue) bool {
+	ttl, ok := item["ttl"]
+	if !ok || ttl.N == nil {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(*ttl.N, 10, 64)
+	return err == nil && d.now().Unix() >= expiresAt
+}
+
+// liveValues adds the current time used by dynamoLive to values.
+func (d *Dynamo) liveValues(values map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	values[":now"] = dynamoNumber(d.now().Unix())
+	return values
+}
+
+// dynamoConsumed reports whether the last view of item has been read.
+func dynamoConsumed(item map[string]*dynamodb.AttributeValue) bool {
+	if v, ok := item["remaining_views"]; ok && dynamoInt(v) <= 0 {
+		return true
+	}
+	_, viewed := item["viewed_at"]
+	return viewed && dynamoSecret(item).OneTime
+}
+
+// dynamoReceipt reports whether item stays as a receipt after its last
+// view, see domain.Metadata.KeepReceipt.
+func dynamoReceipt(item map[string]*dynamodb.AttributeValue) bool {
+	_, ok := item["ttl"]
+	return ok && dynamoMetadata(item).TokenHash != ""
+}
+
+func dynamoKey(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"id": {S: aws.String(key)}}
+}
+
+// dynamoTTLName maps #ttl to the ttl attribute. TTL is a reserved word in
+// DynamoDB expressions.
+func dynamoTTLName() map[string]*string {
+	return map[string]*string{"#ttl": aws.String("ttl")}
+}
+
+func dynamoNumber(n int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+}
+
+// dynamoInt decodes a number attribute, or returns zero if it is not one.
+func dynamoInt(v *dynamodb.AttributeValue) int64 {
+	n, _ := strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+	return n
+}
+
+// dynamoSecret decodes item. Attributes left out by a projection are
+// zero. The message is stored as binary, since string attributes have to
+// be valid UTF-8, or as a string by earlier versions. The expiration is the
+// lifetime from creation to the TTL, so it is zero for items stored without
+// their creation time.
+func dynamoSecret(item map[string]*dynamodb.AttributeValue) domain.Secret {
+	var s domain.Secret
+	if v, ok := item["secret"]; ok {
+		if v.B != nil {
+			s.Message = string(v.B)
+		} else {
+			s.Message = aws.StringValue(v.S)
+		}
+	}
+	if v, ok := item["one_time"]; ok {
+		s.OneTime = aws.BoolValue(v.BOOL)
+	}
+	if v, ok := item["max_views"]; ok {
+		s.MaxViews = int(dynamoInt(v))
+	}
+	if v, ok := item["remaining_views"]; ok {
+		s.RemainingViews = int(dynamoInt(v))
+	}
+	if v, ok := item["kind"]; ok {
+		s.Kind = aws.StringValue(v.S)
+	}
+	if v, ok := item["notify_url"]; ok {
+		s.NotifyURL = aws.StringValue(v.S)
+	}
+	ttl, hasTTL := item["ttl"]
+	created, hasCreated := item["created"]
+	if hasTTL && hasCreated {
+		s.Expiration = int32(dynamoInt(ttl) - dynamoInt(created))
+	}
+	return s
+}
+
+// dynamoMetadata decodes the metadata attributes of item, or of the
+// message for items stored without them.
+func dynamoMetadata(item map[string]*dynamodb.AttributeValue) domain.Metadata {
+	s := dynamoSecret(item)
+	m := domain.Metadata{
+		OneTime:        s.OneTime,
+		MaxViews:       s.MaxViews,
+		RemainingViews: s.RemainingViews,
+		Size:           len(s.Message),
+		Kind:           s.Kind,
+		NotifyURL:      s.NotifyURL,
+	}
+	if m.Kind == "" {
+		m.Kind = domain.KindMessage
+	}
+	if v, ok := item["size"]; ok {
+		m.Size = int(dynamoInt(v))
+	}
+	if v, ok := item["created"]; ok {
+		m.CreatedAt = time.Unix(dynamoInt(v), 0).UTC()
+	}
+	if v, ok := item["ttl"]; ok {
+		m.ExpiresAt = time.Unix(dynamoInt(v), 0).UTC()
+	}
+	if v, ok := item["token_hash"]; ok {
+		m.TokenHash = aws.StringValue(v.S)
+	}
+	if v, ok := item["viewed_at"]; ok {
+		m.ViewedAt = time.Unix(dynamoInt(v), 0).UTC()
+	}
+	return m
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newTestDynamo creates a fresh table in the DynamoDB Local instance at
+// DYNAMODB_ENDPOINT and removes it when the test ends.
+func newTestDynamo(t *testing.T) *Dynamo {
+	t.Helper()
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("Specify DYNAMODB_ENDPOINT env variable to test DynamoDB database")
+	}
+	// DynamoDB Local accepts any credentials, but the SDK needs some.
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		t.Setenv("AWS_ACCESS_KEY_ID", "local")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "local")
+	}
+
+	table := fmt.Sprintf("yopass-test-%d", time.Now().UnixNano())
+	db, err := NewDynamo(table, DynamoOptions{Region: "us-east-1", Endpoint: endpoint})
+	if err != nil {
+		t.Fatalf("error in NewDynamo(): %v", err)
+	}
+	d := db.(*Dynamo)
+
+	ctx := context.Background()
+	_, err = d.svc.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("id"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("id"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if err != nil {
+		t.Fatalf("error creating table: %v", err)
+	}
+	t.Cleanup(func() {
+		d.svc.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+	return d
+}
+
+func TestDynamo(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	if err := d.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
+	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
+	secret := domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}
+	if err := d.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	meta, err := d.Status(ctx, key)
+	if err != nil || !meta.OneTime {
+		t.Fatalf("expected Status() of one-time secret, got %v, %v", meta.OneTime, err)
+	}
+	if meta.Size != len(secret.Message) || meta.Kind != domain.KindMessage || meta.ExpiresAt.Sub(meta.CreatedAt) != time.Hour {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	s, err := d.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != secret.Message || !s.OneTime || s.Expiration != secret.Expiration {
+		t.Fatalf("expected one-time secret %s, got %+v", secret.Message, s)
+	}
+	if _, err := d.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for consumed secret, got %v", err)
+	}
+
+	key = "b2cbe5a8-8a5a-4d6c-9d1c-4c0ad6a1e7a3"
+	if err := d.Put(ctx, key, domain.Secret{Message: "bar", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		s, err := d.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "bar" || s.OneTime {
+			t.Fatalf("expected regular secret bar, got %+v", s)
+		}
+	}
+	deleted, err := d.Delete(ctx, key)
+	if err != nil || !deleted {
+		t.Fatalf("expected Delete() to remove secret, got %v, %v", deleted, err)
+	}
+	if deleted, err := d.Delete(ctx, key); err != nil || deleted {
+		t.Fatalf("expected Delete() of missing key to report false, got %v, %v", deleted, err)
+	}
+	if _, err := d.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status(), got %v", err)
+	}
+}
+
+func TestDynamoOneTimeConcurrentGet(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	key := "c0ffee00-3ed2-4e60-b441-c426d3f9f3c1"
+	if err := d.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var reads atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.Get(ctx, key)
+			switch err {
+			case nil:
+				reads.Add(1)
+			case domain.ErrNotFound:
+			default:
+				t.Errorf("error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reads.Load() != 1 {
+		t.Fatalf("expected one-time secret to be read once, got %d", reads.Load())
+	}
+}
+
+func TestDynamoExpiry(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	for _, oneTime := range []bool{false, true} {
+		key := fmt.Sprintf("expired-%t", oneTime)
+		if err := d.Put(ctx, key, domain.Secret{Message: "foo", OneTime: oneTime, Expiration: 60}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+	}
+	// DynamoDB keeps expired items around until its time to live catches up.
+	d.now = func() time.Time { return time.Now().Add(time.Minute) }
+
+	for _, oneTime := range []bool{false, true} {
+		key := fmt.Sprintf("expired-%t", oneTime)
+		if _, err := d.Get(ctx, key); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound from Get() of expired secret, got %v", err)
+		}
+		if _, err := d.Status(ctx, key); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound from Status() of expired secret, got %v", err)
+		}
+		if deleted, err := d.Delete(ctx, key); err != nil || deleted {
+			t.Fatalf("expected Delete() of expired secret to report false, got %v, %v", deleted, err)
+		}
+	}
+}
+
+func TestDynamoMaxViews(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	key := "test-max-views"
+	if err := d.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := d.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		if meta, err := d.Status(ctx, key); err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+	}
+	if _, err := d.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestDynamoConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := d.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestDynamoBinaryMessage(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDynamo(t)
+
+	message := "yopass:binary:\x00\xff\xfe packets"
+	if err := d.Put(ctx, "binary", domain.Secret{Message: message, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if s, err := d.Get(ctx, "binary"); err != nil || s.Message != message {
+		t.Fatalf("expected binary message to be kept intact, got %q, %v", s.Message, err)
+	}
+
+	// Items written before messages were stored as binary.
+	_, err := d.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":       {S: aws.String("legacy")},
+			"secret":   {S: aws.String("foo")},
+			"one_time": {BOOL: aws.Bool(false)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error in PutItem(): %v", err)
+	}
+	if meta, err := d.Status(ctx, "legacy"); err != nil || meta.Size != len("foo") {
+		t.Fatalf("expected size of legacy item from its message, got %+v, %v", meta, err)
+	}
+	if s, err := d.Get(ctx, "legacy"); err != nil || s.Message != "foo" {
+		t.Fatalf("expected legacy item to be readable, got %+v, %v", s, err)
+	}
+}
+
+func TestDynamoSecretExpiration(t *testing.T) {
+	created := time.Now().Unix()
+	item := map[string]*dynamodb.AttributeValue{
+		"secret":  {B: []byte("foo")},
+		"created": dynamoNumber(created),
+		"ttl":     dynamoNumber(created + 3600),
+	}
+	if s := dynamoSecret(item); s.Expiration != 3600 {
+		t.Errorf("expected expiration 3600, got %d", s.Expiration)
+	}
+
+	// Items stored without their creation time don't tell their lifetime.
+	delete(item, "created")
+	if s := dynamoSecret(item); s.Expiration != 0 {
+		t.Errorf("expected no expiration without creation time, got %d", s.Expiration)
+	}
+}
+
+func TestNewDynamoWithoutTable(t *testing.T) {
+	if _, err := NewDynamo("", DynamoOptions{}); err == nil {
+		t.Fatal("expected error without a table name")
+	}
+}
+
+func TestDynamoReceipt(t *testing.T) {
+	testRepositoryReceipt(t, func(t *testing.T) domain.Repository {
+		return newTestDynamo(t)
+	})
+}
+
+func TestDynamoExtend(t *testing.T) {
+	testRepositoryExtend(t, newTestDynamo(t))
+}
+
+func TestDynamoPeek(t *testing.T) {
+	testRepositoryPeek(t, newTestDynamo(t))
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// sealedMagic starts messages sealed by Encrypted. It is followed by the
+// length of the key ID in one byte, the key ID, the nonce and the
+// ciphertext. Neither armored nor binary OpenPGP messages start with a zero
+// byte, so sealed messages can't be mistaken for unsealed ones.
+const sealedMagic = "\x00YS1"
+
+// ErrUnsealFailed is returned when a stored secret can't be opened with the
+// keyring, e.g. because its key was removed or the ciphertext was altered.
+var ErrUnsealFailed = errors.New("failed to unseal secret")
+
+// Encrypted seals the message of every secret with AES-GCM before it reaches
+// the wrapped repository, so the database never holds the client ciphertext
+// itself. The storage key is used as additional data, which prevents a
+// sealed message from being copied over to another key.
+//
+// Messages stored without the sealed header, i.e. before encryption at rest
+// was enabled, are returned unchanged.
+type Encrypted struct {
+	repo    domain.Repository
+	keyring *Keyring
+}
+
+// NewEncrypted returns repo with messages sealed using keyring.
+func NewEncrypted(repo domain.Repository, keyring *Keyring) domain.Repository {
+	return &Encrypted{repo: repo, keyring: keyring}
+}
+
+func (e *Encrypted) Get(ctx context.Context, key string) (domain.Secret, error) {
+	s, err := e.repo.Get(ctx, key)
+	if err != nil {
+		return s, err
+	}
+	message, err := e.open(key, s.Message)
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	s.Message = message
+	return s, nil
+}
+
+func (e *Encrypted) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	s, err := e.repo.Peek(ctx, key)
+	if err != nil {
+		return s, err
+	}
+	message, err := e.open(key, s.Message)
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	s.Message = message
+	return s, nil
+}
+
+func (e *Encrypted) Put(ctx context.Context, key string, secret domain.Secret) error {
+	message, err := e.seal(key, secret.Message)
+	if err != nil {
+		return err
+	}
+	// Report the size of the message, not of its sealed form.
+	if secret.Size == 0 {
+		secret.Size = len(secret.Message)
+	}
+	secret.Message = message
+	return e.repo.Put(ctx, key, secret)
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key string) (bool, error) {
+	return e.repo.Delete(ctx, key)
+}
+
+// Status only reports metadata which is stored in the clear, so there is
+// nothing to open.
+func (e *Encrypted) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	return e.repo.Status(ctx, key)
+}
+
+func (e *Encrypted) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return e.repo.Extend(ctx, key, expiresAt)
+}
+
+func (e *Encrypted) Ping(ctx context.Context) error {
+	return e.repo.Ping(ctx)
+}
+
+// Close closes the wrapped repository if it holds any resources.
+func (e *Encrypted) Close() error {
+	if closer, ok := e.repo.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *Encrypted) seal(key, message string) (string, error) {
+	id := e.keyring.active
+	aead := e.keyring.aeads[id]
+	header := len(sealedMagic) + 1 + len(id)
+	sealed := make([]byte, header+aead.NonceSize(), header+aead.NonceSize()+len(message)+aead.Overhead())
+	copy(sealed, sealedMagic)
+	sealed[len(sealedMagic)] = byte(len(id))
+	copy(sealed[len(sealedMagic)+1:], id)
+	nonce := sealed[header:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return string(aead.Seal(sealed, nonce, []byte(message), []byte(key))), nil
+}
+
+func (e *Encrypted) open(key, message string) (string, error) {
+	rest, ok := strings.CutPrefix(message, sealedMagic)
+	if !ok {
+		return message, nil
+	}
+	if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+		return "", fmt.Errorf("%w: malformed message", ErrUnsealFailed)
+	}
+	id, sealed := rest[1:1+int(rest[0])], []byte(rest[1+int(rest[0]):])
+
+	aead, ok := e.keyring.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown key %q", ErrUnsealFailed, id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("%w: malformed message", ErrUnsealFailed)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnsealFailed, err)
+	}
+	return string(plaintext), nil
+}
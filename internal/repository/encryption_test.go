@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+func newTestKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	k, err := NewKeyring(active, keys)
+	if err != nil {
+		t.Fatalf("error in NewKeyring(): %v", err)
+	}
+	return k
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemory(t, 0)
+	repo := NewEncrypted(store, newTestKeyring(t, "1", "1"))
+
+	secret := domain.Secret{Message: "-----BEGIN PGP MESSAGE-----", OneTime: true, Expiration: 3600}
+	if err := repo.Put(ctx, "key", secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	stored, err := store.Status(ctx, "key")
+	if err != nil || !stored.OneTime || stored.Size != len(secret.Message) {
+		t.Fatalf("expected metadata of the message to stay readable, got %+v, %v", stored, err)
+	}
+	raw := store.entries["key"].secret.Message
+	if !strings.HasPrefix(raw, sealedMagic+"\x011") || strings.Contains(raw, "PGP") {
+		t.Fatalf("expected sealed message in database, got %q", raw)
+	}
+	// The header, nonce and tag are all that is added to the message.
+	if overhead := len(raw) - len(secret.Message); overhead != len(sealedMagic)+2+12+16 {
+		t.Fatalf("expected sealed message to add 34 bytes, got %d", overhead)
+	}
+
+	s, err := repo.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	// The size of the message is kept, not that of the sealed message.
+	want := secret
+	want.Size = len(secret.Message)
+	if s != want {
+		t.Fatalf("expected %+v, got %+v", want, s)
+	}
+	if _, err := repo.Get(ctx, "key"); err != domain.ErrNotFound {
+		t.Fatalf("expected one-time secret to be consumed, got %v", err)
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemory(t, 0)
+
+	old := NewEncrypted(store, newTestKeyring(t, "1", "1"))
+	if err := old.Put(ctx, "old", domain.Secret{Message: "old"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	rotated := NewEncrypted(store, newTestKeyring(t, "2", "1", "2"))
+	if err := rotated.Put(ctx, "new", domain.Secret{Message: "new"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if raw := store.entries["new"].secret.Message; !strings.HasPrefix(raw, sealedMagic+"\x012") {
+		t.Fatalf("expected new secret to be sealed with the active key, got %q", raw)
+	}
+	for _, key := range []string{"old", "new"} {
+		s, err := rotated.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(%s): %v", key, err)
+		}
+		if s.Message != key {
+			t.Fatalf("expected value %s, got %s", key, s.Message)
+		}
+	}
+
+	// Once the old key is dropped its secrets can't be opened anymore.
+	retired := NewEncrypted(store, newTestKeyring(t, "2", "2"))
+	if _, err := retired.Get(ctx, "old"); !errors.Is(err, ErrUnsealFailed) {
+		t.Fatalf("expected ErrUnsealFailed for a removed key, got %v", err)
+	}
+}
+
+func TestEncryptedRejectsTampering(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemory(t, 0)
+	repo := NewEncrypted(store, newTestKeyring(t, "1", "1"))
+
+	if err := repo.Put(ctx, "key", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	sealed := store.entries["key"].secret
+	altered := []byte(sealed.Message)
+	altered[len(altered)-5] ^= 1
+
+	// A sealed message is bound to its key.
+	if err := store.Put(ctx, "other", sealed); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := repo.Get(ctx, "other"); !errors.Is(err, ErrUnsealFailed) {
+		t.Fatalf("expected ErrUnsealFailed for a moved message, got %v", err)
+	}
+
+	for name, message := range map[string]string{
+		"no key ID":    sealedMagic,
+		"short key ID": sealedMagic + "\x05abc",
+		"too short":    sealedMagic + "\x011AAAA",
+		"altered":      string(altered),
+		"unknown key":  strings.Replace(sealed.Message, sealedMagic+"\x011", sealedMagic+"\x019", 1),
+	} {
+		if err := store.Put(ctx, "key", domain.Secret{Message: message}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		if _, err := repo.Get(ctx, "key"); !errors.Is(err, ErrUnsealFailed) {
+			t.Errorf("%s: expected ErrUnsealFailed, got %v", name, err)
+		}
+	}
+}
+
+func TestEncryptedReadsUnsealedSecrets(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemory(t, 0)
+	if err := store.Put(ctx, "key", domain.Secret{Message: "stored before encryption"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	repo := NewEncrypted(store, newTestKeyring(t, "1", "1"))
+	s, err := repo.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != "stored before encryption" {
+		t.Fatalf("expected unsealed message to be returned unchanged, got %q", s.Message)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "keyring.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write keyring: %v", err)
+		}
+		return path
+	}
+	key := "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+	k, err := LoadKeyring(write(t, `{"active": "2", "keys": {"1": "`+key+`", "2": "`+key+`"}}`))
+	if err != nil {
+		t.Fatalf("error in LoadKeyring(): %v", err)
+	}
+	if k.active != "2" || len(k.aeads) != 2 {
+		t.Fatalf("expected two keys with 2 active, got %d keys with %q active", len(k.aeads), k.active)
+	}
+
+	for name, content := range map[string]string{
+		"invalid json":     `{`,
+		"missing active":   `{"keys": {"1": "` + key + `"}}`,
+		"unknown active":   `{"active": "2", "keys": {"1": "` + key + `"}}`,
+		"invalid base64":   `{"active": "1", "keys": {"1": "!!!"}}`,
+		"invalid key size": `{"active": "1", "keys": {"1": "AAAA"}}`,
+		"invalid key ID":   `{"active": "a:b", "keys": {"a:b": "` + key + `"}}`,
+		"long key ID":      `{"active": "` + strings.Repeat("a", 256) + `", "keys": {"` + strings.Repeat("a", 256) + `": "` + key + `"}}`,
+	} {
+		if _, err := LoadKeyring(write(t, content)); err == nil {
+			t.Errorf("%s: expected error from LoadKeyring()", name)
+		}
+	}
+
+	if _, err := LoadKeyring(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for a missing keyring file")
+	}
+}
@@ -2,21 +2,96 @@ package repository
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/pkg/driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
-func NewRepository(cfg *config.Config, logger *zap.Logger) (domain.Repository, error) {
-	switch cfg.Database {
-	case "memcached":
-		logger.Debug("Configuring Memcached", zap.String("address", cfg.Memcached))
-		return NewMemcached(cfg.Memcached), nil
-	case "redis":
-		logger.Debug("Configuring Redis", zap.String("url", cfg.Redis))
-		return NewRedis(cfg.Redis)
-	default:
-		return nil, fmt.Errorf("unsupported database: %s", cfg.Database)
+func NewRepository(cfg *config.Config, logger *zap.Logger, reg prometheus.Registerer) (domain.Repository, error) {
+	var keyring *Keyring
+	if cfg.Keyring != "" {
+		var err error
+		if keyring, err = LoadKeyring(cfg.Keyring); err != nil {
+			return nil, err
+		}
+	}
+
+	repo, err := newDatabase(cfg.Database, cfg, logger, reg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SecondaryDatabase != "" {
+		migration, err := newMigration(repo, cfg, logger, reg)
+		if err != nil {
+			closeRepository(repo)
+			return nil, err
+		}
+		repo = migration
+	}
+	if keyring != nil {
+		logger.Debug("Configuring encryption at rest", zap.String("keyring", cfg.Keyring))
+		repo = NewEncrypted(repo, keyring)
+	}
+	// The timeout goes inside the retries, so every attempt gets one of its
+	// own and a stalled attempt doesn't use up the time of the next.
+	if cfg.DatabaseTimeout > 0 {
+		logger.Debug("Configuring database timeout", zap.Duration("timeout", cfg.DatabaseTimeout))
+		repo = NewTimeout(repo, cfg.DatabaseTimeout)
+	}
+	if cfg.DatabaseRetries > 0 || cfg.CircuitBreakerThreshold > 0 {
+		logger.Debug("Configuring database retries and circuit breaker",
+			zap.Int("retries", cfg.DatabaseRetries), zap.Int("threshold", cfg.CircuitBreakerThreshold))
+		repo = NewResilient(repo, ResilienceOptions{
+			Retries:          cfg.DatabaseRetries,
+			RetryBackoff:     cfg.DatabaseRetryBackoff,
+			FailureThreshold: cfg.CircuitBreakerThreshold,
+			Cooldown:         cfg.CircuitBreakerCooldown,
+			Logger:           logger,
+			Registerer:       reg,
+		})
+	}
+	return NewInstrumented(repo, cfg.Database, reg), nil
+}
+
+func newDatabase(database string, cfg *config.Config, logger *zap.Logger, reg prometheus.Registerer) (domain.Repository, error) {
+	d, ok := driver.Lookup(database)
+	if !ok {
+		return nil, fmt.Errorf("unsupported database: %s", database)
+	}
+	settings := cfg.Settings
+	if settings == nil {
+		settings = viper.New()
+	}
+	return d.Open(settings, driver.Options{Logger: logger, Registerer: reg})
+}
+
+// newMigration combines primary with the configured secondary database.
+func newMigration(primary domain.Repository, cfg *config.Config, logger *zap.Logger, reg prometheus.Registerer) (domain.Repository, error) {
+	if cfg.SecondaryDatabase == cfg.Database {
+		return nil, fmt.Errorf("secondary database must differ from database: %s", cfg.Database)
+	}
+	logger.Debug("Configuring database migration",
+		zap.String("secondary", cfg.SecondaryDatabase), zap.String("mode", cfg.MigrationMode))
+	secondary, err := newDatabase(cfg.SecondaryDatabase, cfg, logger, reg)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := NewMigration(primary, secondary, MigrationMode(cfg.MigrationMode), logger)
+	if err != nil {
+		closeRepository(secondary)
+		return nil, err
+	}
+	return repo, nil
+}
+
+// closeRepository releases a repository that won't be handed out.
+func closeRepository(repo domain.Repository) {
+	if closer, ok := repo.(io.Closer); ok {
+		closer.Close()
 	}
 }
@@ -1,12 +1,43 @@
 package repository
 
 import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/pkg/driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 	"go.uber.org/zap/zaptest"
 )
 
+// unwrapInstrumented returns the repository wrapped by the metrics
+// decorator NewRepository always adds.
+func unwrapInstrumented(t *testing.T, repo domain.Repository) domain.Repository {
+	t.Helper()
+	i, ok := repo.(*Instrumented)
+	if !ok {
+		t.Fatalf("expected repository to be instrumented, got %T", repo)
+	}
+	return i.repo
+}
+
+// newTestSettings returns driver settings holding values.
+func newTestSettings(values map[string]any) driver.Settings {
+	v := viper.New()
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	return v
+}
+
 func TestNewRepository(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 
@@ -25,6 +56,21 @@ func TestNewRepository(t *testing.T) {
 			db:      "redis",
 			wantErr: false,
 		},
+		{
+			name:    "dynamodb",
+			db:      "dynamodb",
+			wantErr: false,
+		},
+		{
+			name:    "memory",
+			db:      "memory",
+			wantErr: false,
+		},
+		{
+			name:    "file",
+			db:      "file",
+			wantErr: false,
+		},
 		{
 			name:    "unsupported",
 			db:      "invalid",
@@ -35,11 +81,16 @@ func TestNewRepository(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &config.Config{
-				Database:  tt.db,
-				Memcached: "localhost:11211",
-				Redis:     "redis://localhost:6379/0",
+				Database: tt.db,
+				Settings: newTestSettings(map[string]any{
+					"memcached":       []string{"localhost:11211"},
+					"redis":           "redis://localhost:6379/0",
+					"data-dir":        t.TempDir(),
+					"dynamodb-table":  "yopass",
+					"dynamodb-region": "eu-west-1",
+				}),
 			}
-			repo, err := NewRepository(cfg, logger)
+			repo, err := NewRepository(cfg, logger, prometheus.NewRegistry())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewRepository() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -47,6 +98,284 @@ func TestNewRepository(t *testing.T) {
 			if !tt.wantErr && repo == nil {
 				t.Error("NewRepository() returned nil repo without error")
 			}
+			if !tt.wantErr {
+				unwrapInstrumented(t, repo)
+			}
+			if closer, ok := repo.(io.Closer); ok {
+				closer.Close()
+			}
 		})
 	}
 }
+
+func TestNewRepositoryTimeout(t *testing.T) {
+	cfg := &config.Config{Database: "memory", DatabaseTimeout: time.Second}
+	repo, err := NewRepository(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	defer repo.(io.Closer).Close()
+
+	if _, ok := unwrapInstrumented(t, repo).(*Timeout); !ok {
+		t.Errorf("expected repository to be wrapped with a timeout, got %T", repo)
+	}
+}
+
+func TestNewRepositoryResilient(t *testing.T) {
+	cfg := &config.Config{
+		Database:                "memory",
+		DatabaseTimeout:         time.Second,
+		DatabaseRetries:         2,
+		CircuitBreakerThreshold: 5,
+	}
+	repo, err := NewRepository(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	defer repo.(io.Closer).Close()
+
+	// Every attempt gets its own timeout.
+	resilient, ok := unwrapInstrumented(t, repo).(*Resilient)
+	if !ok {
+		t.Fatalf("expected repository to be wrapped with retries, got %T", repo)
+	}
+	if _, ok := resilient.repo.(*Timeout); !ok {
+		t.Errorf("expected a timeout inside the retries, got %T", resilient.repo)
+	}
+}
+
+func TestNewRepositoryRetriesTimedOutAttempt(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		Database:             "stalling",
+		Settings:             newTestSettings(map[string]any{"stalling-stalls": 1}),
+		DatabaseTimeout:      50 * time.Millisecond,
+		DatabaseRetries:      1,
+		DatabaseRetryBackoff: time.Millisecond,
+	}
+	repo, err := NewRepository(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	defer repo.(io.Closer).Close()
+
+	if err := repo.Put(ctx, "key", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("expected Put() to succeed on the second attempt, got %v", err)
+	}
+	if s, err := repo.Get(ctx, "key"); err != nil || s.Message != "foo" {
+		t.Fatalf("expected secret foo, got %+v, %v", s, err)
+	}
+}
+
+func TestNewRepositoryRedisModes(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+
+	tests := []struct {
+		name     string
+		settings map[string]any
+		wantErr  bool
+	}{
+		{
+			name:     "standalone",
+			settings: map[string]any{"redis-mode": "standalone"},
+		},
+		{
+			name:     "sentinel",
+			settings: map[string]any{"redis-mode": "sentinel", "redis-master-name": "mymaster", "redis-addrs": []string{"localhost:26379"}},
+		},
+		{
+			name:     "sentinel without master",
+			settings: map[string]any{"redis-mode": "sentinel", "redis-addrs": []string{"localhost:26379"}},
+			wantErr:  true,
+		},
+		{
+			name:     "cluster",
+			settings: map[string]any{"redis-mode": "cluster", "redis-addrs": []string{"localhost:7000", "localhost:7001"}},
+		},
+		{
+			name:     "unsupported",
+			settings: map[string]any{"redis-mode": "invalid"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.settings["redis"] = "redis://localhost:6379/0"
+			cfg := &config.Config{Database: "redis", Settings: newTestSettings(tt.settings)}
+			_, err := NewRepository(cfg, logger, prometheus.NewRegistry())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRepositoryMigration(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{
+			name: "fallback",
+			cfg:  config.Config{SecondaryDatabase: "file", MigrationMode: "fallback"},
+		},
+		{
+			name: "mirror",
+			cfg:  config.Config{SecondaryDatabase: "file", MigrationMode: "mirror"},
+		},
+		{
+			name:    "same database",
+			cfg:     config.Config{SecondaryDatabase: "memory", MigrationMode: "fallback"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported secondary",
+			cfg:     config.Config{SecondaryDatabase: "invalid", MigrationMode: "fallback"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported mode",
+			cfg:     config.Config{SecondaryDatabase: "file", MigrationMode: "invalid"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Database = "memory"
+			tt.cfg.Settings = newTestSettings(map[string]any{"data-dir": t.TempDir()})
+			repo, err := NewRepository(&tt.cfg, logger, prometheus.NewRegistry())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer repo.(io.Closer).Close()
+			if _, ok := unwrapInstrumented(t, repo).(*Migration); !ok {
+				t.Errorf("expected a migration repository, got %T", repo)
+			}
+		})
+	}
+}
+
+func TestNewRepositoryKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	keyring := `{"active": "1", "keys": {"1": "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="}}`
+	if err := os.WriteFile(path, []byte(keyring), 0o600); err != nil {
+		t.Fatalf("failed to write keyring: %v", err)
+	}
+
+	repo, err := NewRepository(&config.Config{Database: "memory", Keyring: path}, zaptest.NewLogger(t), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	defer repo.(io.Closer).Close()
+	if _, ok := unwrapInstrumented(t, repo).(*Encrypted); !ok {
+		t.Errorf("expected repository to be encrypted, got %T", repo)
+	}
+
+	cfg := &config.Config{Database: "memory", Keyring: filepath.Join(t.TempDir(), "missing.json")}
+	if _, err := NewRepository(cfg, zaptest.NewLogger(t), prometheus.NewRegistry()); err == nil {
+		t.Error("expected error for a missing keyring")
+	}
+}
+
+// stallingRepository blocks its first stalls operations until their
+// context is done.
+type stallingRepository struct {
+	domain.Repository
+	mu     sync.Mutex
+	stalls int
+}
+
+func (s *stallingRepository) stall(ctx context.Context) error {
+	s.mu.Lock()
+	stall := s.stalls > 0
+	s.stalls--
+	s.mu.Unlock()
+	if !stall {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *stallingRepository) Put(ctx context.Context, key string, secret domain.Secret) error {
+	if err := s.stall(ctx); err != nil {
+		return err
+	}
+	return s.Repository.Put(ctx, key, secret)
+}
+
+func init() {
+	driver.Register(driver.Driver{
+		Name: "stalling",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.Int("stalling-stalls", 0, "number of operations to stall")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			return &stallingRepository{Repository: NewMemory(0), stalls: s.GetInt("stalling-stalls")}, nil
+		},
+	})
+	driver.Register(driver.Driver{
+		Name: "in-house",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.Int("in-house-max-secrets", 0, "max number of secrets")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (domain.Repository, error) {
+			return NewMemory(s.GetInt("in-house-max-secrets")), nil
+		},
+	})
+}
+
+func TestNewRepositoryRegisteredDriver(t *testing.T) {
+	cfg := &config.Config{
+		Database: "in-house",
+		Settings: newTestSettings(map[string]any{"in-house-max-secrets": 1}),
+	}
+	repo, err := NewRepository(cfg, zaptest.NewLogger(t), prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("NewRepository() error = %v", err)
+	}
+	defer repo.(io.Closer).Close()
+
+	m, ok := unwrapInstrumented(t, repo).(*Memory)
+	if !ok {
+		t.Fatalf("expected repository of the registered driver, got %T", repo)
+	}
+	if m.maxSecrets != 1 {
+		t.Errorf("expected driver settings to be passed on, got maxSecrets %d", m.maxSecrets)
+	}
+}
+
+func TestBuiltinDriverFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("yopass", pflag.ContinueOnError)
+	for _, d := range driver.Drivers() {
+		if d.Flags != nil {
+			d.Flags(fs)
+		}
+	}
+
+	v := viper.New()
+	if err := v.BindPFlags(fs); err != nil {
+		t.Fatalf("BindPFlags() error = %v", err)
+	}
+	for key, want := range map[string]any{
+		"redis":          "redis://localhost:6379/0",
+		"postgres":       "postgres://localhost:5432/yopass",
+		"data-dir":       "data",
+		"dynamodb-table": "yopass",
+	} {
+		if got := v.GetString(key); got != want {
+			t.Errorf("expected default %s %v, got %v", key, want, got)
+		}
+	}
+	if got := v.GetStringSlice("memcached"); len(got) != 1 || got[0] != "localhost:11211" {
+		t.Errorf("expected default memcached node, got %v", got)
+	}
+}
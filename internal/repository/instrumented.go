@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Instrumented records Prometheus metrics for every operation of the
+// wrapped repository.
+type Instrumented struct {
+	repo     domain.Repository
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	payload  *prometheus.HistogramVec
+	consumed prometheus.Counter
+}
+
+// NewInstrumented returns repo with its metrics registered in reg. The
+// database label of every metric is set to database.
+func NewInstrumented(repo domain.Repository, database string, reg prometheus.Registerer) domain.Repository {
+	labels := prometheus.Labels{"database": database}
+	i := &Instrumented{
+		repo: repo,
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "yopass_database_operation_duration_seconds",
+				Help:        "Histogram of database operation latencies by operation.",
+				ConstLabels: labels,
+				Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 15),
+			},
+			[]string{"operation"},
+		),
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "yopass_database_errors_total",
+				Help:        "Total number of failed database operations by operation and error class.",
+				ConstLabels: labels,
+			},
+			[]string{"operation", "class"},
+		),
+		payload: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:        "yopass_database_payload_bytes",
+				Help:        "Histogram of secret sizes written and read by operation.",
+				ConstLabels: labels,
+				Buckets:     prometheus.ExponentialBuckets(64, 4, 10),
+			},
+			[]string{"operation"},
+		),
+		consumed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name:        "yopass_database_one_time_consumed_total",
+				Help:        "Total number of one-time and multi-view secrets consumed by their last read.",
+				ConstLabels: labels,
+			},
+		),
+	}
+	reg.MustRegister(i.duration, i.errors, i.payload, i.consumed)
+	return i
+}
+
+func (i *Instrumented) Get(ctx context.Context, key string) (domain.Secret, error) {
+	start := time.Now()
+	s, err := i.repo.Get(ctx, key)
+	i.observe("get", start, err)
+	if err == nil {
+		i.payload.WithLabelValues("get").Observe(float64(len(s.Message)))
+		if s.OneTime || s.MaxViews > 0 && s.RemainingViews == 0 {
+			i.consumed.Inc()
+		}
+	}
+	return s, err
+}
+
+func (i *Instrumented) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	start := time.Now()
+	s, err := i.repo.Peek(ctx, key)
+	i.observe("peek", start, err)
+	if err == nil {
+		i.payload.WithLabelValues("peek").Observe(float64(len(s.Message)))
+	}
+	return s, err
+}
+
+func (i *Instrumented) Put(ctx context.Context, key string, secret domain.Secret) error {
+	start := time.Now()
+	err := i.repo.Put(ctx, key, secret)
+	i.observe("put", start, err)
+	if err == nil {
+		i.payload.WithLabelValues("put").Observe(float64(len(secret.Message)))
+	}
+	return err
+}
+
+func (i *Instrumented) Delete(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	deleted, err := i.repo.Delete(ctx, key)
+	i.observe("delete", start, err)
+	return deleted, err
+}
+
+func (i *Instrumented) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	start := time.Now()
+	meta, err := i.repo.Status(ctx, key)
+	i.observe("status", start, err)
+	return meta, err
+}
+
+func (i *Instrumented) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	start := time.Now()
+	err := i.repo.Extend(ctx, key, expiresAt)
+	i.observe("extend", start, err)
+	return err
+}
+
+func (i *Instrumented) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := i.repo.Ping(ctx)
+	i.observe("ping", start, err)
+	return err
+}
+
+// Close closes the wrapped repository if it holds any resources.
+func (i *Instrumented) Close() error {
+	if closer, ok := i.repo.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (i *Instrumented) observe(operation string, start time.Time, err error) {
+	i.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		i.errors.WithLabelValues(operation, errorClass(err)).Inc()
+	}
+}
+
+// errorClass groups errors into a small set of metric label values.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "backend"
+	}
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	repo := NewInstrumented(newTestMemory(t, 0), "memory", reg)
+	i := repo.(*Instrumented)
+
+	if err := repo.Put(ctx, "key", domain.Secret{Message: strings.Repeat("x", 100), OneTime: true}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := repo.Status(ctx, "key"); err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if _, err := repo.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if _, err := repo.Get(ctx, "key"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := repo.Delete(ctx, "key"); err != nil {
+		t.Fatalf("error in Delete(): %v", err)
+	}
+	if err := repo.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
+	if got := testutil.CollectAndCount(i.duration); got != 5 {
+		t.Errorf("expected latency histograms for 5 operations, got %d", got)
+	}
+	if got := testutil.ToFloat64(i.errors.WithLabelValues("get", "not_found")); got != 1 {
+		t.Errorf("expected 1 not_found error for get, got %v", got)
+	}
+	if got := testutil.CollectAndCount(i.errors); got != 1 {
+		t.Errorf("expected a single error series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(i.payload); got != 2 {
+		t.Errorf("expected payload histograms for get and put, got %d", got)
+	}
+	if got := testutil.ToFloat64(i.consumed); got != 1 {
+		t.Errorf("expected 1 consumed one-time secret, got %v", got)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("error in Gather(): %v", err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			found := false
+			for _, label := range metric.GetLabel() {
+				found = found || label.GetName() == "database" && label.GetValue() == "memory"
+			}
+			if !found {
+				t.Errorf("expected %s to carry the database label", family.GetName())
+			}
+		}
+	}
+}
+
+func TestInstrumentedConsumedMaxViews(t *testing.T) {
+	ctx := context.Background()
+	i := NewInstrumented(newTestMemory(t, 0), "memory", prometheus.NewRegistry()).(*Instrumented)
+
+	if err := i.Put(ctx, "key", domain.Secret{Message: "foo", MaxViews: 2}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := i.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if got := testutil.ToFloat64(i.consumed); got != 0 {
+		t.Errorf("expected a secret with views left not to be counted, got %v", got)
+	}
+	if _, err := i.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if got := testutil.ToFloat64(i.consumed); got != 1 {
+		t.Errorf("expected the last view to consume the secret, got %v", got)
+	}
+}
+
+func TestInstrumentedErrorClasses(t *testing.T) {
+	ctx := context.Background()
+	i := NewInstrumented(NewTimeout(slowRepository{}, time.Millisecond), "slow", prometheus.NewRegistry()).(*Instrumented)
+
+	if err := i.Put(ctx, "key", domain.Secret{}); err == nil {
+		t.Fatal("expected Put() to time out")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := i.Status(canceled, "key"); err == nil {
+		t.Fatal("expected Status() to be canceled")
+	}
+	i.repo = failingRepository{}
+	if _, err := i.Delete(ctx, "key"); err == nil {
+		t.Fatal("expected Delete() to fail")
+	}
+
+	for _, tt := range []struct{ operation, class string }{
+		{"put", "timeout"},
+		{"status", "canceled"},
+		{"delete", "backend"},
+	} {
+		if got := testutil.ToFloat64(i.errors.WithLabelValues(tt.operation, tt.class)); got != 1 {
+			t.Errorf("expected 1 %s error for %s, got %v", tt.class, tt.operation, got)
+		}
+	}
+	if got := testutil.CollectAndCount(i.payload); got != 0 {
+		t.Errorf("expected no payload sizes for failed operations, got %d", got)
+	}
+}
@@ -0,0 +1,18 @@
+package repository
+
+import "time"
+
+// runJanitor calls sweep every interval until done is closed. Backends that
+// can't rely on their store to expire secrets use it to purge them.
+func runJanitor(interval time.Duration, done <-chan struct{}, sweep func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sweep()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Keyring holds the server-side keys used to seal secrets at rest. New
+// secrets are always sealed with the active key, the other keys are kept so
+// secrets sealed before a rotation can still be opened.
+//
+// A keyring file is JSON with base64 encoded AES keys of 16, 24 or 32 bytes:
+//
+//	{
+//	  "active": "2",
+//	  "keys": {
+//	    "1": "q2Vy...",
+//	    "2": "8Wf0..."
+//	  }
+//	}
+type Keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+type keyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a keyring file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in keyring: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(f.Active, keys)
+}
+
+// NewKeyring returns a keyring sealing new secrets with the key named active.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", active)
+	}
+
+	k := &Keyring{active: active, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 || strings.Contains(id, ":") {
+			return nil, errors.New("key IDs must be 1 to 255 bytes long and must not contain ':'")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in keyring: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
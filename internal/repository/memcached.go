@@ -1,78 +1,391 @@
 package repository
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 )
 
+// tombstone replaces a one-time secret once a reader has claimed it. It is
+// written with compare-and-swap so only one reader can win the claim, and it
+// keeps the secret unreadable even if the follow-up delete fails.
+var tombstone = []byte("yopass:consumed")
+
+// tombstoneExpiration is how long a tombstone is kept in seconds. It only
+// has to outlive the delete that follows a successful claim.
+const tombstoneExpiration = 60
+
+// memcachedCASAttempts bounds how often a record that concurrent readers
+// keep updating is read and written again, e.g. to count a view.
+const memcachedCASAttempts = 10
+
+// errRecordConflict is returned by update when a concurrent reader updated
+// the record first.
+var errRecordConflict = errors.New("secret was updated concurrently")
+
+// defaultMemcachedRetryInterval is how long a failed node is skipped when
+// MemcachedOptions.RetryInterval is not set.
+const defaultMemcachedRetryInterval = 10 * time.Second
+
+// MemcachedOptions tunes the connection to the memcached nodes. Zero values
+// fall back to the gomemcache defaults.
+type MemcachedOptions struct {
+	// Timeout bounds connecting to a node and every read or write on it.
+	Timeout time.Duration
+	// MaxIdleConns is the number of idle connections kept per node.
+	MaxIdleConns int
+	// RetryInterval is how long a failed node is skipped before it is
+	// tried again.
+	RetryInterval time.Duration
+	// ChunkSize is the largest value written to a single item, larger
+	// secrets are split into chunks. It has to stay below the item size
+	// limit of the servers.
+	ChunkSize  int
+	Logger     *zap.Logger
+	Registerer prometheus.Registerer
+}
+
+// Memcached stores secrets in memcached. The client has no context support,
+// so the context is checked before every round trip to the server instead.
+//
+// Keys are sharded across all configured nodes. When a node fails only the
+// keys it owns become unavailable until it recovers. The item stored under
+// the secret key only holds its metadata, the message is stored in chunks
+// small enough for single items.
 type Memcached struct {
-	client *memcache.Client
+	client    *memcache.Client
+	nodes     *memcachedNodes
+	chunkSize int
+}
+
+func NewMemcached(servers []string, opts MemcachedOptions) (domain.Repository, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no memcached servers configured")
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultMemcachedRetryInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultMemcachedChunkSize
+	}
+
+	nodes, err := newMemcachedNodes(servers, opts.RetryInterval, opts.Logger, opts.Registerer)
+	if err != nil {
+		return nil, err
+	}
+	client := memcache.NewFromSelector(nodes)
+	client.Timeout = opts.Timeout
+	client.MaxIdleConns = opts.MaxIdleConns
+	return &Memcached{client: client, nodes: nodes, chunkSize: opts.ChunkSize}, nil
 }
 
-func NewMemcached(server string) domain.Repository {
-	return &Memcached{memcache.New(server)}
+// Get returns the secret stored under key. Views are recorded with
+// compare-and-swap, which is retried a few times if other readers of the
+// same secret get in between.
+func (m *Memcached) Get(ctx context.Context, key string) (domain.Secret, error) {
+	for attempt := 1; ; attempt++ {
+		s, err := m.view(ctx, key)
+		if err != errRecordConflict {
+			return s, err
+		}
+		if attempt == memcachedCASAttempts {
+			return domain.Secret{}, fmt.Errorf("failed to count view of secret: %w", err)
+		}
+	}
 }
 
-func (m *Memcached) Get(key string) (domain.Secret, error) {
+func (m *Memcached) view(ctx context.Context, key string) (domain.Secret, error) {
 	var s domain.Secret
 
-	item, err := m.client.Get(key)
+	item, record, err := m.get(ctx, key)
 	if err != nil {
-		if err == memcache.ErrCacheMiss {
-			return s, domain.ErrNotFound
-		}
 		return s, err
 	}
+	if record != nil && record.Consumed() {
+		// Only the receipt of the secret is left.
+		return s, domain.ErrNotFound
+	}
 
-	if err := json.Unmarshal(item.Value, &s); err != nil {
-		return s, err
+	if record == nil {
+		err = json.Unmarshal(item.Value, &s)
+	} else {
+		var data []byte
+		if data, err = m.getChunks(ctx, key, record); err == nil {
+			s = record.Secret(string(data))
+		}
+	}
+	if err != nil {
+		return domain.Secret{}, err
 	}
 
-	if s.OneTime {
-		_ = m.client.Delete(key)
+	if record == nil {
+		if s.OneTime {
+			if err := m.consume(ctx, key, item, record); err != nil {
+				return domain.Secret{}, err
+			}
+		}
+		return s, nil
 	}
 
+	meta, last := record.Viewed(time.Now())
+	s.RemainingViews = meta.RemainingViews
+	switch {
+	case last && !meta.KeepReceipt():
+		if err := m.consume(ctx, key, item, record); err != nil {
+			return domain.Secret{}, err
+		}
+	case last:
+		// The record stays without its chunks as a receipt of the view.
+		receipt := *record
+		receipt.Metadata, receipt.ID, receipt.Chunks = meta, "", 0
+		if err := m.update(ctx, item, &receipt); err != nil {
+			return domain.Secret{}, err
+		}
+		m.deleteChunks(key, record)
+	case meta != record.Metadata:
+		record.Metadata = meta
+		if err := m.update(ctx, item, record); err != nil {
+			return domain.Secret{}, err
+		}
+	}
 	return s, nil
 }
 
-func (m *Memcached) Put(key string, secret domain.Secret) error {
-	data, err := secret.ToJSON()
+func (m *Memcached) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	item, record, err := m.get(ctx, key)
+	switch {
+	case err != nil:
+		return s, err
+	case record == nil:
+		err = json.Unmarshal(item.Value, &s)
+	case record.Consumed():
+		return s, domain.ErrNotFound
+	default:
+		var data []byte
+		if data, err = m.getChunks(ctx, key, record); err == nil {
+			s = record.Secret(string(data))
+		}
+	}
 	if err != nil {
+		return domain.Secret{}, err
+	}
+	return s, nil
+}
+
+// consume claims item for the reader of its last view and removes the
+// chunks of record, if it has any.
+func (m *Memcached) consume(ctx context.Context, key string, item *memcache.Item, record *memcachedRecord) error {
+	if err := m.claim(ctx, item); err != nil {
 		return err
 	}
+	if record != nil {
+		m.deleteChunks(key, record)
+	}
+	return nil
+}
+
+// claim atomically replaces item with a tombstone. It only succeeds for the
+// caller whose compare-and-swap token is still current, every other reader
+// gets domain.ErrNotFound.
+func (m *Memcached) claim(ctx context.Context, item *memcache.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	item.Value = tombstone
+	item.Expiration = tombstoneExpiration
+	err := m.client.CompareAndSwap(item)
+	m.nodes.observe(item.Key, err)
+	if err != nil {
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored || err == memcache.ErrCacheMiss {
+			return domain.ErrNotFound
+		}
+		return fmt.Errorf("failed to claim one-time secret: %w", err)
+	}
 
-	return m.client.Set(&memcache.Item{
+	// The tombstone already hides the secret, so a failed delete only
+	// leaves it around until it expires.
+	_ = m.client.Delete(item.Key)
+	return nil
+}
+
+// update replaces item with the updated record using compare-and-swap, so
+// no two readers can count the same view.
+func (m *Memcached) update(ctx context.Context, item *memcache.Item, record *memcachedRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	item.Value = append(bytes.Clone(metaPrefix), encoded...)
+	// Memcached takes expirations beyond 30 days as a Unix timestamp, which
+	// keeps the original expiry of the secret.
+	item.Expiration = 0
+	if !record.ExpiresAt.IsZero() {
+		item.Expiration = int32(record.ExpiresAt.Unix())
+	}
+	err = m.client.CompareAndSwap(item)
+	m.nodes.observe(item.Key, err)
+	switch err {
+	case nil:
+		return nil
+	case memcache.ErrCASConflict:
+		return errRecordConflict
+	case memcache.ErrNotStored, memcache.ErrCacheMiss:
+		return domain.ErrNotFound
+	}
+	return fmt.Errorf("failed to update secret: %w", err)
+}
+
+func (m *Memcached) Put(ctx context.Context, key string, secret domain.Secret) error {
+	record, err := m.putChunks(ctx, key, []byte(secret.Message), secret.Expiration)
+	if err != nil {
+		return err
+	}
+	record.Metadata = domain.NewMetadata(secret, time.Now())
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		m.deleteChunks(key, record)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		m.deleteChunks(key, record)
+		return err
+	}
+
+	err = m.client.Set(&memcache.Item{
 		Key:        key,
-		Value:      data,
+		Value:      append(bytes.Clone(metaPrefix), encoded...),
 		Expiration: secret.Expiration,
 	})
+	m.nodes.observe(key, err)
+	if err != nil {
+		m.deleteChunks(key, record)
+	}
+	return err
 }
 
-func (m *Memcached) Delete(key string) (bool, error) {
-	err := m.client.Delete(key)
+func (m *Memcached) Delete(ctx context.Context, key string) (bool, error) {
+	_, record, err := m.get(ctx, key)
+	if err != nil && err != domain.ErrNotFound {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	err = m.client.Delete(key)
+	m.nodes.observe(key, err)
 	if err != nil {
 		if err == memcache.ErrCacheMiss {
 			return false, nil
 		}
 		return false, err
 	}
+	if record != nil {
+		m.deleteChunks(key, record)
+	}
 	return true, nil
 }
 
-func (m *Memcached) Status(key string) (bool, error) {
-	item, err := m.client.Get(key)
+// Status reads the metadata record only. Secrets stored by earlier versions
+// carry no timestamps.
+func (m *Memcached) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	item, record, err := m.get(ctx, key)
 	if err != nil {
-		if err == memcache.ErrCacheMiss {
-			return false, domain.ErrNotFound
-		}
-		return false, err
+		return domain.Metadata{}, err
+	}
+	if record != nil {
+		return record.Metadata, nil
 	}
 
 	var s domain.Secret
 	if err := json.Unmarshal(item.Value, &s); err != nil {
-		return false, err
+		return domain.Metadata{}, err
+	}
+	return domain.Metadata{OneTime: s.OneTime, Size: len(s.Message), Kind: domain.KindMessage}, nil
+}
+
+// Extend moves the expiry of the secret stored under key and its chunks to
+// expiresAt. Secrets stored by earlier versions can't be extended.
+func (m *Memcached) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	for attempt := 1; ; attempt++ {
+		err := m.extend(ctx, key, expiresAt)
+		if err != errRecordConflict {
+			return err
+		}
+		if attempt == memcachedCASAttempts {
+			return fmt.Errorf("failed to extend secret: %w", err)
+		}
+	}
+}
+
+func (m *Memcached) extend(ctx context.Context, key string, expiresAt time.Time) error {
+	item, record, err := m.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Consumed() {
+		return domain.ErrNotFound
+	}
+	record.ExpiresAt = expiresAt.UTC().Truncate(time.Second)
+	// Chunks are extended first, so they never expire before the record.
+	for i := 0; i < record.Chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k := chunkKey(key, record, i)
+		err := m.client.Touch(k, int32(record.ExpiresAt.Unix()))
+		m.nodes.observe(k, err)
+		if err != nil {
+			if err == memcache.ErrCacheMiss {
+				return domain.ErrNotFound
+			}
+			return err
+		}
+	}
+	return m.update(ctx, item, record)
+}
+
+// Ping probes every node and succeeds while at least one of them answers,
+// since a failed node only affects the keys it owns.
+func (m *Memcached) Ping(ctx context.Context) error {
+	return m.nodes.ping(ctx, m.client.Timeout)
+}
+
+// get fetches the raw item for key and treats claimed secrets as missing.
+// The record parsed from the item is returned as well, unless the item
+// holds a secret stored inline.
+func (m *Memcached) get(ctx context.Context, key string) (*memcache.Item, *memcachedRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	item, err := m.client.Get(key)
+	m.nodes.observe(key, err)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, nil, domain.ErrNotFound
+		}
+		return nil, nil, err
+	}
+	if bytes.Equal(item.Value, tombstone) {
+		return nil, nil, domain.ErrNotFound
+	}
+	record, err := parseRecord(item.Value)
+	if err != nil {
+		return nil, nil, err
 	}
-	return s.OneTime, nil
+	return item, record, nil
 }
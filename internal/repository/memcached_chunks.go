@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// defaultMemcachedChunkSize keeps every item well below the 1 MiB item size
+// limit memcached enforces by default.
+const defaultMemcachedChunkSize = 512 * 1024
+
+// metaPrefix marks an item holding a memcachedRecord. The record is stored
+// under the secret key and the message in separate chunk items, so the
+// metadata can be read without fetching the message.
+var metaPrefix = []byte("yopass:meta:")
+
+// memcachedRecord holds the metadata of a secret and points at the Chunks
+// items its message is split over. Their keys are derived from the secret
+// key and the random ID, so chunks of a replaced secret never mix with the
+// current ones. Only the record knows the ID, which makes the chunks
+// unreachable as soon as the record is gone.
+type memcachedRecord struct {
+	domain.Metadata
+	ID     string `json:"id"`
+	Chunks int    `json:"chunks"`
+}
+
+// parseRecord returns the record stored in value. It returns nil for a
+// secret stored inline as JSON, as secrets were before the metadata was
+// split off.
+func parseRecord(value []byte) (*memcachedRecord, error) {
+	data, ok := bytes.CutPrefix(value, metaPrefix)
+	if !ok {
+		return nil, nil
+	}
+	var record memcachedRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid secret record: %w", err)
+	}
+	return &record, nil
+}
+
+// chunkSeparator joins the secret key and the rest of a chunk key.
+const chunkSeparator = ":chunk:"
+
+func chunkKey(key string, record *memcachedRecord, i int) string {
+	return fmt.Sprintf("%s%s%s:%d", key, chunkSeparator, record.ID, i)
+}
+
+// shardKey returns the key that decides which node stores key. Chunks are
+// stored with their secret.
+func shardKey(key string) string {
+	if secret, _, ok := strings.Cut(key, chunkSeparator); ok {
+		return secret
+	}
+	return key
+}
+
+// putChunks writes data in chunks and returns a record pointing at them.
+// The chunks expire together with the record, which has to be written last.
+func (m *Memcached) putChunks(ctx context.Context, key string, data []byte, expiration int32) (*memcachedRecord, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	record := &memcachedRecord{
+		ID:     hex.EncodeToString(id),
+		Chunks: (len(data) + m.chunkSize - 1) / m.chunkSize,
+	}
+
+	for i := 0; i < record.Chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			m.deleteChunks(key, record)
+			return nil, err
+		}
+		chunk := data[i*m.chunkSize : min((i+1)*m.chunkSize, len(data))]
+		k := chunkKey(key, record, i)
+		err := m.client.Set(&memcache.Item{Key: k, Value: chunk, Expiration: expiration})
+		m.nodes.observe(k, err)
+		if err != nil {
+			m.deleteChunks(key, record)
+			return nil, fmt.Errorf("failed to store chunk %d of %d: %w", i+1, record.Chunks, err)
+		}
+	}
+	return record, nil
+}
+
+// getChunks reassembles the data described by record. A missing chunk,
+// e.g. one evicted by memcached, makes the whole secret unreadable.
+func (m *Memcached) getChunks(ctx context.Context, key string, record *memcachedRecord) ([]byte, error) {
+	var data []byte
+	for i := 0; i < record.Chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		k := chunkKey(key, record, i)
+		item, err := m.client.Get(k)
+		m.nodes.observe(k, err)
+		if err != nil {
+			if err == memcache.ErrCacheMiss {
+				return nil, domain.ErrNotFound
+			}
+			return nil, err
+		}
+		data = append(data, item.Value...)
+	}
+	return data, nil
+}
+
+// deleteChunks removes the chunks of record. It is best effort, chunks
+// that can't be deleted are unreachable anyway and expire with the secret.
+func (m *Memcached) deleteChunks(key string, record *memcachedRecord) {
+	for i := 0; i < record.Chunks; i++ {
+		k := chunkKey(key, record, i)
+		m.nodes.observe(k, m.client.Delete(k))
+	}
+}
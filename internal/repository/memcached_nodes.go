@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// errNodeDown is returned for keys owned by a memcached node that failed
+// recently, instead of waiting for yet another timeout on it.
+var errNodeDown = errors.New("memcached node is unavailable")
+
+// memcachedNodes shards keys across memcached nodes exactly like
+// memcache.ServerList, so a failed node never moves keys onto the remaining
+// ones. A node whose connection fails is skipped for retryInterval, after
+// which the next request for one of its keys probes it again.
+type memcachedNodes struct {
+	memcache.ServerList
+
+	retryInterval time.Duration
+	logger        *zap.Logger
+	failures      *prometheus.CounterVec
+	up            *prometheus.GaugeVec
+	now           func() time.Time
+
+	mu   sync.Mutex
+	down map[string]time.Time
+}
+
+func newMemcachedNodes(servers []string, retryInterval time.Duration, logger *zap.Logger, reg prometheus.Registerer) (*memcachedNodes, error) {
+	n := &memcachedNodes{
+		retryInterval: retryInterval,
+		logger:        logger,
+		failures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "yopass_memcached_node_errors_total",
+				Help: "Total number of failed requests by memcached node.",
+			},
+			[]string{"node"},
+		),
+		up: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "yopass_memcached_node_up",
+				Help: "Whether a memcached node is in use (1) or skipped after a failure (0).",
+			},
+			[]string{"node"},
+		),
+		now:  time.Now,
+		down: make(map[string]time.Time),
+	}
+	if err := n.SetServers(servers...); err != nil {
+		return nil, fmt.Errorf("invalid memcached servers: %w", err)
+	}
+	err := n.Each(func(addr net.Addr) error {
+		n.up.WithLabelValues(addr.String()).Set(1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reg != nil {
+		reg.MustRegister(n.failures, n.up)
+	}
+	return n, nil
+}
+
+// PickServer implements memcache.ServerSelector. Chunks are placed on the
+// node owning their secret, so a failed node only affects its own secrets.
+func (n *memcachedNodes) PickServer(key string) (net.Addr, error) {
+	addr, err := n.ServerList.PickServer(shardKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	until, down := n.down[addr.String()]
+	n.mu.Unlock()
+	if down && n.now().Before(until) {
+		return nil, fmt.Errorf("%w: %s", errNodeDown, addr)
+	}
+	return addr, nil
+}
+
+// observe records the outcome of a request for key against the node owning
+// it. Only connection level errors count as a node failure, protocol answers
+// such as a cache miss prove that the node is alive.
+func (n *memcachedNodes) observe(key string, err error) {
+	if errors.Is(err, errNodeDown) {
+		return
+	}
+	addr, pickErr := n.ServerList.PickServer(shardKey(key))
+	if pickErr != nil {
+		return
+	}
+	node := addr.String()
+
+	if isNodeFailure(err) {
+		n.fail(node, err)
+	} else {
+		n.recover(node)
+	}
+}
+
+func (n *memcachedNodes) fail(node string, err error) {
+	n.mu.Lock()
+	_, wasDown := n.down[node]
+	n.down[node] = n.now().Add(n.retryInterval)
+	n.mu.Unlock()
+
+	n.failures.WithLabelValues(node).Inc()
+	n.up.WithLabelValues(node).Set(0)
+	if !wasDown {
+		n.logger.Warn("Memcached node failed, skipping it",
+			zap.String("node", node), zap.Duration("retryIn", n.retryInterval), zap.Error(err))
+	}
+}
+
+func (n *memcachedNodes) recover(node string) {
+	n.mu.Lock()
+	_, wasDown := n.down[node]
+	delete(n.down, node)
+	n.mu.Unlock()
+
+	if wasDown {
+		n.up.WithLabelValues(node).Set(1)
+		n.logger.Info("Memcached node recovered", zap.String("node", node))
+	}
+}
+
+// ping probes every node and updates its health. It fails only if no node
+// is reachable.
+func (n *memcachedNodes) ping(ctx context.Context, timeout time.Duration) error {
+	var nodes int
+	var errs []error
+	err := n.Each(func(addr net.Addr) error {
+		nodes++
+		if err := pingMemcachedNode(ctx, addr, timeout); err != nil {
+			n.fail(addr.String(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		} else {
+			n.recover(addr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) < nodes {
+		return nil
+	}
+	return fmt.Errorf("no memcached node is reachable: %w", errors.Join(errs...))
+}
+
+// pingMemcachedNode asks the node at addr for its version on a connection
+// of its own, so a probe never waits for a connection from the pool.
+func pingMemcachedNode(ctx context.Context, addr net.Addr, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = memcache.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, addr.Network(), addr.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(conn, "version\r\n"); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "VERSION ") {
+		return fmt.Errorf("unexpected response %q", strings.TrimSpace(line))
+	}
+	return nil
+}
+
+func isNodeFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	var connectErr *memcache.ConnectTimeoutError
+	return errors.As(err, &netErr) || errors.As(err, &connectErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
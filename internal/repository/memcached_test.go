@@ -1,29 +1,45 @@
 package repository
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestMemcached(t *testing.T) {
+	ctx := context.Background()
 	memcachedURL := os.Getenv("MEMCACHED")
 	if memcachedURL == "" {
 		t.Skip("Specify MEMCACHED env variable to test memcached database")
 	}
 
-	m := NewMemcached(memcachedURL)
+	m := newTestMemcached(t, memcachedURL)
 
 	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
 	secret := domain.Secret{Message: "foo", OneTime: true}
 
-	err := m.Put(key, secret)
+	err := m.Put(ctx, key, secret)
 	if err != nil {
 		t.Fatalf("error in Put(): %v", err)
 	}
 
-	storedSecret, err := m.Get(key)
+	storedSecret, err := m.Get(ctx, key)
 	if err != nil {
 		t.Fatalf("error in Get(): %v", err)
 	}
@@ -32,15 +48,30 @@ func TestMemcached(t *testing.T) {
 		t.Fatalf("expected value %s, got %s", secret.Message, storedSecret.Message)
 	}
 
-	_, err = m.Get(key)
+	_, err = m.Get(ctx, key)
 	if err == nil {
 		t.Fatal("expected error from Get() after Delete()")
 	}
 }
 
+func newTestMemcached(t *testing.T, servers string) domain.Repository {
+	t.Helper()
+	m, err := NewMemcached(strings.Split(servers, ","), MemcachedOptions{})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	return m
+}
+
 func TestMemcachedUnits(t *testing.T) {
 	t.Run("NewMemcached creates correct instance", func(t *testing.T) {
-		db := NewMemcached("localhost:11211")
+		db, err := NewMemcached([]string{"localhost:11211"}, MemcachedOptions{
+			Timeout:      time.Second,
+			MaxIdleConns: 10,
+		})
+		if err != nil {
+			t.Fatalf("NewMemcached() error = %v", err)
+		}
 		m, ok := db.(*Memcached)
 		if !ok {
 			t.Fatal("NewMemcached should return *Memcached")
@@ -48,63 +79,712 @@ func TestMemcachedUnits(t *testing.T) {
 		if m.client == nil {
 			t.Fatal("Client should be initialized")
 		}
+		if m.client.Timeout != time.Second || m.client.MaxIdleConns != 10 {
+			t.Fatalf("expected pool options to be applied, got timeout %v and %d idle conns", m.client.Timeout, m.client.MaxIdleConns)
+		}
+	})
+
+	t.Run("NewMemcached requires a server", func(t *testing.T) {
+		if _, err := NewMemcached(nil, MemcachedOptions{}); err == nil {
+			t.Fatal("expected error without servers")
+		}
+	})
+
+	t.Run("NewMemcached rejects invalid addresses", func(t *testing.T) {
+		if _, err := NewMemcached([]string{"not a valid address"}, MemcachedOptions{}); err == nil {
+			t.Fatal("expected error for invalid address")
+		}
 	})
 }
 
+// startStubMemcached serves just enough of the memcached text protocol to
+// store items and answer every lookup with a miss.
+func startStubMemcached(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						continue
+					}
+					switch fields[0] {
+					case "set":
+						size, _ := strconv.Atoi(fields[4])
+						if _, err := io.CopyN(io.Discard, r, int64(size)+2); err != nil {
+							return
+						}
+						io.WriteString(conn, "STORED\r\n")
+					case "get", "gets":
+						io.WriteString(conn, "END\r\n")
+					case "version":
+						io.WriteString(conn, "VERSION 1.6.0\r\n")
+					default:
+						io.WriteString(conn, "ERROR\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// closedAddress returns an address nothing listens on.
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestMemcachedNodeFailure(t *testing.T) {
+	ctx := context.Background()
+	live := startStubMemcached(t)
+	dead := closedAddress(t)
+	reg := prometheus.NewRegistry()
+
+	repo, err := NewMemcached([]string{live, dead}, MemcachedOptions{
+		Timeout:       time.Second,
+		RetryInterval: time.Minute,
+		Registerer:    reg,
+	})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	m := repo.(*Memcached)
+
+	// Find one key owned by each node.
+	keys := map[string]string{}
+	for i := 0; len(keys) < 2 && i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := m.nodes.ServerList.PickServer(key)
+		if err != nil {
+			t.Fatalf("error in PickServer(): %v", err)
+		}
+		if _, ok := keys[addr.String()]; !ok {
+			keys[addr.String()] = key
+		}
+	}
+	liveKey, deadKey := keys[live], keys[dead]
+	if liveKey == "" || deadKey == "" {
+		t.Fatal("failed to find a key for every node")
+	}
+
+	secret := domain.Secret{Message: "foo", Expiration: 3600}
+	if err := m.Put(ctx, deadKey, secret); err == nil || errors.Is(err, errNodeDown) {
+		t.Fatalf("expected connection error from the dead node, got %v", err)
+	}
+	if err := m.Put(ctx, deadKey, secret); !errors.Is(err, errNodeDown) {
+		t.Fatalf("expected the dead node to be skipped, got %v", err)
+	}
+	if err := m.Put(ctx, liveKey, secret); err != nil {
+		t.Fatalf("expected the live node to keep working, got %v", err)
+	}
+	if _, err := m.Get(ctx, liveKey); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from the live node, got %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.nodes.failures.WithLabelValues(dead)); got != 1 {
+		t.Fatalf("expected 1 failure for the dead node, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.nodes.failures.WithLabelValues(live)); got != 0 {
+		t.Fatalf("expected no failures for the live node, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.nodes.up.WithLabelValues(dead)); got != 0 {
+		t.Fatalf("expected the dead node to be reported down, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.nodes.up.WithLabelValues(live)); got != 1 {
+		t.Fatalf("expected the live node to be reported up, got %v", got)
+	}
+
+	// Once the retry interval has passed the node is probed again.
+	m.nodes.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := m.nodes.PickServer(deadKey); err != nil {
+		t.Fatalf("expected the dead node to be retried, got %v", err)
+	}
+	m.nodes.observe(deadKey, nil)
+	if got := testutil.ToFloat64(m.nodes.up.WithLabelValues(dead)); got != 1 {
+		t.Fatalf("expected the recovered node to be reported up, got %v", got)
+	}
+}
+
+func TestMemcachedPing(t *testing.T) {
+	ctx := context.Background()
+	live := startStubMemcached(t)
+	dead := closedAddress(t)
+
+	repo, err := NewMemcached([]string{live, dead}, MemcachedOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	if err := repo.Ping(ctx); err != nil {
+		t.Fatalf("expected Ping() to succeed while one node is up, got %v", err)
+	}
+	if got := testutil.ToFloat64(repo.(*Memcached).nodes.up.WithLabelValues(dead)); got != 0 {
+		t.Fatalf("expected Ping() to mark the dead node down, got %v", got)
+	}
+
+	repo, err = NewMemcached([]string{dead}, MemcachedOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	if err := repo.Ping(ctx); err == nil {
+		t.Fatal("expected Ping() to fail without a reachable node")
+	}
+}
+
 func TestMemcachedStatus(t *testing.T) {
+	ctx := context.Background()
 	memcachedURL := os.Getenv("MEMCACHED")
 	if memcachedURL == "" {
 		t.Skip("Specify MEMCACHED env variable to test memcached database")
 	}
 
-	m := NewMemcached(memcachedURL)
+	m := newTestMemcached(t, memcachedURL)
 
 	t.Run("Status returns correct OneTime value for existing secret", func(t *testing.T) {
 		key := "test-status-onetime"
 		secret := domain.Secret{Message: "test message", OneTime: true, Expiration: 3600}
 
-		err := m.Put(key, secret)
+		err := m.Put(ctx, key, secret)
 		if err != nil {
 			t.Fatalf("error in Put(): %v", err)
 		}
 
-		oneTime, err := m.Status(key)
+		meta, err := m.Status(ctx, key)
 		if err != nil {
 			t.Fatalf("error in Status(): %v", err)
 		}
 
-		if oneTime != true {
-			t.Fatalf("expected OneTime to be true, got %v", oneTime)
+		if meta.OneTime != true {
+			t.Fatalf("expected OneTime to be true, got %v", meta.OneTime)
 		}
 
-		m.Delete(key)
+		m.Delete(ctx, key)
 	})
 
 	t.Run("Status returns correct OneTime value for non-onetime secret", func(t *testing.T) {
 		key := "test-status-multi"
 		secret := domain.Secret{Message: "test message", OneTime: false, Expiration: 3600}
 
-		err := m.Put(key, secret)
+		err := m.Put(ctx, key, secret)
 		if err != nil {
 			t.Fatalf("error in Put(): %v", err)
 		}
 
-		oneTime, err := m.Status(key)
+		meta, err := m.Status(ctx, key)
 		if err != nil {
 			t.Fatalf("error in Status(): %v", err)
 		}
 
-		if oneTime != false {
-			t.Fatalf("expected OneTime to be false, got %v", oneTime)
+		if meta.OneTime != false {
+			t.Fatalf("expected OneTime to be false, got %v", meta.OneTime)
 		}
 
-		m.Delete(key)
+		m.Delete(ctx, key)
 	})
 
 	t.Run("Status returns error for non-existent key", func(t *testing.T) {
-		_, err := m.Status("non-existent")
+		_, err := m.Status(ctx, "non-existent")
 		if err != domain.ErrNotFound {
 			t.Fatalf("expected ErrNotFound, got %v", err)
 		}
 	})
 }
+
+func TestMemcachedConcurrentOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := newTestMemcached(t, memcachedURL)
+
+	const readers = 50
+	for i := 0; i < 20; i++ {
+		key := "test-concurrent-onetime"
+		if err := m.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+
+		var wins int32
+		var wg sync.WaitGroup
+		for j := 0; j < readers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s, err := m.Get(ctx, key)
+				if err == nil {
+					if s.Message != "foo" {
+						t.Errorf("expected value foo, got %s", s.Message)
+					}
+					atomic.AddInt32(&wins, 1)
+				} else if err != domain.ErrNotFound {
+					t.Errorf("unexpected error in Get(): %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+		}
+	}
+}
+
+func TestMemcachedMaxViews(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := newTestMemcached(t, memcachedURL)
+
+	key := "test-max-views"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := m.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		meta, err := m.Status(ctx, key)
+		if err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+		if ttl := time.Until(meta.ExpiresAt); ttl <= 59*time.Minute {
+			t.Fatalf("expected counting a view to keep the expiry, got %v", meta.ExpiresAt)
+		}
+	}
+	if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestMemcachedConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := newTestMemcached(t, memcachedURL)
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestMemcachedTombstone(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := newTestMemcached(t, memcachedURL)
+	client := m.(*Memcached).client
+
+	// A tombstone left behind by a failed delete must never be readable.
+	key := "test-tombstone"
+	if err := client.Set(&memcache.Item{Key: key, Value: tombstone, Expiration: 60}); err != nil {
+		t.Fatalf("error in Set(): %v", err)
+	}
+	defer m.Delete(ctx, key)
+
+	if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get(), got %v", err)
+	}
+	if _, err := m.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status(), got %v", err)
+	}
+}
+
+func TestMemcachedStatusDoesNotConsume(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m := newTestMemcached(t, memcachedURL)
+
+	key := "test-status-not-consumed"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	if _, err := m.Status(ctx, key); err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if _, err := m.Get(ctx, key); err != nil {
+		t.Fatalf("error in Get() after Status(): %v", err)
+	}
+	if _, err := m.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after Get(), got %v", err)
+	}
+}
+
+func TestMemcachedChunking(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	repo, err := NewMemcached(strings.Split(memcachedURL, ","), MemcachedOptions{ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	m := repo.(*Memcached)
+	message := strings.Repeat("0123456789", 100)
+
+	recordOf := func(t *testing.T, key string) *memcachedRecord {
+		t.Helper()
+		_, record, err := m.get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in get(): %v", err)
+		}
+		if record == nil {
+			t.Fatal("expected secret to be stored in chunks")
+		}
+		return record
+	}
+	assertChunksDeleted := func(t *testing.T, key string, record *memcachedRecord) {
+		t.Helper()
+		for i := 0; i < record.Chunks; i++ {
+			if _, err := m.client.Get(chunkKey(key, record, i)); err != memcache.ErrCacheMiss {
+				t.Fatalf("expected chunk %d to be deleted, got %v", i, err)
+			}
+		}
+	}
+
+	t.Run("Get reassembles chunks", func(t *testing.T) {
+		key := "test-chunked-multi"
+		if err := m.Put(ctx, key, domain.Secret{Message: message, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		defer m.Delete(ctx, key)
+
+		if record := recordOf(t, key); record.Chunks < 2 {
+			t.Fatalf("expected multiple chunks, got %d", record.Chunks)
+		}
+		for i := 0; i < 2; i++ {
+			s, err := m.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("error in Get(): %v", err)
+			}
+			if s.Message != message {
+				t.Fatalf("expected reassembled message, got %d bytes", len(s.Message))
+			}
+		}
+	})
+
+	t.Run("one-time Get consumes all chunks", func(t *testing.T) {
+		key := "test-chunked-onetime"
+		if err := m.Put(ctx, key, domain.Secret{Message: message, OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		record := recordOf(t, key)
+
+		meta, err := m.Status(ctx, key)
+		if err != nil || !meta.OneTime {
+			t.Fatalf("expected Status() to report a one-time secret, got %v, %v", meta.OneTime, err)
+		}
+		if _, err := m.Get(ctx, key); err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound after one-time Get(), got %v", err)
+		}
+		assertChunksDeleted(t, key, record)
+	})
+
+	t.Run("Delete removes all chunks", func(t *testing.T) {
+		key := "test-chunked-delete"
+		if err := m.Put(ctx, key, domain.Secret{Message: message, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		record := recordOf(t, key)
+
+		deleted, err := m.Delete(ctx, key)
+		if err != nil || !deleted {
+			t.Fatalf("expected Delete() to succeed, got %v, %v", deleted, err)
+		}
+		assertChunksDeleted(t, key, record)
+	})
+
+	t.Run("missing chunk makes the secret unreadable", func(t *testing.T) {
+		key := "test-chunked-missing"
+		if err := m.Put(ctx, key, domain.Secret{Message: message, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		defer m.Delete(ctx, key)
+
+		record := recordOf(t, key)
+		if err := m.client.Delete(chunkKey(key, record, 1)); err != nil {
+			t.Fatalf("error in Delete(): %v", err)
+		}
+		if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound for an incomplete secret, got %v", err)
+		}
+	})
+
+	t.Run("small secrets are stored in a single chunk", func(t *testing.T) {
+		key := "test-chunked-small"
+		if err := m.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		defer m.Delete(ctx, key)
+
+		if record := recordOf(t, key); record.Chunks != 1 {
+			t.Fatalf("expected a single chunk, got %d", record.Chunks)
+		}
+	})
+}
+
+func TestMemcachedConcurrentChunkedOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	m, err := NewMemcached(strings.Split(memcachedURL, ","), MemcachedOptions{ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("error in NewMemcached(): %v", err)
+	}
+	message := strings.Repeat("x", 1000)
+
+	key := "test-concurrent-chunked-onetime"
+	if err := m.Put(ctx, key, domain.Secret{Message: message, OneTime: true, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < 20; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := m.Get(ctx, key)
+			if err == nil {
+				if s.Message != message {
+					t.Errorf("expected reassembled message, got %d bytes", len(s.Message))
+				}
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+	}
+}
+
+func TestMemcachedMetadata(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	repo := newTestMemcached(t, memcachedURL)
+	m := repo.(*Memcached)
+
+	key := "test-metadata"
+	secret := domain.Secret{Message: "test message", OneTime: true, Expiration: 3600, Kind: domain.KindFile}
+	if err := m.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	defer m.Delete(ctx, key)
+
+	item, err := m.client.Get(key)
+	if err != nil {
+		t.Fatalf("error reading record: %v", err)
+	}
+	if bytes.Contains(item.Value, []byte(secret.Message)) {
+		t.Fatal("expected the message to be stored apart from the metadata")
+	}
+	record, err := parseRecord(item.Value)
+	if err != nil || record == nil {
+		t.Fatalf("expected a metadata record, got %v, %v", record, err)
+	}
+	if !record.OneTime || record.Size != len(secret.Message) || record.Kind != domain.KindFile || record.ExpiresAt.Sub(record.CreatedAt) != time.Hour {
+		t.Fatalf("unexpected metadata %+v", record.Metadata)
+	}
+
+	// Status only needs the record, even with the message gone.
+	chunk, err := m.client.Get(chunkKey(key, record, 0))
+	if err != nil {
+		t.Fatalf("error reading chunk: %v", err)
+	}
+	if err := m.client.Delete(chunk.Key); err != nil {
+		t.Fatalf("error in Delete(): %v", err)
+	}
+	meta, err := m.Status(ctx, key)
+	if err != nil || meta != record.Metadata {
+		t.Fatalf("expected Status() from the record alone, got %+v, %v", meta, err)
+	}
+	if err := m.client.Set(chunk); err != nil {
+		t.Fatalf("error in Set(): %v", err)
+	}
+
+	s, err := m.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != secret.Message || !s.OneTime || s.Expiration != secret.Expiration || s.Kind != domain.KindFile {
+		t.Fatalf("expected %+v, got %+v", secret, s)
+	}
+	if _, err := m.client.Get(chunk.Key); err != memcache.ErrCacheMiss {
+		t.Fatalf("expected the message to be consumed with the metadata, got %v", err)
+	}
+}
+
+func TestMemcachedLegacySecret(t *testing.T) {
+	ctx := context.Background()
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	repo := newTestMemcached(t, memcachedURL)
+	m := repo.(*Memcached)
+
+	// Secrets written before the metadata was split off are stored inline
+	// as JSON.
+	key := "test-legacy-inline"
+	inline := `{"expiration":3600,"message":"inline","one_time":true}`
+	if err := m.client.Set(&memcache.Item{Key: key, Value: []byte(inline)}); err != nil {
+		t.Fatalf("error in Set(): %v", err)
+	}
+	meta, err := m.Status(ctx, key)
+	if err != nil || !meta.OneTime {
+		t.Fatalf("expected Status() of legacy one-time secret, got %v, %v", meta.OneTime, err)
+	}
+	s, err := m.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != "inline" || !s.OneTime || s.Expiration != 3600 {
+		t.Fatalf("unexpected legacy secret %+v", s)
+	}
+	if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected legacy one-time secret to be consumed, got %v", err)
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	record, err := parseRecord([]byte(`{"message":"foo"}`))
+	if err != nil || record != nil {
+		t.Fatalf("expected no record for an inline secret, got %v, %v", record, err)
+	}
+
+	record, err = parseRecord(append(bytes.Clone(metaPrefix), `{"id":"abc","chunks":3,"one_time":true,"size":5,"kind":"file"}`...))
+	if err != nil {
+		t.Fatalf("error in parseRecord(): %v", err)
+	}
+	expected := memcachedRecord{
+		Metadata: domain.Metadata{OneTime: true, Size: 5, Kind: domain.KindFile},
+		ID:       "abc",
+		Chunks:   3,
+	}
+	if *record != expected {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	if _, err := parseRecord(append(bytes.Clone(metaPrefix), '{')); err == nil {
+		t.Fatal("expected error for a malformed record")
+	}
+}
+
+func TestShardKey(t *testing.T) {
+	record := &memcachedRecord{ID: "abc"}
+	if got := shardKey(chunkKey("secret", record, 2)); got != "secret" {
+		t.Fatalf("expected chunk to be sharded with its secret, got %s", got)
+	}
+}
+
+func TestMemcachedReceipt(t *testing.T) {
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	testRepositoryReceipt(t, func(t *testing.T) domain.Repository {
+		return newTestMemcached(t, memcachedURL)
+	})
+}
+
+func TestMemcachedExtend(t *testing.T) {
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	testRepositoryExtend(t, newTestMemcached(t, memcachedURL))
+}
+
+func TestMemcachedPeek(t *testing.T) {
+	memcachedURL := os.Getenv("MEMCACHED")
+	if memcachedURL == "" {
+		t.Skip("Specify MEMCACHED env variable to test memcached database")
+	}
+
+	testRepositoryPeek(t, newTestMemcached(t, memcachedURL))
+}
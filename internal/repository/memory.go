@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// ErrRepositoryFull is returned by Put when a size capped repository cannot
+// accept any more secrets.
+var ErrRepositoryFull = errors.New("repository is full")
+
+// memoryCleanupInterval is how often the janitor evicts expired secrets.
+const memoryCleanupInterval = time.Minute
+
+type memoryEntry struct {
+	secret    domain.Secret
+	meta      domain.Metadata
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// Memory keeps secrets in process memory. It is meant for development and
+// ephemeral environments, every secret is lost when the process exits.
+type Memory struct {
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	maxSecrets int
+	now        func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemory returns an in-process repository holding at most maxSecrets
+// secrets, or an unlimited number if maxSecrets is zero or negative. A
+// background janitor evicts expired secrets until Close is called.
+func NewMemory(maxSecrets int) domain.Repository {
+	m := &Memory{
+		entries:    make(map[string]memoryEntry),
+		maxSecrets: maxSecrets,
+		now:        time.Now,
+		done:       make(chan struct{}),
+	}
+	go runJanitor(memoryCleanupInterval, m.done, m.evictExpired)
+	return m
+}
+
+func (m *Memory) Get(_ context.Context, key string) (domain.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok || e.meta.Consumed() {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	s := e.secret
+	meta, last := e.meta.Viewed(m.now())
+	s.RemainingViews = meta.RemainingViews
+	switch {
+	case last && !meta.KeepReceipt():
+		delete(m.entries, key)
+	case last:
+		e.secret = domain.Secret{}
+		fallthrough
+	default:
+		e.meta = meta
+		m.entries[key] = e
+	}
+	return s, nil
+}
+
+func (m *Memory) Peek(_ context.Context, key string) (domain.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok || e.meta.Consumed() {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	s := e.secret
+	s.RemainingViews = e.meta.RemainingViews
+	return s, nil
+}
+
+func (m *Memory) Put(_ context.Context, key string, secret domain.Secret) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.lookup(key); !exists && m.maxSecrets > 0 && len(m.entries) >= m.maxSecrets {
+		m.evictExpiredLocked()
+		if len(m.entries) >= m.maxSecrets {
+			return ErrRepositoryFull
+		}
+	}
+
+	now := m.now()
+	e := memoryEntry{secret: secret, meta: domain.NewMetadata(secret, now)}
+	if secret.Expiration > 0 {
+		e.expiresAt = now.Add(time.Duration(secret.Expiration) * time.Second)
+	}
+	m.entries[key] = e
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lookup(key); !ok {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *Memory) Status(_ context.Context, key string) (domain.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		return domain.Metadata{}, domain.ErrNotFound
+	}
+	return e.meta, nil
+}
+
+func (m *Memory) Extend(_ context.Context, key string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok || e.meta.Consumed() {
+		return domain.ErrNotFound
+	}
+	e.expiresAt = expiresAt
+	e.meta.ExpiresAt = expiresAt.UTC().Truncate(time.Second)
+	e.secret.Expiration = int32(e.meta.ExpiresAt.Sub(e.meta.CreatedAt) / time.Second)
+	m.entries[key] = e
+	return nil
+}
+
+// Ping always succeeds, the secrets live in the process itself.
+func (m *Memory) Ping(_ context.Context) error {
+	return nil
+}
+
+// Close stops the background janitor.
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
+
+// lookup returns the live entry for key, dropping it if it has expired.
+// The caller must hold m.mu.
+func (m *Memory) lookup(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return e, false
+	}
+	if e.expired(m.now()) {
+		delete(m.entries, key)
+		return e, false
+	}
+	return e, true
+}
+
+func (m *Memory) evictExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictExpiredLocked()
+}
+
+func (m *Memory) evictExpiredLocked() {
+	now := m.now()
+	for key, e := range m.entries {
+		if e.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
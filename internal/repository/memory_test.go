@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+func newTestMemory(t *testing.T, maxSecrets int) *Memory {
+	t.Helper()
+	m := NewMemory(maxSecrets).(*Memory)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+
+	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
+	secret := domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}
+
+	if err := m.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	meta, err := m.Status(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if !meta.OneTime {
+		t.Fatal("expected OneTime to be true")
+	}
+
+	storedSecret, err := m.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if storedSecret.Message != secret.Message {
+		t.Fatalf("expected value %s, got %s", secret.Message, storedSecret.Message)
+	}
+
+	if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get() after one-time read, got %v", err)
+	}
+}
+
+func TestMemoryStatus(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	secret := domain.Secret{Message: "foo", Expiration: 3600, Kind: domain.KindFile, Size: 2}
+	if err := m.Put(ctx, "key", secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	meta, err := m.Status(ctx, "key")
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	want := domain.Metadata{Size: 2, Kind: domain.KindFile, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if meta != want {
+		t.Fatalf("expected %+v, got %+v", want, meta)
+	}
+}
+
+func TestMemoryMultiRead(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+
+	key := "test-multi"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := m.Get(ctx, key); err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+	}
+
+	deleted, err := m.Delete(ctx, key)
+	if err != nil || !deleted {
+		t.Fatalf("expected Delete() to remove the secret, got %v, %v", deleted, err)
+	}
+	deleted, err = m.Delete(ctx, key)
+	if err != nil || deleted {
+		t.Fatalf("expected second Delete() to report nothing deleted, got %v, %v", deleted, err)
+	}
+	if _, err := m.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status() after Delete(), got %v", err)
+	}
+}
+
+func TestMemoryExpiration(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if err := m.Put(ctx, "expiring", domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := m.Put(ctx, "forever", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	now = now.Add(59 * time.Second)
+	if _, err := m.Get(ctx, "expiring"); err != nil {
+		t.Fatalf("expected secret to be readable before expiration, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := m.Get(ctx, "expiring"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after expiration, got %v", err)
+	}
+	if _, err := m.Get(ctx, "forever"); err != nil {
+		t.Fatalf("expected secret without expiration to be readable, got %v", err)
+	}
+}
+
+func TestMemoryEvictExpired(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := m.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+	}
+	if err := m.Put(ctx, "d", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	m.evictExpired()
+
+	if len(m.entries) != 1 {
+		t.Fatalf("expected 1 secret after eviction, got %d", len(m.entries))
+	}
+	if _, ok := m.entries["d"]; !ok {
+		t.Fatal("expected unexpired secret to be kept")
+	}
+}
+
+func TestMemoryMaxSecrets(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 2)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if err := m.Put(ctx, "a", domain.Secret{Message: "foo", Expiration: 60}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := m.Put(ctx, "b", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := m.Put(ctx, "c", domain.Secret{Message: "foo", Expiration: 3600}); err != ErrRepositoryFull {
+		t.Fatalf("expected ErrRepositoryFull, got %v", err)
+	}
+	if err := m.Put(ctx, "b", domain.Secret{Message: "bar", Expiration: 3600}); err != nil {
+		t.Fatalf("expected overwriting an existing key to succeed, got %v", err)
+	}
+
+	// Expired secrets make room for new ones.
+	now = now.Add(time.Minute)
+	if err := m.Put(ctx, "c", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put() after expiration: %v", err)
+	}
+}
+
+func TestMemoryConcurrentOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+
+	const readers = 50
+	for i := 0; i < 20; i++ {
+		key := "test-concurrent-onetime"
+		if err := m.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+
+		var wins int32
+		var wg sync.WaitGroup
+		for j := 0; j < readers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := m.Get(ctx, key); err == nil {
+					atomic.AddInt32(&wins, 1)
+				} else if err != domain.ErrNotFound {
+					t.Errorf("unexpected error in Get(): %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+		}
+	}
+}
+
+func TestMemoryMaxViews(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+
+	key := "test-max-views"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := m.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		if meta, err := m.Status(ctx, key); err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+	}
+	if _, err := m.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestMemoryConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t, 0)
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := m.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	m := NewMemory(0).(*Memory)
+	if err := m.Close(); err != nil {
+		t.Fatalf("error in Close(): %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("expected Close() to be idempotent, got %v", err)
+	}
+}
+
+func TestMemoryReceipt(t *testing.T) {
+	testRepositoryReceipt(t, func(t *testing.T) domain.Repository {
+		return newTestMemory(t, 0)
+	})
+}
+
+func TestMemoryExtend(t *testing.T) {
+	testRepositoryExtend(t, newTestMemory(t, 0))
+}
+
+func TestMemoryPeek(t *testing.T) {
+	testRepositoryPeek(t, newTestMemory(t, 0))
+}
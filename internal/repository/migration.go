@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"go.uber.org/zap"
+)
+
+// MigrationMode selects how a Migration repository spreads secrets over its
+// two backends.
+type MigrationMode string
+
+const (
+	// MigrationFallback writes new secrets to the primary only and reads
+	// from the primary, then from the secondary. Point the primary at the
+	// new backend and the secondary at the old one, and drop the secondary
+	// once its secrets have expired.
+	MigrationFallback MigrationMode = "fallback"
+	// MigrationMirror writes every secret to both backends but only reads
+	// from the primary. Point the primary at the old backend and the
+	// secondary at the new one, and switch over to the secondary once the
+	// secrets written before mirroring started have expired.
+	MigrationMirror MigrationMode = "mirror"
+)
+
+// Migration combines two backends so secrets survive switching from one to
+// the other. Deleting a secret removes it from both, and reading a one-time
+// or multi-view secret while mirroring removes its copy from the secondary,
+// so it can't be read more often after switching over.
+type Migration struct {
+	primary   domain.Repository
+	secondary domain.Repository
+	mode      MigrationMode
+	logger    *zap.Logger
+}
+
+// NewMigration returns a repository migrating between primary and secondary
+// according to mode. logger may be nil.
+func NewMigration(primary, secondary domain.Repository, mode MigrationMode, logger *zap.Logger) (domain.Repository, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	switch mode {
+	case MigrationFallback, MigrationMirror:
+		return &Migration{primary: primary, secondary: secondary, mode: mode, logger: logger}, nil
+	default:
+		return nil, fmt.Errorf("unsupported migration mode: %s", mode)
+	}
+}
+
+func (m *Migration) Get(ctx context.Context, key string) (domain.Secret, error) {
+	s, err := m.primary.Get(ctx, key)
+	if errors.Is(err, domain.ErrNotFound) && m.mode == MigrationFallback {
+		return m.secondary.Get(ctx, key)
+	}
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	if m.mode == MigrationMirror {
+		m.unmirror(ctx, key, s)
+	}
+	return s, nil
+}
+
+// unmirror removes the copy of a one-time or multi-view secret from the
+// secondary once it has been read from the primary, so the copy can't be
+// read more often after switching over. The view has already been counted
+// by then, so a failure is only logged.
+func (m *Migration) unmirror(ctx context.Context, key string, s domain.Secret) {
+	if !s.OneTime && s.MaxViews == 0 {
+		return
+	}
+	if _, err := m.secondary.Delete(ctx, key); err != nil {
+		m.logger.Error("Failed to remove mirrored copy of read secret", zap.Error(err))
+	}
+}
+
+func (m *Migration) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	s, err := m.primary.Peek(ctx, key)
+	if !errors.Is(err, domain.ErrNotFound) || m.mode == MigrationMirror {
+		return s, err
+	}
+	return m.secondary.Peek(ctx, key)
+}
+
+func (m *Migration) Put(ctx context.Context, key string, secret domain.Secret) error {
+	if err := m.primary.Put(ctx, key, secret); err != nil {
+		return err
+	}
+	if m.mode != MigrationMirror {
+		return nil
+	}
+
+	if err := m.secondary.Put(ctx, key, secret); err != nil {
+		// The secret is never handed out, so don't leave half of it behind.
+		_, _ = m.primary.Delete(ctx, key)
+		return fmt.Errorf("failed to mirror secret: %w", err)
+	}
+	return nil
+}
+
+func (m *Migration) Delete(ctx context.Context, key string) (bool, error) {
+	deletedPrimary, errPrimary := m.primary.Delete(ctx, key)
+	deletedSecondary, errSecondary := m.secondary.Delete(ctx, key)
+	if err := errors.Join(errPrimary, errSecondary); err != nil {
+		return false, err
+	}
+	return deletedPrimary || deletedSecondary, nil
+}
+
+func (m *Migration) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	meta, err := m.primary.Status(ctx, key)
+	if !errors.Is(err, domain.ErrNotFound) || m.mode == MigrationMirror {
+		return meta, err
+	}
+	return m.secondary.Status(ctx, key)
+}
+
+// Extend moves the expiry of the secret in every backend that stores it.
+func (m *Migration) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	err := m.primary.Extend(ctx, key, expiresAt)
+	switch {
+	case m.mode == MigrationFallback && errors.Is(err, domain.ErrNotFound):
+		return m.secondary.Extend(ctx, key, expiresAt)
+	case m.mode == MigrationMirror && err == nil:
+		if err := m.secondary.Extend(ctx, key, expiresAt); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return fmt.Errorf("failed to extend mirrored secret: %w", err)
+		}
+	}
+	return err
+}
+
+// Ping checks both backends, since secrets may be stored in either of them.
+func (m *Migration) Ping(ctx context.Context) error {
+	return errors.Join(m.primary.Ping(ctx), m.secondary.Ping(ctx))
+}
+
+// Close closes both backends if they hold any resources.
+func (m *Migration) Close() error {
+	var errs []error
+	for _, repo := range []domain.Repository{m.primary, m.secondary} {
+		if closer, ok := repo.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
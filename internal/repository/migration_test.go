@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// failingRepository rejects every operation.
+type failingRepository struct{}
+
+var errFailingRepository = errors.New("database unavailable")
+
+func (failingRepository) Get(ctx context.Context, key string) (domain.Secret, error) {
+	return domain.Secret{}, errFailingRepository
+}
+
+func (failingRepository) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	return domain.Secret{}, errFailingRepository
+}
+
+func (failingRepository) Put(ctx context.Context, key string, secret domain.Secret) error {
+	return errFailingRepository
+}
+
+func (failingRepository) Delete(ctx context.Context, key string) (bool, error) {
+	return false, errFailingRepository
+}
+
+func (failingRepository) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	return domain.Metadata{}, errFailingRepository
+}
+
+func (failingRepository) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return errFailingRepository
+}
+
+func (failingRepository) Ping(ctx context.Context) error {
+	return errFailingRepository
+}
+
+func newTestMigration(t *testing.T, mode MigrationMode) (domain.Repository, domain.Repository, domain.Repository) {
+	t.Helper()
+	primary, secondary := newTestMemory(t, 0), newTestMemory(t, 0)
+	m, err := NewMigration(primary, secondary, mode, nil)
+	if err != nil {
+		t.Fatalf("error in NewMigration(): %v", err)
+	}
+	return m, primary, secondary
+}
+
+func TestMigrationFallback(t *testing.T) {
+	ctx := context.Background()
+	m, primary, secondary := newTestMigration(t, MigrationFallback)
+
+	if err := m.Put(ctx, "new", domain.Secret{Message: "new"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := primary.Status(ctx, "new"); err != nil {
+		t.Fatalf("expected new secret in primary, got %v", err)
+	}
+	if _, err := secondary.Status(ctx, "new"); err != domain.ErrNotFound {
+		t.Fatalf("expected new secret not to be written to secondary, got %v", err)
+	}
+
+	// Secrets stored before the migration are still served.
+	if err := secondary.Put(ctx, "old", domain.Secret{Message: "old", OneTime: true}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	meta, err := m.Status(ctx, "old")
+	if err != nil || !meta.OneTime {
+		t.Fatalf("expected Status() of old one-time secret, got %v, %v", meta.OneTime, err)
+	}
+	s, err := m.Get(ctx, "old")
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != "old" {
+		t.Fatalf("expected value old, got %s", s.Message)
+	}
+	if _, err := m.Get(ctx, "old"); err != domain.ErrNotFound {
+		t.Fatalf("expected one-time secret to be consumed, got %v", err)
+	}
+
+	if err := secondary.Put(ctx, "deleted", domain.Secret{Message: "deleted"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	deleted, err := m.Delete(ctx, "deleted")
+	if err != nil || !deleted {
+		t.Fatalf("expected Delete() to remove secret from secondary, got %v, %v", deleted, err)
+	}
+	if _, err := m.Get(ctx, "deleted"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after Delete(), got %v", err)
+	}
+	if deleted, err := m.Delete(ctx, "missing"); err != nil || deleted {
+		t.Fatalf("expected Delete() of missing key to report false, got %v, %v", deleted, err)
+	}
+}
+
+func TestMigrationMirror(t *testing.T) {
+	ctx := context.Background()
+	m, primary, secondary := newTestMigration(t, MigrationMirror)
+
+	if err := m.Put(ctx, "key", domain.Secret{Message: "foo", OneTime: true}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for name, repo := range map[string]domain.Repository{"primary": primary, "secondary": secondary} {
+		if _, err := repo.Status(ctx, "key"); err != nil {
+			t.Fatalf("expected secret in %s, got %v", name, err)
+		}
+	}
+
+	if _, err := m.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	for name, repo := range map[string]domain.Repository{"primary": primary, "secondary": secondary} {
+		if _, err := repo.Status(ctx, "key"); err != domain.ErrNotFound {
+			t.Fatalf("expected one-time secret to be consumed in %s, got %v", name, err)
+		}
+	}
+
+	// A multi-view secret is removed from the secondary with its first
+	// view, receipt or not, since the secondary can't tell how many views
+	// are left.
+	if err := m.Put(ctx, "views", domain.Secret{Message: "foo", MaxViews: 2, Expiration: 3600, TokenHash: "hash"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if s, err := m.Get(ctx, "views"); err != nil || s.RemainingViews != 1 {
+		t.Fatalf("expected one remaining view, got %+v, %v", s, err)
+	}
+	if _, err := secondary.Status(ctx, "views"); err != domain.ErrNotFound {
+		t.Fatalf("expected multi-view secret to be removed from secondary, got %v", err)
+	}
+	if s, err := m.Get(ctx, "views"); err != nil || s.RemainingViews != 0 {
+		t.Fatalf("expected the last view, got %+v, %v", s, err)
+	}
+	if meta, err := primary.Status(ctx, "views"); err != nil || !meta.Consumed() {
+		t.Fatalf("expected receipt of consumed secret in primary, got %+v, %v", meta, err)
+	}
+
+	// Other secrets stay mirrored when read.
+	if err := m.Put(ctx, "reusable", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := m.Get(ctx, "reusable"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if _, err := secondary.Status(ctx, "reusable"); err != nil {
+		t.Fatalf("expected secret to stay in secondary, got %v", err)
+	}
+
+	// Only the primary serves reads while mirroring.
+	if err := secondary.Put(ctx, "secondary-only", domain.Secret{Message: "foo"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := m.Get(ctx, "secondary-only"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for secret missing in primary, got %v", err)
+	}
+	if _, err := m.Status(ctx, "secondary-only"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status(), got %v", err)
+	}
+}
+
+func TestMigrationExtend(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+
+	m, _, secondary := newTestMigration(t, MigrationFallback)
+	if err := secondary.Put(ctx, "old", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := m.Extend(ctx, "old", expiresAt); err != nil {
+		t.Fatalf("error in Extend(): %v", err)
+	}
+	if meta, err := secondary.Status(ctx, "old"); err != nil || !meta.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected secret in secondary to be extended, got %+v, %v", meta, err)
+	}
+
+	m, primary, secondary := newTestMigration(t, MigrationMirror)
+	if err := m.Put(ctx, "key", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if err := m.Extend(ctx, "key", expiresAt); err != nil {
+		t.Fatalf("error in Extend(): %v", err)
+	}
+	for name, repo := range map[string]domain.Repository{"primary": primary, "secondary": secondary} {
+		if meta, err := repo.Status(ctx, "key"); err != nil || !meta.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("expected secret in %s to be extended, got %+v, %v", name, meta, err)
+		}
+	}
+}
+
+func TestMigrationMirrorPutFailure(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestMemory(t, 0)
+	m, err := NewMigration(primary, failingRepository{}, MigrationMirror, nil)
+	if err != nil {
+		t.Fatalf("error in NewMigration(): %v", err)
+	}
+
+	if err := m.Put(ctx, "key", domain.Secret{Message: "foo"}); !errors.Is(err, errFailingRepository) {
+		t.Fatalf("expected mirror error from Put(), got %v", err)
+	}
+	if _, err := primary.Status(ctx, "key"); err != domain.ErrNotFound {
+		t.Fatalf("expected failed secret to be removed from primary, got %v", err)
+	}
+}
+
+func TestMigrationSecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestMemory(t, 0)
+	m, err := NewMigration(primary, failingRepository{}, MigrationFallback, nil)
+	if err != nil {
+		t.Fatalf("error in NewMigration(): %v", err)
+	}
+
+	if err := primary.Put(ctx, "key", domain.Secret{Message: "foo", OneTime: true}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := m.Get(ctx, "key"); err != nil {
+		t.Fatalf("expected Get() from primary not to touch the secondary, got %v", err)
+	}
+	if _, err := m.Delete(ctx, "key"); !errors.Is(err, errFailingRepository) {
+		t.Fatalf("expected Delete() to report the secondary error, got %v", err)
+	}
+	if _, err := m.Get(ctx, "missing"); !errors.Is(err, errFailingRepository) {
+		t.Fatalf("expected Get() to report the secondary error, got %v", err)
+	}
+}
+
+func TestMigrationMirrorDeleteFailure(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestMemory(t, 0)
+	m, err := NewMigration(primary, failingRepository{}, MigrationMirror, nil)
+	if err != nil {
+		t.Fatalf("error in NewMigration(): %v", err)
+	}
+
+	if err := primary.Put(ctx, "key", domain.Secret{Message: "foo", OneTime: true}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if s, err := m.Get(ctx, "key"); err != nil || s.Message != "foo" {
+		t.Fatalf("expected the secret despite the secondary error, got %+v, %v", s, err)
+	}
+	if _, err := m.Get(ctx, "key"); err != domain.ErrNotFound {
+		t.Fatalf("expected one-time secret to be consumed, got %v", err)
+	}
+}
+
+func TestNewMigrationUnsupportedMode(t *testing.T) {
+	if _, err := NewMigration(failingRepository{}, failingRepository{}, "invalid", nil); err == nil {
+		t.Fatal("expected error for unsupported migration mode")
+	}
+}
+
+func TestMigrationPing(t *testing.T) {
+	ctx := context.Background()
+	m, _, _ := newTestMigration(t, MigrationFallback)
+	if err := m.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
+	m, err := NewMigration(newTestMemory(t, 0), failingRepository{}, MigrationFallback, nil)
+	if err != nil {
+		t.Fatalf("error in NewMigration(): %v", err)
+	}
+	if err := m.Ping(ctx); !errors.Is(err, errFailingRepository) {
+		t.Fatalf("expected Ping() to report the secondary error, got %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+)
+
+// postgresSweepInterval is how often expired rows are purged.
+const postgresSweepInterval = time.Minute
+
+// postgresMaintenanceTimeout bounds migrations and sweeps, which don't run
+// on behalf of a request.
+const postgresMaintenanceTimeout = 30 * time.Second
+
+// postgresMigrations are applied in order, each exactly once. Never edit a
+// released migration, append a new one instead.
+var postgresMigrations = []string{
+	`CREATE TABLE yopass_secrets (
+		key             TEXT PRIMARY KEY,
+		message         BYTEA NOT NULL,
+		one_time        BOOLEAN NOT NULL DEFAULT FALSE,
+		expiration      INTEGER NOT NULL DEFAULT 0,
+		expires_at      TIMESTAMPTZ,
+		size            INTEGER NOT NULL DEFAULT 0,
+		kind            TEXT NOT NULL DEFAULT 'message',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		max_views       INTEGER,
+		remaining_views INTEGER,
+		token_hash      TEXT,
+		viewed_at       TIMESTAMPTZ,
+		notify_url      TEXT
+	)`,
+	`CREATE INDEX yopass_secrets_expires_at ON yopass_secrets (expires_at)`,
+}
+
+// postgresMigrationLock is the advisory lock key that serializes migrations
+// when several servers start at the same time.
+const postgresMigrationLock = 0x796f70617373
+
+// unexpired restricts a query to secrets that haven't expired yet. Expired
+// rows stay invisible until the sweeper removes them.
+const unexpired = `(expires_at IS NULL OR expires_at > now())`
+
+// live restricts a query to unexpired secrets that can still be read, as
+// opposed to receipts left behind by their last view.
+const live = `(` + unexpired + ` AND (NOT one_time OR viewed_at IS NULL) AND (remaining_views IS NULL OR remaining_views > 0))`
+
+// receiptless matches consumed secrets that don't keep a receipt.
+const receiptless = `(token_hash IS NULL OR expires_at IS NULL)`
+
+type Postgres struct {
+	db *sql.DB
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPostgres connects to the PostgreSQL database at dsn, migrates its
+// schema and starts a background sweeper that purges expired secrets until
+// Close is called.
+func NewPostgres(dsn string) (domain.Repository, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postgresMaintenanceTimeout)
+	defer cancel()
+	if err := migratePostgres(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	p := &Postgres{db: db, done: make(chan struct{})}
+	go runJanitor(postgresSweepInterval, p.done, func() { _ = p.sweep() })
+	return p, nil
+}
+
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS yopass_schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM yopass_schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO yopass_schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get returns the secret stored under key and records its view. The row
+// is locked first, so concurrent readers queue up on it and never both get
+// the last view. The last view of a secret that doesn't keep a receipt
+// deletes the row; any other view updates it, wiping the message with the
+// last one.
+func (p *Postgres) Get(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	err := p.db.QueryRowContext(
+		ctx,
+		`WITH old AS (
+			SELECT key, message, one_time, expiration, max_views, remaining_views, kind, notify_url,
+				(one_time OR remaining_views = 1) AND `+receiptless+` AS gone
+			FROM yopass_secrets WHERE key = $1 AND `+live+` FOR UPDATE
+		), deleted AS (
+			DELETE FROM yopass_secrets s USING old WHERE s.key = old.key AND old.gone
+			RETURNING s.key
+		), viewed AS (
+			UPDATE yopass_secrets s SET
+				remaining_views = s.remaining_views - 1,
+				viewed_at = COALESCE(s.viewed_at, now()),
+				message = CASE WHEN s.one_time OR s.remaining_views = 1 THEN ''::bytea ELSE s.message END
+			FROM old WHERE s.key = old.key AND NOT old.gone
+			RETURNING s.key
+		)
+		SELECT message, one_time, expiration, COALESCE(max_views, 0), COALESCE(remaining_views - 1, 0),
+			kind, COALESCE(notify_url, '')
+		FROM old`,
+		key,
+	).Scan(&s.Message, &s.OneTime, &s.Expiration, &s.MaxViews, &s.RemainingViews, &s.Kind, &s.NotifyURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	return s, nil
+}
+
+func (p *Postgres) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	err := p.db.QueryRowContext(
+		ctx,
+		`SELECT message, one_time, expiration, COALESCE(max_views, 0), COALESCE(remaining_views, 0),
+			kind, COALESCE(notify_url, '')
+		FROM yopass_secrets WHERE key = $1 AND `+live,
+		key,
+	).Scan(&s.Message, &s.OneTime, &s.Expiration, &s.MaxViews, &s.RemainingViews, &s.Kind, &s.NotifyURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	return s, nil
+}
+
+func (p *Postgres) Put(ctx context.Context, key string, secret domain.Secret) error {
+	meta := domain.NewMetadata(secret, time.Now())
+	var views sql.NullInt32
+	if meta.MaxViews > 0 {
+		views = sql.NullInt32{Int32: int32(meta.MaxViews), Valid: true}
+	}
+	_, err := p.db.ExecContext(
+		ctx,
+		`INSERT INTO yopass_secrets (key, message, one_time, expiration, expires_at, size, kind, created_at, max_views, remaining_views, token_hash, viewed_at, notify_url)
+		VALUES ($1, $2, $3, $4, CASE WHEN $4::integer > 0 THEN now() + make_interval(secs => $4::integer) END, $5, $6, now(), $7, $7, $8, NULL, $9)
+		ON CONFLICT (key) DO UPDATE SET
+			message = EXCLUDED.message,
+			one_time = EXCLUDED.one_time,
+			expiration = EXCLUDED.expiration,
+			expires_at = EXCLUDED.expires_at,
+			size = EXCLUDED.size,
+			kind = EXCLUDED.kind,
+			created_at = EXCLUDED.created_at,
+			max_views = EXCLUDED.max_views,
+			remaining_views = EXCLUDED.remaining_views,
+			token_hash = EXCLUDED.token_hash,
+			viewed_at = EXCLUDED.viewed_at,
+			notify_url = EXCLUDED.notify_url`,
+		key, []byte(secret.Message), secret.OneTime, secret.Expiration, meta.Size, meta.Kind, views,
+		sql.NullString{String: meta.TokenHash, Valid: meta.TokenHash != ""},
+		sql.NullString{String: meta.NotifyURL, Valid: meta.NotifyURL != ""},
+	)
+	return err
+}
+
+func (p *Postgres) Delete(ctx context.Context, key string) (bool, error) {
+	res, err := p.db.ExecContext(ctx, `DELETE FROM yopass_secrets WHERE key = $1 AND `+unexpired, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Status reads the metadata columns. Receipts of consumed secrets are
+// reported as well.
+func (p *Postgres) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	var m domain.Metadata
+	var expiresAt, viewedAt sql.NullTime
+	err := p.db.QueryRowContext(
+		ctx,
+		`SELECT one_time, size, kind, created_at, expires_at,
+			COALESCE(max_views, 0), COALESCE(remaining_views, 0), COALESCE(token_hash, ''), viewed_at,
+			COALESCE(notify_url, '')
+		FROM yopass_secrets WHERE key = $1 AND `+unexpired,
+		key,
+	).Scan(&m.OneTime, &m.Size, &m.Kind, &m.CreatedAt, &expiresAt, &m.MaxViews, &m.RemainingViews, &m.TokenHash, &viewedAt, &m.NotifyURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, domain.ErrNotFound
+	}
+	if viewedAt.Valid {
+		m.ViewedAt = viewedAt.Time.UTC().Truncate(time.Second)
+	}
+	m.CreatedAt = m.CreatedAt.UTC().Truncate(time.Second)
+	if expiresAt.Valid {
+		m.ExpiresAt = expiresAt.Time.UTC().Truncate(time.Second)
+	}
+	return m, err
+}
+
+func (p *Postgres) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	res, err := p.db.ExecContext(
+		ctx,
+		`UPDATE yopass_secrets SET
+			expiration = EXTRACT(EPOCH FROM $2::timestamptz - created_at)::integer,
+			expires_at = $2::timestamptz
+		WHERE key = $1 AND `+live,
+		key, expiresAt.UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// Close stops the sweeper and closes the connection pool.
+func (p *Postgres) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.done)
+		err = p.db.Close()
+	})
+	return err
+}
+
+// sweep removes every expired secret and any secret left behind after its
+// last view that doesn't keep a receipt.
+func (p *Postgres) sweep() error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresMaintenanceTimeout)
+	defer cancel()
+	_, err := p.db.ExecContext(ctx, `DELETE FROM yopass_secrets WHERE expires_at <= now() OR (NOT `+live+` AND `+receiptless+`)`)
+	return err
+}
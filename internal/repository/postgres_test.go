@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+func newTestPostgres(t *testing.T) *Postgres {
+	t.Helper()
+	postgresURL := os.Getenv("POSTGRES_URL")
+	if postgresURL == "" {
+		t.Skip("Specify POSTGRES_URL env variable to test PostgreSQL database")
+	}
+
+	db, err := NewPostgres(postgresURL)
+	if err != nil {
+		t.Fatalf("error in NewPostgres(): %v", err)
+	}
+	p := db.(*Postgres)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPostgres(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	if err := p.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
+	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
+	secret := domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}
+
+	if err := p.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	meta, err := p.Status(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if !meta.OneTime {
+		t.Fatal("expected OneTime to be true")
+	}
+	if meta.Size != len(secret.Message) || meta.Kind != domain.KindMessage || meta.ExpiresAt.Sub(meta.CreatedAt) != time.Hour {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+
+	storedSecret, err := p.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if storedSecret.Message != secret.Message || !storedSecret.OneTime || storedSecret.Expiration != 3600 {
+		t.Fatalf("expected %+v, got %+v", secret, storedSecret)
+	}
+
+	if _, err := p.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get() after one-time read, got %v", err)
+	}
+}
+
+func TestPostgresMultiRead(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	key := "test-multi"
+	if err := p.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		s, err := p.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.OneTime {
+			t.Fatal("expected OneTime to be false")
+		}
+	}
+
+	deleted, err := p.Delete(ctx, key)
+	if err != nil || !deleted {
+		t.Fatalf("expected Delete() to remove the secret, got %v, %v", deleted, err)
+	}
+	deleted, err = p.Delete(ctx, key)
+	if err != nil || deleted {
+		t.Fatalf("expected second Delete() to report nothing deleted, got %v, %v", deleted, err)
+	}
+	if _, err := p.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status() after Delete(), got %v", err)
+	}
+}
+
+func TestPostgresExpiration(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	key := "test-expired"
+	if err := p.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := p.db.Exec(`UPDATE yopass_secrets SET expires_at = now() - INTERVAL '1 second' WHERE key = $1`, key); err != nil {
+		t.Fatalf("failed to expire secret: %v", err)
+	}
+
+	if _, err := p.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get() after expiration, got %v", err)
+	}
+	if _, err := p.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Status() after expiration, got %v", err)
+	}
+
+	if err := p.sweep(); err != nil {
+		t.Fatalf("error in sweep(): %v", err)
+	}
+	var n int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM yopass_secrets WHERE key = $1`, key).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected sweep() to remove the expired row")
+	}
+}
+
+func TestPostgresBinaryMessage(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	key := "binary-message"
+	message := "yopass:binary:\x00\xff\xfe packets"
+	if err := p.Put(ctx, key, domain.Secret{Message: message, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	defer p.Delete(ctx, key)
+
+	s, err := p.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != message {
+		t.Fatalf("expected binary message to be kept intact, got %q", s.Message)
+	}
+}
+
+func TestPostgresMigrationsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	if err := migratePostgres(ctx, p.db); err != nil {
+		t.Fatalf("expected migrations to be idempotent, got %v", err)
+	}
+
+	var version int
+	if err := p.db.QueryRow(`SELECT MAX(version) FROM yopass_schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(postgresMigrations) {
+		t.Fatalf("expected schema version %d, got %d", len(postgresMigrations), version)
+	}
+}
+
+func TestPostgresConcurrentOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	const readers = 50
+	for i := 0; i < 20; i++ {
+		key := "test-concurrent-onetime"
+		if err := p.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+
+		var wins int32
+		var wg sync.WaitGroup
+		for j := 0; j < readers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := p.Get(ctx, key); err == nil {
+					atomic.AddInt32(&wins, 1)
+				} else if err != domain.ErrNotFound {
+					t.Errorf("unexpected error in Get(): %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+		}
+	}
+}
+
+func TestPostgresMaxViews(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	key := "test-max-views"
+	if err := p.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := p.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		if meta, err := p.Status(ctx, key); err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+	}
+	if _, err := p.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestPostgresConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPostgres(t)
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := p.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestPostgresReceipt(t *testing.T) {
+	testRepositoryReceipt(t, func(t *testing.T) domain.Repository {
+		return newTestPostgres(t)
+	})
+}
+
+func TestPostgresExtend(t *testing.T) {
+	testRepositoryExtend(t, newTestPostgres(t))
+}
+
+func TestPostgresPeek(t *testing.T) {
+	testRepositoryPeek(t, newTestPostgres(t))
+}
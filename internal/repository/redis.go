@@ -1,15 +1,133 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/go-redis/redis/v7"
 )
 
+// Secrets are stored as a hash with the metadata and the encrypted
+// message in separate fields, so status checks don't transfer the message.
+// Both live in a single key and therefore expire and are removed together.
+// Multi-view secrets also count their remaining views in a third field,
+// and the time of the first view is kept in a fourth.
+const (
+	redisMetaField    = "meta"
+	redisPayloadField = "payload"
+	redisViewsField   = "views"
+	redisViewedField  = "viewed"
+)
+
+// getScript returns the metadata and message of the stored secret and
+// removes it in the same step when it is a one-time secret, so concurrent
+// readers can never both receive it. Multi-view secrets have their views
+// counted down the same way and are removed with the last one, which is
+// returned as well. Secrets with a management token and an expiry only
+// lose their message, the rest stays as a receipt of the view until the key
+// expires. Secrets stored as a single JSON string before the metadata was
+// split off are returned without metadata.
+var getScript = redis.NewScript(`
+local t = redis.call("TYPE", KEYS[1])["ok"]
+local meta, val
+if t == "hash" then
+	local fields = redis.call("HMGET", KEYS[1], ARGV[1], ARGV[2])
+	meta, val = fields[1], fields[2]
+	if not meta or not val then
+		return false
+	end
+	redis.call("HSETNX", KEYS[1], ARGV[4], ARGV[5])
+	local ok, decoded = pcall(cjson.decode, meta)
+	local consume = function()
+		if ok and type(decoded) == "table" and type(decoded["token_hash"]) == "string"
+			and decoded["token_hash"] ~= "" and redis.call("PTTL", KEYS[1]) > 0 then
+			redis.call("HDEL", KEYS[1], ARGV[2])
+		else
+			redis.call("DEL", KEYS[1])
+		end
+	end
+	if redis.call("HEXISTS", KEYS[1], ARGV[3]) == 1 then
+		local views = redis.call("HINCRBY", KEYS[1], ARGV[3], -1)
+		if views <= 0 then
+			consume()
+		end
+		if views < 0 then
+			return false
+		end
+		return {meta, val, views}
+	end
+	if ok and type(decoded) == "table" and decoded["one_time"] == true then
+		consume()
+	end
+	return {meta, val, false}
+elseif t == "string" then
+	meta, val = false, redis.call("GET", KEYS[1])
+else
+	return false
+end
+local ok, decoded = pcall(cjson.decode, val)
+if ok and type(decoded) == "table" and decoded["one_time"] == true then
+	redis.call("DEL", KEYS[1])
+end
+return {meta, val, false}
+`)
+
+// peekScript returns the metadata, message and remaining views of the
+// stored secret like getScript, but leaves it untouched.
+var peekScript = redis.NewScript(`
+local t = redis.call("TYPE", KEYS[1])["ok"]
+if t == "hash" then
+	local fields = redis.call("HMGET", KEYS[1], ARGV[1], ARGV[2], ARGV[3])
+	if not fields[1] or not fields[2] then
+		return false
+	end
+	return fields
+elseif t == "string" then
+	return {false, redis.call("GET", KEYS[1]), false}
+end
+return false
+`)
+
+// statusScript returns the metadata of the stored secret, or the whole
+// secret if it predates the split, along with its remaining time to live
+// in milliseconds, its remaining views and the time of its first view.
+var statusScript = redis.NewScript(`
+local t = redis.call("TYPE", KEYS[1])["ok"]
+local meta, val, views, viewed = false, false, false, false
+if t == "hash" then
+	local fields = redis.call("HMGET", KEYS[1], ARGV[1], ARGV[2], ARGV[3])
+	meta, views, viewed = fields[1], fields[2], fields[3]
+elseif t == "string" then
+	val = redis.call("GET", KEYS[1])
+else
+	return false
+end
+return {meta, val, redis.call("PTTL", KEYS[1]), views, viewed}
+`)
+
+// extendScript moves the expiry of a secret that still has its message to
+// the unix time in milliseconds in ARGV[4], which ARGV[3] holds in RFC 3339
+// format for the metadata.
+var extendScript = redis.NewScript(`
+if redis.call("TYPE", KEYS[1])["ok"] ~= "hash" or redis.call("HEXISTS", KEYS[1], ARGV[2]) == 0 then
+	return false
+end
+local meta = cjson.decode(redis.call("HGET", KEYS[1], ARGV[1]))
+meta["expires_at"] = ARGV[3]
+redis.call("HSET", KEYS[1], ARGV[1], cjson.encode(meta))
+redis.call("PEXPIREAT", KEYS[1], ARGV[4])
+return true
+`)
+
+// Redis stores secrets in a standalone Redis server, a Sentinel managed
+// deployment or a Redis Cluster. Every secret lives in a single key, so the
+// Lua script used for one-time reads stays atomic in all of them.
 type Redis struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
 func NewRedis(url string) (domain.Repository, error) {
@@ -21,40 +139,151 @@ func NewRedis(url string) (domain.Repository, error) {
 	return &Redis{client}, nil
 }
 
-func (r *Redis) Get(key string) (domain.Secret, error) {
+// NewRedisSentinel returns a repository that asks the given sentinels for
+// the current master of masterName and follows it through failovers.
+// Credentials, database and TLS settings are taken from url.
+func NewRedisSentinel(url, masterName string, sentinelAddrs []string) (domain.Repository, error) {
+	if masterName == "" || len(sentinelAddrs) == 0 {
+		return nil, fmt.Errorf("redis sentinel requires a master name and at least one sentinel address")
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: sentinelAddrs,
+		Username:      options.Username,
+		Password:      options.Password,
+		DB:            options.DB,
+		TLSConfig:     options.TLSConfig,
+	})
+	return &Redis{client}, nil
+}
+
+// NewRedisCluster returns a repository backed by the Redis Cluster reachable
+// through the given seed nodes. Credentials and TLS settings are taken from
+// url, which must not select a database other than 0.
+func NewRedisCluster(url string, addrs []string) (domain.Repository, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("redis cluster requires at least one node address")
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if options.DB != 0 {
+		return nil, fmt.Errorf("redis cluster does not support selecting database %d", options.DB)
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:     addrs,
+		Username:  options.Username,
+		Password:  options.Password,
+		TLSConfig: options.TLSConfig,
+	})
+	return &Redis{client}, nil
+}
+
+// withContext returns the client bound to ctx.
+func (r *Redis) withContext(ctx context.Context) redis.Cmdable {
+	switch c := r.client.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	}
+	return r.client
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (domain.Secret, error) {
 	var s domain.Secret
-	val, err := r.client.Get(key).Result()
+	res, err := getScript.Run(r.withContext(ctx), []string{key},
+		redisMetaField, redisPayloadField, redisViewsField, redisViewedField, time.Now().Unix()).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return s, domain.ErrNotFound
 		}
 		return s, err
 	}
+	val, ok := res.([]interface{})
+	if !ok || len(val) != 3 {
+		return s, fmt.Errorf("unexpected reply to get script: %v", res)
+	}
+	payload, _ := val[1].(string)
 
-	if err := json.Unmarshal([]byte(val), &s); err != nil {
+	meta, ok := val[0].(string)
+	if !ok {
+		err := json.Unmarshal([]byte(payload), &s)
+		return s, err
+	}
+	var m domain.Metadata
+	if err := json.Unmarshal([]byte(meta), &m); err != nil {
 		return s, err
 	}
+	s = m.Secret(payload)
+	if views, ok := val[2].(int64); ok {
+		s.RemainingViews = int(views)
+	}
+	return s, nil
+}
 
-	if s.OneTime {
-		_, _ = r.Delete(key)
+func (r *Redis) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	res, err := peekScript.Run(r.withContext(ctx), []string{key},
+		redisMetaField, redisPayloadField, redisViewsField).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return s, domain.ErrNotFound
+		}
+		return s, err
+	}
+	val, ok := res.([]interface{})
+	if !ok || len(val) != 3 {
+		return s, fmt.Errorf("unexpected reply to peek script: %v", res)
+	}
+	payload, _ := val[1].(string)
+
+	meta, ok := val[0].(string)
+	if !ok {
+		err := json.Unmarshal([]byte(payload), &s)
+		return s, err
+	}
+	var m domain.Metadata
+	if err := json.Unmarshal([]byte(meta), &m); err != nil {
+		return s, err
+	}
+	s = m.Secret(payload)
+	if views, ok := val[2].(string); ok {
+		if s.RemainingViews, err = strconv.Atoi(views); err != nil {
+			return domain.Secret{}, err
+		}
 	}
 	return s, nil
 }
 
-func (r *Redis) Put(key string, secret domain.Secret) error {
-	data, err := secret.ToJSON()
+func (r *Redis) Put(ctx context.Context, key string, secret domain.Secret) error {
+	meta := domain.NewMetadata(secret, time.Now())
+	encoded, err := json.Marshal(meta)
 	if err != nil {
 		return err
 	}
-	return r.client.Set(
-		key,
-		data,
-		time.Duration(secret.Expiration)*time.Second,
-	).Err()
+	fields := []interface{}{redisMetaField, encoded, redisPayloadField, secret.Message}
+	if meta.RemainingViews > 0 {
+		fields = append(fields, redisViewsField, meta.RemainingViews)
+	}
+	_, err = r.withContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(key)
+		pipe.HSet(key, fields...)
+		if secret.Expiration > 0 {
+			pipe.Expire(key, time.Duration(secret.Expiration)*time.Second)
+		}
+		return nil
+	})
+	return err
 }
 
-func (r *Redis) Delete(key string) (bool, error) {
-	res, err := r.client.Del(key).Result()
+func (r *Redis) Delete(ctx context.Context, key string) (bool, error) {
+	res, err := r.withContext(ctx).Del(key).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return false, nil
@@ -64,17 +293,66 @@ func (r *Redis) Delete(key string) (bool, error) {
 	return res > 0, nil
 }
 
-func (r *Redis) Status(key string) (bool, error) {
-	val, err := r.client.Get(key).Result()
+// Status returns the stored metadata. For secrets stored before the
+// metadata was split off, it is derived from the secret and its TTL.
+func (r *Redis) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	var m domain.Metadata
+	res, err := statusScript.Run(r.withContext(ctx), []string{key}, redisMetaField, redisViewsField, redisViewedField).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return false, domain.ErrNotFound
+			return m, domain.ErrNotFound
 		}
-		return false, err
+		return m, err
+	}
+	val, ok := res.([]interface{})
+	if !ok || len(val) != 5 {
+		return m, fmt.Errorf("unexpected reply to status script: %v", res)
+	}
+	pttl, _ := val[2].(int64)
+
+	if meta, ok := val[0].(string); ok {
+		if err := json.Unmarshal([]byte(meta), &m); err != nil {
+			return m, err
+		}
+		if views, ok := val[3].(string); ok {
+			if m.RemainingViews, err = strconv.Atoi(views); err != nil {
+				return m, err
+			}
+		}
+		if viewed, ok := val[4].(string); ok {
+			sec, err := strconv.ParseInt(viewed, 10, 64)
+			if err != nil {
+				return m, err
+			}
+			m.ViewedAt = time.Unix(sec, 0).UTC()
+		}
+		return m, nil
 	}
 	var s domain.Secret
-	if err := json.Unmarshal([]byte(val), &s); err != nil {
-		return false, err
+	legacy, _ := val[1].(string)
+	if err := json.Unmarshal([]byte(legacy), &s); err != nil {
+		return m, err
 	}
-	return s.OneTime, nil
+	m = domain.Metadata{OneTime: s.OneTime, Size: len(s.Message), Kind: domain.KindMessage}
+	if pttl > 0 {
+		m.ExpiresAt = time.Now().Add(time.Duration(pttl) * time.Millisecond).UTC().Truncate(time.Second)
+		if s.Expiration > 0 {
+			m.CreatedAt = m.ExpiresAt.Add(-time.Duration(s.Expiration) * time.Second)
+		}
+	}
+	return m, nil
+}
+
+func (r *Redis) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	expiresAt = expiresAt.UTC().Truncate(time.Second)
+	err := extendScript.Run(r.withContext(ctx), []string{key}, redisMetaField, redisPayloadField,
+		expiresAt.Format(time.RFC3339), expiresAt.UnixMilli()).Err()
+	if err == redis.Nil {
+		return domain.ErrNotFound
+	}
+	return err
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.withContext(ctx).Ping().Err()
 }
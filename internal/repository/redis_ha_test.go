@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v7"
+)
+
+// fakeSentinel is a stand-in Redis Sentinel which answers master lookups
+// with whatever address the test has promoted.
+type fakeSentinel struct {
+	*server.Server
+
+	mu     sync.Mutex
+	master string
+}
+
+func newFakeSentinel(t *testing.T, masterName, master string) *fakeSentinel {
+	t.Helper()
+	srv, err := server.NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake sentinel: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	s := &fakeSentinel{Server: srv, master: master}
+	srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && args[0] == "get-master-addr-by-name" && args[1] == masterName:
+			s.mu.Lock()
+			host, port, _ := net.SplitHostPort(s.master)
+			s.mu.Unlock()
+			c.WriteStrings([]string{host, port})
+		case len(args) == 2 && args[0] == "sentinels":
+			c.WriteLen(0)
+		default:
+			c.WriteNull()
+		}
+	})
+	srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		for i, channel := range args {
+			c.WriteLen(3)
+			c.WriteBulk("subscribe")
+			c.WriteBulk(channel)
+			c.WriteInt(i + 1)
+		}
+	})
+	return s
+}
+
+func (s *fakeSentinel) promote(master string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master = master
+}
+
+func TestRedisSentinelFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	sentinel := newFakeSentinel(t, "mymaster", primary.Addr())
+
+	r, err := NewRedisSentinel("redis://localhost:6379/0", "mymaster", []string{sentinel.Addr().String()})
+	if err != nil {
+		t.Fatalf("error in NewRedisSentinel(): %v", err)
+	}
+
+	if err := r.Put(ctx, "before-failover", domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if !primary.Exists("before-failover") {
+		t.Fatal("expected secret to be stored on the primary")
+	}
+
+	// Promote the replica and take the old primary down. Connections to the
+	// old primary fail and new ones must go to the promoted replica.
+	sentinel.promote(replica.Addr())
+	primary.Close()
+
+	key := "after-failover"
+	secret := domain.Secret{Message: "bar", OneTime: true, Expiration: 3600}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err = r.Put(ctx, key, secret); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("repository did not recover after failover: %v", err)
+	}
+	if !replica.Exists(key) {
+		t.Fatal("expected secret to be stored on the promoted replica")
+	}
+
+	s, err := r.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != secret.Message {
+		t.Fatalf("expected value %s, got %s", secret.Message, s.Message)
+	}
+	if _, err := r.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected one-time secret to be consumed after failover, got %v", err)
+	}
+}
+
+func TestRedisCluster(t *testing.T) {
+	ctx := context.Background()
+	node := miniredis.RunT(t)
+
+	r, err := NewRedisCluster("redis://", []string{node.Addr()})
+	if err != nil {
+		t.Fatalf("error in NewRedisCluster(): %v", err)
+	}
+	if _, ok := r.(*Redis).client.(*redis.ClusterClient); !ok {
+		t.Fatal("expected a cluster client")
+	}
+	if err := r.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := "cluster-" + strconv.Itoa(i)
+		if err := r.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		meta, err := r.Status(ctx, key)
+		if err != nil || !meta.OneTime {
+			t.Fatalf("expected one-time status, got %v, %v", meta.OneTime, err)
+		}
+		if _, err := r.Get(ctx, key); err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if _, err := r.Get(ctx, key); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound after one-time read, got %v", err)
+		}
+	}
+}
+
+func TestRedisHAUnits(t *testing.T) {
+	t.Run("Sentinel requires master name", func(t *testing.T) {
+		if _, err := NewRedisSentinel("redis://localhost:6379/0", "", []string{"localhost:26379"}); err == nil {
+			t.Fatal("expected error without master name")
+		}
+	})
+
+	t.Run("Sentinel requires addresses", func(t *testing.T) {
+		if _, err := NewRedisSentinel("redis://localhost:6379/0", "mymaster", nil); err == nil {
+			t.Fatal("expected error without sentinel addresses")
+		}
+	})
+
+	t.Run("Sentinel with invalid URL", func(t *testing.T) {
+		if _, err := NewRedisSentinel("invalid-url", "mymaster", []string{"localhost:26379"}); err == nil {
+			t.Fatal("expected error for invalid Redis URL")
+		}
+	})
+
+	t.Run("Cluster requires addresses", func(t *testing.T) {
+		if _, err := NewRedisCluster("redis://localhost:6379/0", nil); err == nil {
+			t.Fatal("expected error without cluster addresses")
+		}
+	})
+
+	t.Run("Cluster rejects database selection", func(t *testing.T) {
+		if _, err := NewRedisCluster("redis://localhost:6379/3", []string{"localhost:6379"}); err == nil {
+			t.Fatal("expected error when selecting a database")
+		}
+	})
+}
@@ -1,13 +1,19 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 )
 
 func TestRedis(t *testing.T) {
+	ctx := context.Background()
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
 		t.Skip("Specify REDIS_URL env variable to test Redis database")
@@ -18,15 +24,19 @@ func TestRedis(t *testing.T) {
 		t.Fatalf("error in NewRedis(): %v", err)
 	}
 
+	if err := r.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+
 	key := "f9fa5704-3ed2-4e60-b441-c426d3f9f3c1"
 	secret := domain.Secret{Message: "foo", OneTime: true}
 
-	err = r.Put(key, secret)
+	err = r.Put(ctx, key, secret)
 	if err != nil {
 		t.Fatalf("error in Put(): %v", err)
 	}
 
-	storedVal, err := r.Get(key)
+	storedVal, err := r.Get(ctx, key)
 	if err != nil {
 		t.Fatalf("error in Get(): %v", err)
 	}
@@ -35,7 +45,7 @@ func TestRedis(t *testing.T) {
 		t.Fatalf("expected value %s, got %s", secret.Message, storedVal.Message)
 	}
 
-	_, err = r.Get(key)
+	_, err = r.Get(ctx, key)
 	if err == nil {
 		t.Fatal("expected error from Get() after Delete()")
 	}
@@ -65,6 +75,7 @@ func TestRedisUnits(t *testing.T) {
 }
 
 func TestRedisStatus(t *testing.T) {
+	ctx := context.Background()
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
 		t.Skip("Specify REDIS_URL env variable to test Redis database")
@@ -79,41 +90,343 @@ func TestRedisStatus(t *testing.T) {
 		key := "test-status-onetime"
 		secret := domain.Secret{Message: "test message", OneTime: true, Expiration: 3600}
 
-		err := r.Put(key, secret)
+		err := r.Put(ctx, key, secret)
 		if err != nil {
 			t.Fatalf("error in Put(): %v", err)
 		}
 
-		oneTime, err := r.Status(key)
+		meta, err := r.Status(ctx, key)
 		if err != nil {
 			t.Fatalf("error in Status(): %v", err)
 		}
 
-		if oneTime != true {
-			t.Fatalf("expected OneTime to be true, got %v", oneTime)
+		if meta.OneTime != true {
+			t.Fatalf("expected OneTime to be true, got %v", meta.OneTime)
 		}
 
-		r.Delete(key)
+		r.Delete(ctx, key)
 	})
 
 	t.Run("Status returns correct OneTime value for non-onetime secret", func(t *testing.T) {
 		key := "test-status-multi"
 		secret := domain.Secret{Message: "test message", OneTime: false, Expiration: 3600}
 
-		err := r.Put(key, secret)
+		err := r.Put(ctx, key, secret)
 		if err != nil {
 			t.Fatalf("error in Put(): %v", err)
 		}
 
-		oneTime, err := r.Status(key)
+		meta, err := r.Status(ctx, key)
 		if err != nil {
 			t.Fatalf("error in Status(): %v", err)
 		}
 
-		if oneTime != false {
-			t.Fatalf("expected OneTime to be false, got %v", oneTime)
+		if meta.OneTime != false {
+			t.Fatalf("expected OneTime to be false, got %v", meta.OneTime)
 		}
 
-		r.Delete(key)
+		r.Delete(ctx, key)
 	})
 }
+
+func TestRedisConcurrentOneTimeGet(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	const readers = 50
+	for i := 0; i < 20; i++ {
+		key := "test-concurrent-onetime"
+		if err := r.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+
+		var wins int32
+		var wg sync.WaitGroup
+		for j := 0; j < readers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s, err := r.Get(ctx, key)
+				if err == nil {
+					if s.Message != "foo" {
+						t.Errorf("expected value foo, got %s", s.Message)
+					}
+					atomic.AddInt32(&wins, 1)
+				} else if err != domain.ErrNotFound {
+					t.Errorf("unexpected error in Get(): %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("expected exactly one reader to receive the secret, got %d", wins)
+		}
+	}
+}
+
+func TestRedisConcurrentMultiGet(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	key := "test-concurrent-multi"
+	if err := r.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	defer r.Delete(ctx, key)
+
+	var wg sync.WaitGroup
+	for j := 0; j < 50; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Get(ctx, key); err != nil {
+				t.Errorf("error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	meta, err := r.Status(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	if meta.OneTime {
+		t.Fatal("expected OneTime to be false")
+	}
+}
+
+func TestRedisMaxViews(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	key := "test-max-views"
+	if err := r.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 3, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for _, remaining := range []int{2, 1, 0} {
+		s, err := r.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("error in Get(): %v", err)
+		}
+		if s.Message != "foo" || s.MaxViews != 3 || s.RemainingViews != remaining {
+			t.Fatalf("expected %d remaining views, got %+v", remaining, s)
+		}
+		if remaining == 0 {
+			break
+		}
+		if meta, err := r.Status(ctx, key); err != nil || meta.RemainingViews != remaining {
+			t.Fatalf("expected Status() to report %d remaining views, got %+v, %v", remaining, meta, err)
+		}
+	}
+	if _, err := r.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+	}
+}
+
+func TestRedisConcurrentMaxViews(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	const readers, views = 50, 5
+	key := "test-concurrent-max-views"
+	if err := r.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: views, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	var wins int32
+	var wg sync.WaitGroup
+	for j := 0; j < readers; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Get(ctx, key); err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != domain.ErrNotFound {
+				t.Errorf("unexpected error in Get(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != views {
+		t.Fatalf("expected %d readers to receive the secret, got %d", views, wins)
+	}
+}
+
+func TestRedisStatusDoesNotConsume(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+
+	key := "test-status-not-consumed"
+	if err := r.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.Status(ctx, key); err != nil {
+			t.Fatalf("error in Status(): %v", err)
+		}
+	}
+
+	if _, err := r.Get(ctx, key); err != nil {
+		t.Fatalf("error in Get() after Status(): %v", err)
+	}
+	if _, err := r.Status(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after Get(), got %v", err)
+	}
+}
+
+func TestRedisMetadata(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+	client := r.(*Redis).client
+
+	key := "test-metadata"
+	secret := domain.Secret{Message: "test message", OneTime: true, Expiration: 3600, Kind: domain.KindFile}
+	if err := r.Put(ctx, key, secret); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	defer r.Delete(ctx, key)
+
+	meta, err := client.HGet(key, redisMetaField).Result()
+	if err != nil {
+		t.Fatalf("error reading metadata: %v", err)
+	}
+	var m domain.Metadata
+	if err := json.Unmarshal([]byte(meta), &m); err != nil {
+		t.Fatalf("error decoding metadata: %v", err)
+	}
+	if !m.OneTime || m.Size != len(secret.Message) || m.Kind != domain.KindFile || m.ExpiresAt.Sub(m.CreatedAt) != time.Hour {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+	if ttl := client.TTL(key).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected secret to expire within an hour, got %v", ttl)
+	}
+
+	s, err := r.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != secret.Message || !s.OneTime || s.Expiration != secret.Expiration || s.Kind != domain.KindFile {
+		t.Fatalf("expected %+v, got %+v", secret, s)
+	}
+	if client.Exists(key).Val() != 0 {
+		t.Fatal("expected metadata and message to be consumed together")
+	}
+}
+
+func TestRedisLegacySecret(t *testing.T) {
+	ctx := context.Background()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+	client := r.(*Redis).client
+
+	// Secrets written before the metadata was split off are a JSON string.
+	key := "test-legacy"
+	legacy := `{"expiration":3600,"message":"legacy","one_time":true}`
+	if err := client.Set(key, legacy, time.Hour).Err(); err != nil {
+		t.Fatalf("error in Set(): %v", err)
+	}
+	defer r.Delete(ctx, key)
+
+	meta, err := r.Status(ctx, key)
+	if err != nil || !meta.OneTime {
+		t.Fatalf("expected Status() of legacy one-time secret, got %v, %v", meta.OneTime, err)
+	}
+	if meta.Size != len("legacy") || time.Until(meta.ExpiresAt) > time.Hour || meta.ExpiresAt.Sub(meta.CreatedAt) != time.Hour {
+		t.Fatalf("expected metadata derived from the secret and its TTL, got %+v", meta)
+	}
+	s, err := r.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s.Message != "legacy" || !s.OneTime || s.Expiration != 3600 {
+		t.Fatalf("unexpected legacy secret %+v", s)
+	}
+	if _, err := r.Get(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected legacy one-time secret to be consumed, got %v", err)
+	}
+}
+
+func TestRedisReceipt(t *testing.T) {
+	testRepositoryReceipt(t, newTestRedis)
+}
+
+func TestRedisExtend(t *testing.T) {
+	testRepositoryExtend(t, newTestRedis(t))
+}
+
+func TestRedisPeek(t *testing.T) {
+	testRepositoryPeek(t, newTestRedis(t))
+}
+
+// newTestRedis connects to the Redis server at REDIS_URL.
+func newTestRedis(t *testing.T) domain.Repository {
+	t.Helper()
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("Specify REDIS_URL env variable to test Redis database")
+	}
+
+	r, err := NewRedis(redisURL)
+	if err != nil {
+		t.Fatalf("error in NewRedis(): %v", err)
+	}
+	return r
+}
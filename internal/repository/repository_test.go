@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// testRepositoryReceipt checks that a backend keeps the metadata of a
+// consumed secret with a management token as its receipt, and nothing of
+// one without. newRepo is called once per case.
+func testRepositoryReceipt(t *testing.T, newRepo func(t *testing.T) domain.Repository) {
+	tests := []struct {
+		name    string
+		key     string
+		secret  domain.Secret
+		views   int
+		receipt bool
+	}{
+		{
+			name:    "one-time",
+			key:     "test-receipt",
+			secret:  domain.Secret{Message: "foo", OneTime: true, Expiration: 3600, TokenHash: "hash", NotifyURL: "https://hooks.example.com/yopass"},
+			views:   1,
+			receipt: true,
+		},
+		{
+			name:    "max views",
+			key:     "test-receipt-max-views",
+			secret:  domain.Secret{Message: "foo", MaxViews: 2, Expiration: 3600, TokenHash: "hash"},
+			views:   2,
+			receipt: true,
+		},
+		{
+			name:   "without management token",
+			key:    "test-no-receipt",
+			secret: domain.Secret{Message: "foo", OneTime: true, Expiration: 3600},
+			views:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newRepo(t)
+
+			if err := repo.Put(ctx, tt.key, tt.secret); err != nil {
+				t.Fatalf("error in Put(): %v", err)
+			}
+			meta, err := repo.Status(ctx, tt.key)
+			if err != nil || !meta.ViewedAt.IsZero() || meta.TokenHash != tt.secret.TokenHash || meta.NotifyURL != tt.secret.NotifyURL {
+				t.Fatalf("expected unviewed secret with token hash and notification URL, got %+v, %v", meta, err)
+			}
+			for i := 0; i < tt.views; i++ {
+				s, err := repo.Get(ctx, tt.key)
+				if err != nil || s.Message != "foo" || s.NotifyURL != tt.secret.NotifyURL {
+					t.Fatalf("expected secret foo, got %+v, %v", s, err)
+				}
+			}
+			if _, err := repo.Get(ctx, tt.key); err != domain.ErrNotFound {
+				t.Fatalf("expected ErrNotFound after the last view, got %v", err)
+			}
+
+			meta, err = repo.Status(ctx, tt.key)
+			if !tt.receipt {
+				if err != domain.ErrNotFound {
+					t.Fatalf("expected ErrNotFound from Status(), got %v", err)
+				}
+				return
+			}
+			if err != nil || meta.ViewedAt.IsZero() || !meta.Consumed() || meta.RemainingViews != 0 {
+				t.Fatalf("expected receipt of consumed secret, got %+v, %v", meta, err)
+			}
+			if err := repo.Extend(ctx, tt.key, meta.ExpiresAt.Add(time.Hour)); err != domain.ErrNotFound {
+				t.Fatalf("expected ErrNotFound from Extend() of consumed secret, got %v", err)
+			}
+		})
+	}
+}
+
+// testRepositoryExtend checks that a backend moves the expiry of a secret,
+// and of nothing else.
+func testRepositoryExtend(t *testing.T, repo domain.Repository) {
+	ctx := context.Background()
+
+	key := "test-extend"
+	if err := repo.Put(ctx, key, domain.Secret{Message: "foo", Expiration: 3600, TokenHash: "hash"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	meta, err := repo.Status(ctx, key)
+	if err != nil {
+		t.Fatalf("error in Status(): %v", err)
+	}
+	expiresAt := meta.CreatedAt.Add(2 * time.Hour)
+	if err := repo.Extend(ctx, key, expiresAt); err != nil {
+		t.Fatalf("error in Extend(): %v", err)
+	}
+	if meta, err := repo.Status(ctx, key); err != nil || !meta.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected secret to expire at %v, got %+v, %v", expiresAt, meta, err)
+	}
+	if s, err := repo.Get(ctx, key); err != nil || s.Message != "foo" || s.Expiration != 7200 {
+		t.Fatalf("expected extended secret foo, got %+v, %v", s, err)
+	}
+	if err := repo.Extend(ctx, "missing", expiresAt); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Extend() of missing secret, got %v", err)
+	}
+}
+
+// testRepositoryPeek checks that a backend reads a secret without counting
+// the view, and doesn't read what Get wouldn't.
+func testRepositoryPeek(t *testing.T, repo domain.Repository) {
+	ctx := context.Background()
+
+	key := "test-peek"
+	if err := repo.Put(ctx, key, domain.Secret{Message: "foo", OneTime: true, Expiration: 3600, TokenHash: "hash"}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		s, err := repo.Peek(ctx, key)
+		if err != nil || s.Message != "foo" || !s.OneTime || s.Expiration != 3600 {
+			t.Fatalf("expected one-time secret foo, got %+v, %v", s, err)
+		}
+	}
+	if meta, err := repo.Status(ctx, key); err != nil || !meta.ViewedAt.IsZero() {
+		t.Fatalf("expected Peek() not to record a view, got %+v, %v", meta, err)
+	}
+	if _, err := repo.Get(ctx, key); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if _, err := repo.Peek(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Peek() of consumed secret, got %v", err)
+	}
+
+	key = "test-peek-max-views"
+	if err := repo.Put(ctx, key, domain.Secret{Message: "foo", MaxViews: 2, Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if s, err := repo.Peek(ctx, key); err != nil || s.Message != "foo" || s.RemainingViews != 2 {
+		t.Fatalf("expected two remaining views, got %+v, %v", s, err)
+	}
+	if _, err := repo.Get(ctx, key); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+	if s, err := repo.Peek(ctx, key); err != nil || s.RemainingViews != 1 {
+		t.Fatalf("expected one remaining view, got %+v, %v", s, err)
+	}
+
+	if _, err := repo.Peek(ctx, "missing"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Peek() of missing secret, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// errCircuitOpen is returned without contacting the database while the
+// circuit breaker is open.
+var errCircuitOpen = fmt.Errorf("%w: circuit breaker is open", domain.ErrUnavailable)
+
+// ResilienceOptions configures a Resilient repository.
+type ResilienceOptions struct {
+	// Retries is the number of times a failed Status, Put or Extend is
+	// retried.
+	Retries int
+	// RetryBackoff is the base of the exponential backoff between retries.
+	// The actual pause is picked at random up to the backoff of the attempt.
+	RetryBackoff time.Duration
+	// FailureThreshold is the number of consecutive failures after which
+	// the circuit breaker opens. Zero disables the circuit breaker.
+	FailureThreshold int
+	// Cooldown is how long the circuit breaker stays open before a trial
+	// request is let through.
+	Cooldown   time.Duration
+	Logger     *zap.Logger
+	Registerer prometheus.Registerer
+}
+
+// Resilient retries idempotent operations of the wrapped repository and
+// stops calling it while it keeps failing.
+//
+// Status, Peek, Put and Extend are retried: the first two only read, the
+// service stores every secret under a fresh key, so writing it twice is
+// harmless, and extending it sets an absolute expiry. Get is never retried
+// since a one-time secret may already be consumed by the failed attempt,
+// and neither is Delete whose result would change on a second attempt.
+//
+// While the circuit breaker is open every operation, including Ping, fails
+// with domain.ErrUnavailable, which also fails the readiness check.
+type Resilient struct {
+	repo    domain.Repository
+	opts    ResilienceOptions
+	breaker *circuitBreaker
+	retries *prometheus.CounterVec
+	state   *prometheus.GaugeVec
+}
+
+// NewResilient returns repo wrapped with retries and a circuit breaker.
+func NewResilient(repo domain.Repository, opts ResilienceOptions) domain.Repository {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	r := &Resilient{
+		repo: repo,
+		opts: opts,
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "yopass_database_retries_total",
+				Help: "Total number of retried database operations by operation.",
+			},
+			[]string{"operation"},
+		),
+		state: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "yopass_database_circuit_breaker_state",
+				Help: "Current state of the database circuit breaker, 1 for the active state.",
+			},
+			[]string{"state"},
+		),
+	}
+	if opts.FailureThreshold > 0 {
+		r.breaker = newCircuitBreaker(opts.FailureThreshold, opts.Cooldown, r.stateChanged)
+	}
+	r.setStateMetric(breakerClosed)
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(r.retries, r.state)
+	}
+	return r
+}
+
+func (r *Resilient) Get(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	err := r.call(ctx, func() error {
+		var err error
+		s, err = r.repo.Get(ctx, key)
+		return err
+	})
+	return s, err
+}
+
+func (r *Resilient) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	var s domain.Secret
+	err := r.retry(ctx, "peek", func() error {
+		var err error
+		s, err = r.repo.Peek(ctx, key)
+		return err
+	})
+	return s, err
+}
+
+func (r *Resilient) Put(ctx context.Context, key string, secret domain.Secret) error {
+	return r.retry(ctx, "put", func() error {
+		return r.repo.Put(ctx, key, secret)
+	})
+}
+
+func (r *Resilient) Delete(ctx context.Context, key string) (bool, error) {
+	var deleted bool
+	err := r.call(ctx, func() error {
+		var err error
+		deleted, err = r.repo.Delete(ctx, key)
+		return err
+	})
+	return deleted, err
+}
+
+func (r *Resilient) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	var meta domain.Metadata
+	err := r.retry(ctx, "status", func() error {
+		var err error
+		meta, err = r.repo.Status(ctx, key)
+		return err
+	})
+	return meta, err
+}
+
+func (r *Resilient) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return r.retry(ctx, "extend", func() error {
+		return r.repo.Extend(ctx, key, expiresAt)
+	})
+}
+
+func (r *Resilient) Ping(ctx context.Context) error {
+	return r.call(ctx, func() error {
+		return r.repo.Ping(ctx)
+	})
+}
+
+// Close closes the wrapped repository if it holds any resources.
+func (r *Resilient) Close() error {
+	if closer, ok := r.repo.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// retry calls fn until it succeeds, fails for a reason a retry can't fix or
+// the retries are used up.
+func (r *Resilient) retry(ctx context.Context, operation string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := r.call(ctx, fn)
+		if attempt >= r.opts.Retries || outcomeOf(err) != outcomeFailure || ctx.Err() != nil {
+			return err
+		}
+
+		r.retries.WithLabelValues(operation).Inc()
+		r.opts.Logger.Debug("Retrying database operation",
+			zap.String("operation", operation), zap.Int("attempt", attempt+1), zap.Error(err))
+		if !r.sleep(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// call runs fn unless the circuit breaker is open.
+func (r *Resilient) call(ctx context.Context, fn func() error) error {
+	if r.breaker == nil {
+		return fn()
+	}
+	if !r.breaker.allow() {
+		return errCircuitOpen
+	}
+	err := fn()
+	r.breaker.record(outcomeOf(err))
+	return err
+}
+
+// sleep waits a random time up to the backoff of attempt. It returns false
+// if ctx ends first.
+func (r *Resilient) sleep(ctx context.Context, attempt int) bool {
+	backoff := r.opts.RetryBackoff << attempt
+	var pause time.Duration
+	if backoff > 0 {
+		pause = rand.N(backoff)
+	}
+
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (r *Resilient) stateChanged(state breakerState) {
+	r.setStateMetric(state)
+	if state == breakerOpen {
+		r.opts.Logger.Warn("Database circuit breaker opened", zap.Duration("cooldown", r.opts.Cooldown))
+	} else {
+		r.opts.Logger.Info("Database circuit breaker state changed", zap.Stringer("state", state))
+	}
+}
+
+func (r *Resilient) setStateMetric(current breakerState) {
+	for _, state := range []breakerState{breakerClosed, breakerHalfOpen, breakerOpen} {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		r.state.WithLabelValues(state.String()).Set(value)
+	}
+}
+
+// outcomeOf tells whether err says anything about the health of the
+// database. A missing secret shows the database answered, so it counts as a
+// success. Canceled requests and failures of a single memcached node, which
+// has its own health tracking, say nothing.
+func outcomeOf(err error) breakerOutcome {
+	switch {
+	case err == nil, errors.Is(err, domain.ErrNotFound):
+		return outcomeSuccess
+	case errors.Is(err, domain.ErrUnavailable),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, ErrRepositoryFull),
+		errors.Is(err, ErrUnsealFailed),
+		errors.Is(err, errNodeDown):
+		return outcomeIgnored
+	default:
+		return outcomeFailure
+	}
+}
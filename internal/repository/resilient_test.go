@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scriptedRepository fails every operation with err until it was called
+// failures times.
+type scriptedRepository struct {
+	mu       sync.Mutex
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *scriptedRepository) next() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *scriptedRepository) Get(ctx context.Context, key string) (domain.Secret, error) {
+	return domain.Secret{Message: "foo"}, s.next()
+}
+
+func (s *scriptedRepository) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	return domain.Secret{Message: "foo"}, s.next()
+}
+
+func (s *scriptedRepository) Put(ctx context.Context, key string, secret domain.Secret) error {
+	return s.next()
+}
+
+func (s *scriptedRepository) Delete(ctx context.Context, key string) (bool, error) {
+	return true, s.next()
+}
+
+func (s *scriptedRepository) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	return domain.Metadata{}, s.next()
+}
+
+func (s *scriptedRepository) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return s.next()
+}
+
+func (s *scriptedRepository) Ping(ctx context.Context) error {
+	return s.next()
+}
+
+var errConnectionRefused = errors.New("connection refused")
+
+func TestResilientRetries(t *testing.T) {
+	ctx := context.Background()
+	opts := ResilienceOptions{Retries: 2, RetryBackoff: time.Millisecond}
+
+	t.Run("Put is retried until it succeeds", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 2}
+		r := NewResilient(repo, opts).(*Resilient)
+		if err := r.Put(ctx, "key", domain.Secret{}); err != nil {
+			t.Fatalf("error in Put(): %v", err)
+		}
+		if repo.calls != 3 {
+			t.Fatalf("expected 3 attempts, got %d", repo.calls)
+		}
+		if got := testutil.ToFloat64(r.retries.WithLabelValues("put")); got != 2 {
+			t.Fatalf("expected 2 retries to be counted, got %v", got)
+		}
+	})
+
+	t.Run("Status gives up after the configured retries", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 10}
+		r := NewResilient(repo, opts)
+		if _, err := r.Status(ctx, "key"); !errors.Is(err, errConnectionRefused) {
+			t.Fatalf("expected the last error from Status(), got %v", err)
+		}
+		if repo.calls != 3 {
+			t.Fatalf("expected 3 attempts, got %d", repo.calls)
+		}
+	})
+
+	t.Run("Extend is retried until it succeeds", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 1}
+		r := NewResilient(repo, opts)
+		if err := r.Extend(ctx, "key", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("error in Extend(): %v", err)
+		}
+		if repo.calls != 2 {
+			t.Fatalf("expected 2 attempts, got %d", repo.calls)
+		}
+	})
+
+	t.Run("Peek is retried until it succeeds", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 1}
+		r := NewResilient(repo, opts)
+		if _, err := r.Peek(ctx, "key"); err != nil {
+			t.Fatalf("error in Peek(): %v", err)
+		}
+		if repo.calls != 2 {
+			t.Fatalf("expected 2 attempts, got %d", repo.calls)
+		}
+	})
+
+	t.Run("missing secrets are not retried", func(t *testing.T) {
+		repo := &scriptedRepository{err: domain.ErrNotFound, failures: 10}
+		r := NewResilient(repo, opts)
+		if _, err := r.Status(ctx, "key"); err != domain.ErrNotFound {
+			t.Fatalf("expected ErrNotFound from Status(), got %v", err)
+		}
+		if repo.calls != 1 {
+			t.Fatalf("expected a single attempt, got %d", repo.calls)
+		}
+	})
+
+	t.Run("Get and Delete are never retried", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 10}
+		r := NewResilient(repo, opts)
+		if _, err := r.Get(ctx, "key"); !errors.Is(err, errConnectionRefused) {
+			t.Fatalf("expected error from Get(), got %v", err)
+		}
+		if _, err := r.Delete(ctx, "key"); !errors.Is(err, errConnectionRefused) {
+			t.Fatalf("expected error from Delete(), got %v", err)
+		}
+		if repo.calls != 2 {
+			t.Fatalf("expected one attempt each, got %d", repo.calls)
+		}
+	})
+
+	t.Run("retries stop when the context ends", func(t *testing.T) {
+		repo := &scriptedRepository{err: errConnectionRefused, failures: 10}
+		r := NewResilient(repo, ResilienceOptions{Retries: 5, RetryBackoff: time.Hour})
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := r.Put(ctx, "key", domain.Secret{}); !errors.Is(err, errConnectionRefused) {
+			t.Fatalf("expected error from Put(), got %v", err)
+		}
+		if repo.calls != 1 {
+			t.Fatalf("expected a single attempt, got %d", repo.calls)
+		}
+	})
+}
+
+func TestResilientCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	repo := &scriptedRepository{err: errConnectionRefused, failures: 2}
+	r := NewResilient(repo, ResilienceOptions{
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+		Registerer:       prometheus.NewRegistry(),
+	}).(*Resilient)
+	now := time.Now()
+	r.breaker.now = func() time.Time { return now }
+
+	stateIs := func(t *testing.T, want breakerState) {
+		t.Helper()
+		for _, state := range []breakerState{breakerClosed, breakerHalfOpen, breakerOpen} {
+			want := map[bool]float64{true: 1, false: 0}[state == want]
+			if got := testutil.ToFloat64(r.state.WithLabelValues(state.String())); got != want {
+				t.Fatalf("expected %s state metric %v, got %v", state, want, got)
+			}
+		}
+	}
+	stateIs(t, breakerClosed)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Get(ctx, "key"); !errors.Is(err, errConnectionRefused) {
+			t.Fatalf("expected error from Get(), got %v", err)
+		}
+	}
+	stateIs(t, breakerOpen)
+
+	// An open breaker fails fast, including the readiness check.
+	if _, err := r.Get(ctx, "key"); !errors.Is(err, domain.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable from Get(), got %v", err)
+	}
+	if err := r.Ping(ctx); !errors.Is(err, domain.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable from Ping(), got %v", err)
+	}
+	if repo.calls != 2 {
+		t.Fatalf("expected no calls while the breaker is open, got %d", repo.calls-2)
+	}
+
+	// After the cooldown a successful trial closes the breaker.
+	now = now.Add(time.Minute)
+	if err := r.Ping(ctx); err != nil {
+		t.Fatalf("error in Ping(): %v", err)
+	}
+	stateIs(t, breakerClosed)
+	if _, err := r.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+}
+
+func TestResilientCircuitBreakerNotFound(t *testing.T) {
+	ctx := context.Background()
+	repo := &scriptedRepository{err: errConnectionRefused, failures: 1}
+	r := NewResilient(repo, ResilienceOptions{
+		FailureThreshold: 1,
+		Cooldown:         time.Minute,
+		Registerer:       prometheus.NewRegistry(),
+	}).(*Resilient)
+	now := time.Now()
+	r.breaker.now = func() time.Time { return now }
+
+	if _, err := r.Get(ctx, "key"); !errors.Is(err, errConnectionRefused) {
+		t.Fatalf("expected error from Get(), got %v", err)
+	}
+	if _, err := r.Get(ctx, "key"); !errors.Is(err, domain.ErrUnavailable) {
+		t.Fatalf("expected an open breaker, got %v", err)
+	}
+
+	// A trial that finds no secret still shows the database answers.
+	repo.err, repo.failures = domain.ErrNotFound, 2
+	now = now.Add(time.Minute)
+	if _, err := r.Get(ctx, "key"); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound from Get(), got %v", err)
+	}
+	if got := testutil.ToFloat64(r.state.WithLabelValues(breakerClosed.String())); got != 1 {
+		t.Fatalf("expected the trial to close the breaker, got closed state metric %v", got)
+	}
+	if _, err := r.Get(ctx, "key"); err != nil {
+		t.Fatalf("error in Get(): %v", err)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	var states []breakerState
+	b := newCircuitBreaker(1, time.Minute, func(s breakerState) { states = append(states, s) })
+	b.now = func() time.Time { return now }
+
+	if !b.allow() {
+		t.Fatal("expected a closed breaker to allow calls")
+	}
+	b.record(outcomeFailure)
+	if b.allow() {
+		t.Fatal("expected an open breaker to reject calls")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("expected a trial call after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("expected only a single trial call")
+	}
+	b.record(outcomeIgnored)
+	if !b.allow() {
+		t.Fatal("expected a new trial call after an inconclusive one")
+	}
+	b.record(outcomeFailure)
+	if b.allow() {
+		t.Fatal("expected a failed trial to open the breaker again")
+	}
+
+	want := []breakerState{breakerOpen, breakerHalfOpen, breakerOpen}
+	if fmt.Sprint(states) != fmt.Sprint(want) {
+		t.Fatalf("expected transitions %v, got %v", want, states)
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	for err, want := range map[error]breakerOutcome{
+		nil:                      outcomeSuccess,
+		errConnectionRefused:     outcomeFailure,
+		context.DeadlineExceeded: outcomeFailure,
+		domain.ErrNotFound:       outcomeSuccess,
+		context.Canceled:         outcomeIgnored,
+		errCircuitOpen:           outcomeIgnored,
+		ErrRepositoryFull:        outcomeIgnored,
+		fmt.Errorf("%w: 127.0.0.1:11211", errNodeDown): outcomeIgnored,
+	} {
+		if got := outcomeOf(err); got != want {
+			t.Errorf("outcomeOf(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
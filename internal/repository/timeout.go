@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// Timeout bounds every operation of the wrapped repository, so a slow
+// backend can't hold on to a request indefinitely.
+type Timeout struct {
+	repo    domain.Repository
+	timeout time.Duration
+}
+
+// NewTimeout returns repo with each operation limited to timeout.
+func NewTimeout(repo domain.Repository, timeout time.Duration) domain.Repository {
+	return &Timeout{repo: repo, timeout: timeout}
+}
+
+func (t *Timeout) Get(ctx context.Context, key string) (domain.Secret, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Get(ctx, key)
+}
+
+func (t *Timeout) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Peek(ctx, key)
+}
+
+func (t *Timeout) Put(ctx context.Context, key string, secret domain.Secret) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Put(ctx, key, secret)
+}
+
+func (t *Timeout) Delete(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Delete(ctx, key)
+}
+
+func (t *Timeout) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Status(ctx, key)
+}
+
+func (t *Timeout) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Extend(ctx, key, expiresAt)
+}
+
+func (t *Timeout) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.repo.Ping(ctx)
+}
+
+// Close closes the wrapped repository if it holds any resources.
+func (t *Timeout) Close() error {
+	if closer, ok := t.repo.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+)
+
+// slowRepository blocks every operation until its context is done.
+type slowRepository struct{}
+
+func (slowRepository) Get(ctx context.Context, key string) (domain.Secret, error) {
+	<-ctx.Done()
+	return domain.Secret{}, ctx.Err()
+}
+
+func (slowRepository) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	<-ctx.Done()
+	return domain.Secret{}, ctx.Err()
+}
+
+func (slowRepository) Put(ctx context.Context, key string, secret domain.Secret) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (slowRepository) Delete(ctx context.Context, key string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func (slowRepository) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	<-ctx.Done()
+	return domain.Metadata{}, ctx.Err()
+}
+
+func (slowRepository) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (slowRepository) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTimeout(slowRepository{}, 10*time.Millisecond)
+
+	if _, err := repo.Get(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Get() to time out, got %v", err)
+	}
+	if _, err := repo.Peek(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Peek() to time out, got %v", err)
+	}
+	if err := repo.Put(ctx, "key", domain.Secret{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Put() to time out, got %v", err)
+	}
+	if _, err := repo.Delete(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Delete() to time out, got %v", err)
+	}
+	if _, err := repo.Status(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Status() to time out, got %v", err)
+	}
+	if err := repo.Extend(ctx, "key", time.Now()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Extend() to time out, got %v", err)
+	}
+	if err := repo.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Ping() to time out, got %v", err)
+	}
+}
+
+func TestTimeoutKeepsCallerCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repo := NewTimeout(slowRepository{}, time.Minute)
+	if _, err := repo.Get(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Get() to be canceled, got %v", err)
+	}
+}
+
+func TestTimeoutClose(t *testing.T) {
+	m := NewMemory(0)
+	repo := NewTimeout(m, time.Second).(*Timeout)
+	if err := repo.Close(); err != nil {
+		t.Fatalf("error in Close(): %v", err)
+	}
+	if err := NewTimeout(slowRepository{}, time.Second).(*Timeout).Close(); err != nil {
+		t.Fatalf("expected Close() without closable repository to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package armor converts ASCII-armored OpenPGP messages into a compact
+// binary form for storage and back.
+//
+// The compact form keeps the armor headers, line width and line endings of
+// the original message, so restoring it gives the client exactly the bytes
+// it uploaded.
+package armor
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Prefix marks a message stored in the compact form. Messages without it
+// are stored as they were uploaded.
+const Prefix = "yopass:binary:"
+
+const (
+	beginLine = "-----BEGIN PGP MESSAGE-----"
+	endLine   = "-----END PGP MESSAGE-----"
+)
+
+const (
+	flagCRLF = 1 << iota
+	flagChecksum
+)
+
+var (
+	// ErrNotArmored is returned by Decode for anything but an armored
+	// message with a valid body.
+	ErrNotArmored = errors.New("not an ASCII-armored PGP message")
+	// ErrMalformed is returned by Expand for a corrupt compact message.
+	ErrMalformed = errors.New("malformed compact message")
+)
+
+// Message is a decoded ASCII-armored PGP message.
+type Message struct {
+	// Packets holds the binary OpenPGP packets of the message.
+	Packets []byte
+
+	// head is everything up to the first line of the body, i.e. the begin
+	// line, the armor headers and the blank line after them. tail is
+	// everything from the end line on.
+	head, tail string
+	width      int
+	crlf       bool
+	checksum   bool
+}
+
+// Decode parses an ASCII-armored PGP message.
+func Decode(armored string) (*Message, error) {
+	if !strings.HasPrefix(armored, beginLine) {
+		return nil, ErrNotArmored
+	}
+	m := &Message{}
+	eol := "\n"
+	if i := strings.IndexByte(armored, '\n'); i > 0 && armored[i-1] == '\r' {
+		eol = "\r\n"
+		m.crlf = true
+	}
+
+	headEnd := strings.Index(armored, eol+eol)
+	if headEnd < 0 {
+		return nil, ErrNotArmored
+	}
+	headEnd += 2 * len(eol)
+	m.head = armored[:headEnd]
+
+	tailStart := strings.Index(armored[headEnd:], endLine)
+	if tailStart < 0 {
+		return nil, ErrNotArmored
+	}
+	tailStart += headEnd
+	m.tail = armored[tailStart:]
+
+	lines := strings.Split(strings.TrimSuffix(armored[headEnd:tailStart], eol), eol)
+	if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], "=") {
+		m.checksum = true
+		lines = lines[:n-1]
+	}
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, ErrNotArmored
+	}
+	m.width = len(lines[0])
+
+	packets, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil {
+		return nil, ErrNotArmored
+	}
+	m.Packets = packets
+	return m, nil
+}
+
+// Encode returns the message ASCII-armored the same way it was decoded.
+// That's the original text unless it wasn't armored canonically, e.g.
+// with lines of varying width or a wrong checksum.
+func (m *Message) Encode() string {
+	eol := "\n"
+	if m.crlf {
+		eol = "\r\n"
+	}
+	body := base64.StdEncoding.EncodeToString(m.Packets)
+
+	var b strings.Builder
+	b.Grow(len(m.head) + len(body) + (len(body)/m.width+2)*len(eol) + 6 + len(m.tail))
+	b.WriteString(m.head)
+	for len(body) > m.width {
+		b.WriteString(body[:m.width])
+		b.WriteString(eol)
+		body = body[m.width:]
+	}
+	b.WriteString(body)
+	b.WriteString(eol)
+	if m.checksum {
+		crc := crc24(m.Packets)
+		b.WriteString("=")
+		b.WriteString(base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}))
+		b.WriteString(eol)
+	}
+	b.WriteString(m.tail)
+	return b.String()
+}
+
+// Compact returns the message in the compact form starting with Prefix.
+func (m *Message) Compact() string {
+	var b []byte
+	b = append(b, Prefix...)
+	b = binary.AppendUvarint(b, uint64(len(m.head)))
+	b = append(b, m.head...)
+	b = binary.AppendUvarint(b, uint64(len(m.tail)))
+	b = append(b, m.tail...)
+	b = binary.AppendUvarint(b, uint64(m.width))
+	var flags byte
+	if m.crlf {
+		flags |= flagCRLF
+	}
+	if m.checksum {
+		flags |= flagChecksum
+	}
+	b = append(b, flags)
+	b = append(b, m.Packets...)
+	return string(b)
+}
+
+// Expand returns the ASCII-armored form of a stored message. Messages not
+// in the compact form are returned unchanged.
+func Expand(stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, Prefix)
+	if !ok {
+		return stored, nil
+	}
+	m := &Message{}
+	var err error
+	if m.head, rest, err = cutString(rest); err != nil {
+		return "", err
+	}
+	if m.tail, rest, err = cutString(rest); err != nil {
+		return "", err
+	}
+	width, n := uvarint(rest)
+	if n <= 0 || width == 0 || width > uint64(len(stored)) || len(rest) <= n {
+		return "", ErrMalformed
+	}
+	m.width = int(width)
+	flags := rest[n]
+	m.crlf = flags&flagCRLF != 0
+	m.checksum = flags&flagChecksum != 0
+	m.Packets = []byte(rest[n+1:])
+	return m.Encode(), nil
+}
+
+// cutString splits a length prefixed string off the start of s.
+func cutString(s string) (value, rest string, err error) {
+	length, n := uvarint(s)
+	if n <= 0 || length > uint64(len(s)-n) {
+		return "", "", ErrMalformed
+	}
+	end := n + int(length)
+	return s[n:end], s[end:], nil
+}
+
+// uvarint decodes the varint at the start of s without copying all of s.
+func uvarint(s string) (uint64, int) {
+	return binary.Uvarint([]byte(s[:min(len(s), binary.MaxVarintLen64)]))
+}
+
+// crc24 computes the armor checksum of RFC 4880, section 6.1.
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
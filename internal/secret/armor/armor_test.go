@@ -0,0 +1,119 @@
+package armor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	xarmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func armored(t *testing.T, packets []byte, headers map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xarmor.Encode(&buf, "PGP MESSAGE", headers)
+	if err != nil {
+		t.Fatalf("error in Encode(): %v", err)
+	}
+	w.Write(packets)
+	w.Close()
+	return buf.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	packets := make([]byte, 1000)
+	rand.Read(packets)
+
+	// Lines of 76 characters without checksum, as written by OpenPGP.js.
+	body := strings.Join(wrap(base64.StdEncoding.EncodeToString(packets), 76), "\r\n")
+	tests := map[string]string{
+		"go-crypto":  armored(t, packets, map[string]string{"Comment": "https://yopass.se"}),
+		"no headers": armored(t, packets, nil),
+		"openpgp.js": "-----BEGIN PGP MESSAGE-----\r\n\r\n" + body + "\r\n-----END PGP MESSAGE-----\r\n",
+		"short body": armored(t, packets[:10], nil),
+	}
+	for name, message := range tests {
+		t.Run(name, func(t *testing.T) {
+			m, err := Decode(message)
+			if err != nil {
+				t.Fatalf("error in Decode(): %v", err)
+			}
+			if !bytes.Equal(m.Packets, packets[:len(m.Packets)]) {
+				t.Fatal("decoded packets differ")
+			}
+			if got := m.Encode(); got != message {
+				t.Fatalf("expected Encode() to restore the message, got\n%s", got)
+			}
+
+			compact := m.Compact()
+			if len(m.Packets) == len(packets) && len(compact) >= len(message)*3/4+100 {
+				t.Fatalf("expected compact form to save the base64 overhead, got %d bytes for %d", len(compact), len(message))
+			}
+			expanded, err := Expand(compact)
+			if err != nil {
+				t.Fatalf("error in Expand(): %v", err)
+			}
+			if expanded != message {
+				t.Fatalf("expected Expand() to restore the message, got\n%s", expanded)
+			}
+		})
+	}
+}
+
+func TestEncodeNonCanonical(t *testing.T) {
+	message := armored(t, []byte("some packets of an encrypted message"), nil)
+	lines := strings.Split(message, "\n")
+	lines[2] = lines[2][:10] + "\n" + lines[2][10:]
+	message = strings.Join(lines, "\n")
+
+	m, err := Decode(message)
+	if err != nil {
+		t.Fatalf("error in Decode(): %v", err)
+	}
+	if string(m.Packets) != "some packets of an encrypted message" {
+		t.Fatalf("unexpected packets %q", m.Packets)
+	}
+	if m.Encode() == message {
+		t.Fatal("expected lines of varying width to be rewrapped")
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, message := range []string{
+		"",
+		"plain text",
+		"-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
+		"-----BEGIN PGP MESSAGE-----\n\n-----END PGP MESSAGE-----",
+		"-----BEGIN PGP MESSAGE-----\n\nnot base64!\n-----END PGP MESSAGE-----",
+		"-----BEGIN PGP MESSAGE-----\n\nAAAA\n",
+	} {
+		if _, err := Decode(message); err != ErrNotArmored {
+			t.Errorf("expected ErrNotArmored for %q, got %v", message, err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	message := "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----"
+	if got, err := Expand(message); err != nil || got != message {
+		t.Fatalf("expected message without prefix to be returned unchanged, got %q, %v", got, err)
+	}
+
+	compact := (&Message{Packets: []byte("abc"), head: "head\n\n", tail: "tail", width: 64}).Compact()
+	for i := len(Prefix); i < len(compact)-3; i++ {
+		if _, err := Expand(compact[:i]); err != ErrMalformed {
+			t.Fatalf("expected ErrMalformed for truncated message %q, got %v", compact[:i], err)
+		}
+	}
+}
+
+func wrap(s string, width int) []string {
+	var lines []string
+	for len(s) > width {
+		lines = append(lines, s[:width])
+		s = s[width:]
+	}
+	return append(lines, s)
+}
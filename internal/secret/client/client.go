@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 )
@@ -27,6 +28,35 @@ func (e *ServerError) Unwrap() error {
 
 type serverResponse struct {
 	Message string `json:"message"`
+	Token   string `json:"token,omitempty"`
+}
+
+// Created identifies a stored secret.
+type Created struct {
+	ID string
+	// Token is the management token of the secret. Servers that don't
+	// support managing secrets return none.
+	Token string
+}
+
+// Status describes a secret to its creator.
+type Status struct {
+	OneTime        bool      `json:"oneTime"`
+	MaxViews       int       `json:"maxViews"`
+	RemainingViews int       `json:"remainingViews"`
+	Size           int       `json:"size"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	Viewed         bool      `json:"viewed"`
+	ViewedAt       time.Time `json:"viewedAt"`
+}
+
+// Request describes a request for a secret to whoever is asked to send it.
+type Request struct {
+	// PublicKey is the armored key to encrypt the secret to. Without one,
+	// the secret is encrypted with the key from the request link.
+	PublicKey string    `json:"publicKey"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 func Fetch(serverURL string, id string) (string, error) {
@@ -40,35 +70,149 @@ func Fetch(serverURL string, id string) (string, error) {
 }
 
 func Store(serverURL string, s domain.Secret) (string, error) {
+	c, err := Create(serverURL, s)
+	return c.ID, err
+}
+
+// Create stores the secret and returns its ID along with its management
+// token.
+func Create(serverURL string, s domain.Secret) (Created, error) {
 	serverURL = strings.TrimSuffix(serverURL, "/")
 
 	var j bytes.Buffer
 	if err := json.NewEncoder(&j).Encode(&s); err != nil {
-		return "", fmt.Errorf("could not encode request: %w", err)
+		return Created{}, fmt.Errorf("could not encode request: %w", err)
 	}
 	resp, err := HTTPClient.Post(serverURL+"/secret", "application/json", &j)
 	if err != nil {
-		return "", &ServerError{err: err}
+		return Created{}, &ServerError{err: err}
 	}
-	return handleServerResponse(resp)
+	var r serverResponse
+	if err := decodeResponse(resp, &r); err != nil {
+		return Created{}, err
+	}
+	return Created{ID: r.Message, Token: r.Token}, nil
+}
+
+// Info returns the status of the secret id, including whether it has been
+// viewed, to the holder of its management token.
+func Info(serverURL, id, token string) (Status, error) {
+	var status Status
+	err := manage(serverURL, http.MethodGet, id, token, nil, &status)
+	return status, err
+}
+
+// Extend lets the secret id expire expiration seconds from now instead.
+func Extend(serverURL, id, token string, expiration int32) (Status, error) {
+	body, err := json.Marshal(map[string]int32{"expiration": expiration})
+	if err != nil {
+		return Status{}, fmt.Errorf("could not encode request: %w", err)
+	}
+	var status Status
+	err = manage(serverURL, http.MethodPatch, id, token, bytes.NewReader(body), &status)
+	return status, err
+}
+
+// Delete deletes the secret id before it is viewed.
+func Delete(serverURL, id, token string) error {
+	return manage(serverURL, http.MethodDelete, id, token, nil, nil)
+}
+
+// CreateRequest stores a request for a secret and returns its ID along
+// with the token to fetch the secret with.
+func CreateRequest(serverURL string, r domain.Request) (Created, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return Created{}, fmt.Errorf("could not encode request: %w", err)
+	}
+	var resp serverResponse
+	if err := send(serverURL, http.MethodPost, "/request", "", bytes.NewReader(body), &resp); err != nil {
+		return Created{}, err
+	}
+	return Created{ID: resp.Message, Token: resp.Token}, nil
+}
+
+// GetRequest returns how to encrypt the secret asked for by the request
+// id.
+func GetRequest(serverURL, id string) (Request, error) {
+	var r Request
+	err := send(serverURL, http.MethodGet, "/request/"+id, "", nil, &r)
+	return r, err
+}
+
+// Fulfil sends the encrypted secret asked for by the request id.
+func Fulfil(serverURL, id string, s domain.Secret) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("could not encode request: %w", err)
+	}
+	return send(serverURL, http.MethodPost, "/request/"+id, "", bytes.NewReader(body), nil)
+}
+
+// FetchRequested returns the secret sent for the request id to the holder
+// of the request token.
+func FetchRequested(serverURL, id, token string) (string, error) {
+	var r serverResponse
+	err := send(serverURL, http.MethodGet, "/request/"+id+"/secret", token, nil, &r)
+	return r.Message, err
+}
+
+// manage sends a request authorized by the management token to the manage
+// endpoint of the secret id and decodes the response into v.
+func manage(serverURL, method, id, token string, body io.Reader, v any) error {
+	return send(serverURL, method, "/secret/"+id+"/manage", token, body, v)
+}
+
+// send sends a request to path, authorized by token unless it is empty,
+// and decodes the response into v.
+func send(serverURL, method, path, token string, body io.Reader, v any) error {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+
+	req, err := http.NewRequest(method, serverURL+path, body)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return &ServerError{err: err}
+	}
+	return decodeResponse(resp, v)
 }
 
 func handleServerResponse(resp *http.Response) (string, error) {
+	var r serverResponse
+	if err := decodeResponse(resp, &r); err != nil {
+		return "", err
+	}
+	return r.Message, nil
+}
+
+// decodeResponse decodes a successful response into v, unless v is nil.
+// Any other response is turned into a ServerError.
+func decodeResponse(resp *http.Response, v any) error {
 	defer resp.Body.Close()
 
-	var r serverResponse
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		msg, _ := io.ReadAll(resp.Body)
+		var r serverResponse
 		if err := json.Unmarshal(msg, &r); err == nil {
 			msg = []byte(r.Message)
 		}
 		err := fmt.Errorf("unexpected response %s: %s", resp.Status, string(msg))
-		return "", &ServerError{err: err}
+		return &ServerError{err: err}
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
-		return "", fmt.Errorf("could not decode server response: %w", err)
+	if v == nil {
+		return nil
 	}
 
-	return r.Message, nil
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode server response: %w", err)
+	}
+	return nil
 }
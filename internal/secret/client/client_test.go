@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 )
@@ -37,12 +38,15 @@ func TestStore(t *testing.T) {
 		if s.Message != "encrypted-content" {
 			t.Errorf("Expected encrypted-content, got %s", s.Message)
 		}
+		if s.MaxViews != 3 {
+			t.Errorf("Expected 3 views, got %d", s.MaxViews)
+		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(serverResponse{Message: "stored-id"})
 	}))
 	defer ts.Close()
 
-	s := domain.Secret{Message: "encrypted-content"}
+	s := domain.Secret{Message: "encrypted-content", MaxViews: 3}
 	got, err := Store(ts.URL, s)
 	if err != nil {
 		t.Fatalf("Store failed: %v", err)
@@ -52,6 +56,125 @@ func TestStore(t *testing.T) {
 	}
 }
 
+func TestCreate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(serverResponse{Message: "stored-id", Token: "token"})
+	}))
+	defer ts.Close()
+
+	got, err := Create(ts.URL, domain.Secret{Message: "encrypted-content"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if got.ID != "stored-id" || got.Token != "token" {
+		t.Errorf("Expected stored-id with token, got %+v", got)
+	}
+}
+
+func TestManage(t *testing.T) {
+	viewedAt := time.Date(2024, 5, 1, 12, 10, 0, 0, time.UTC)
+	var extended map[string]int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secret/test-id/manage" {
+			t.Errorf("Expected path /secret/test-id/manage, got %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(serverResponse{Message: "Invalid management token"})
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]any{"oneTime": true, "viewed": true, "viewedAt": viewedAt})
+		case http.MethodPatch:
+			json.NewDecoder(r.Body).Decode(&extended)
+			json.NewEncoder(w).Encode(map[string]any{"oneTime": true})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	status, err := Info(ts.URL, "test-id", "token")
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if !status.OneTime || !status.Viewed || !status.ViewedAt.Equal(viewedAt) {
+		t.Errorf("Expected viewed one-time secret, got %+v", status)
+	}
+	if _, err := Info(ts.URL, "test-id", "wrong"); err == nil || !contains(err.Error(), "Invalid management token") {
+		t.Errorf("Expected error for a wrong token, got %v", err)
+	}
+	if _, err := Extend(ts.URL, "test-id", "token", 86400); err != nil {
+		t.Fatalf("Extend failed: %v", err)
+	}
+	if extended["expiration"] != 86400 {
+		t.Errorf("Expected expiration 86400, got %v", extended)
+	}
+	if err := Delete(ts.URL, "test-id", "token"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+}
+
+func TestRequest(t *testing.T) {
+	expiresAt := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	var request domain.Request
+	var fulfilled domain.Secret
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /request":
+			json.NewDecoder(r.Body).Decode(&request)
+			json.NewEncoder(w).Encode(serverResponse{Message: "test-id", Token: "token"})
+		case "GET /request/test-id":
+			json.NewEncoder(w).Encode(map[string]any{"publicKey": "key", "expiresAt": expiresAt, "ttl": 60})
+		case "POST /request/test-id":
+			json.NewDecoder(r.Body).Decode(&fulfilled)
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /request/test-id/secret":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(serverResponse{Message: "Invalid request token"})
+				return
+			}
+			json.NewEncoder(w).Encode(serverResponse{Message: "encrypted-content"})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	created, err := CreateRequest(ts.URL, domain.Request{Expiration: 3600, PublicKey: "key"})
+	if err != nil {
+		t.Fatalf("CreateRequest failed: %v", err)
+	}
+	if created.ID != "test-id" || created.Token != "token" || request.Expiration != 3600 || request.PublicKey != "key" {
+		t.Errorf("Expected test-id with token for the request, got %+v for %+v", created, request)
+	}
+	r, err := GetRequest(ts.URL, "test-id")
+	if err != nil {
+		t.Fatalf("GetRequest failed: %v", err)
+	}
+	if r.PublicKey != "key" || !r.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected request with public key, got %+v", r)
+	}
+	if err := Fulfil(ts.URL, "test-id", domain.Secret{Message: "encrypted-content"}); err != nil {
+		t.Fatalf("Fulfil failed: %v", err)
+	}
+	if fulfilled.Message != "encrypted-content" {
+		t.Errorf("Expected encrypted-content, got %s", fulfilled.Message)
+	}
+	got, err := FetchRequested(ts.URL, "test-id", "token")
+	if err != nil {
+		t.Fatalf("FetchRequested failed: %v", err)
+	}
+	if got != "encrypted-content" {
+		t.Errorf("Expected encrypted-content, got %s", got)
+	}
+	if _, err := FetchRequested(ts.URL, "test-id", "wrong"); err == nil || !contains(err.Error(), "Invalid request token") {
+		t.Errorf("Expected error for a wrong token, got %v", err)
+	}
+}
+
 func TestFetchError(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
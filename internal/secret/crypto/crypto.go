@@ -10,15 +10,17 @@ import (
 	"io"
 	"os"
 
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
-	"golang.org/x/crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 var (
-	ErrEmptyKey       = errors.New("empty encryption key")
-	ErrInvalidKey     = errors.New("invalid decryption key")
-	ErrInvalidMessage = errors.New("invalid message")
+	ErrEmptyKey          = errors.New("empty encryption key")
+	ErrInvalidKey        = errors.New("invalid decryption key")
+	ErrInvalidMessage    = errors.New("invalid message")
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidPassphrase = errors.New("invalid passphrase of private key")
 )
 
 var pgpConfig = &packet.Config{
@@ -31,20 +33,44 @@ var pgpHeader = map[string]string{
 	"Comment": "https://yopass.se",
 }
 
-func Decrypt(r io.Reader, key string) (content, filename string, err error) {
+// Decrypt decrypts the armored message read from r with key or, for
+// messages encrypted to public keys, with the private keys of keyring, which
+// may be nil.
+func Decrypt(r io.Reader, key string, keyring *Keyring) (content, filename string, err error) {
+	// ReadMessage needs a keyring, if an empty one, for messages encrypted
+	// to public keys.
+	entities := openpgp.EntityList{}
+	if keyring != nil {
+		entities = keyring.Entities
+	}
 	tried := false
-	prompt := func([]openpgp.Key, bool) ([]byte, error) {
-		if tried {
+	// ReadMessage calls prompt until the message decrypts, with the keys
+	// it is encrypted to that are still locked. Each call tries the key
+	// first, then unlocks one more private key.
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if symmetric && !tried {
+			tried = true
+			if key != "" || len(keys) == 0 {
+				return []byte(key), nil
+			}
+		}
+		if len(keys) == 0 || keyring == nil || keyring.Passphrase == nil {
 			return nil, ErrInvalidKey
 		}
-		tried = true
-		return []byte(key), nil
+		passphrase, err := keyring.Passphrase(keys[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := keys[0].PrivateKey.Decrypt(passphrase); err != nil {
+			return nil, ErrInvalidPassphrase
+		}
+		return nil, nil
 	}
 	a, err := armor.Decode(r)
 	if err != nil {
 		return "", "", ErrInvalidMessage
 	}
-	m, err := openpgp.ReadMessage(a.Body, nil, prompt, pgpConfig)
+	m, err := openpgp.ReadMessage(a.Body, entities, prompt, pgpConfig)
 	if err != nil {
 		return "", "", fmt.Errorf("could not decrypt: %w", err)
 	}
@@ -58,11 +84,74 @@ func Decrypt(r io.Reader, key string) (content, filename string, err error) {
 	return string(p), filename, nil
 }
 
-func Encrypt(r io.Reader, key string) (string, error) {
-	if key == "" {
+// Encrypt encrypts the content of r with key and to the public keys of
+// recipients, so that key as well as the private key of every recipient
+// decrypts it. Either may be left out, but not both.
+func Encrypt(r io.Reader, key string, recipients ...*openpgp.Entity) (string, error) {
+	if key == "" && len(recipients) == 0 {
 		return "", ErrEmptyKey
 	}
+	if len(recipients) == 0 {
+		return encrypt(r, func(w io.Writer, hints *openpgp.FileHints) (io.WriteCloser, error) {
+			return openpgp.SymmetricallyEncrypt(w, []byte(key), hints, pgpConfig)
+		})
+	}
+
+	keys := make([]*packet.PublicKey, len(recipients))
+	for i, e := range recipients {
+		k, ok := e.EncryptionKey(pgpConfig.Now())
+		if !ok {
+			return "", fmt.Errorf("%w: key %X can't encrypt", ErrInvalidPublicKey, e.PrimaryKey.Fingerprint)
+		}
+		keys[i] = k.PublicKey
+	}
+	return encrypt(r, func(w io.Writer, hints *openpgp.FileHints) (io.WriteCloser, error) {
+		return encryptTo(w, key, keys, hints)
+	})
+}
+
+// encryptTo writes the session key encrypted to each of keys and, unless
+// empty, with passphrase to w, and returns the writer of the content. Unlike
+// openpgp.Encrypt, which never uses a passphrase, it doesn't sign or
+// compress.
+func encryptTo(w io.Writer, passphrase string, keys []*packet.PublicKey, hints *openpgp.FileHints) (io.WriteCloser, error) {
+	cipher := pgpConfig.Cipher()
+	var sessionKey []byte
+	if passphrase != "" {
+		k, err := packet.SerializeSymmetricKeyEncrypted(w, []byte(passphrase), pgpConfig)
+		if err != nil {
+			return nil, err
+		}
+		sessionKey = k
+	} else {
+		sessionKey = make([]byte, cipher.KeySize())
+		if _, err := rand.Read(sessionKey); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range keys {
+		if err := packet.SerializeEncryptedKeyAEAD(w, k, cipher, false, sessionKey, pgpConfig); err != nil {
+			return nil, err
+		}
+	}
+	contents, err := packet.SerializeSymmetricallyEncrypted(w, cipher, false, packet.CipherSuite{}, sessionKey, pgpConfig)
+	if err != nil {
+		return nil, err
+	}
+	if hints == nil {
+		hints = &openpgp.FileHints{}
+	}
+	var modTime uint32
+	if !hints.ModTime.IsZero() {
+		modTime = uint32(hints.ModTime.Unix())
+	}
+	return packet.SerializeLiteral(contents, hints.IsBinary, hints.FileName, modTime)
+}
 
+// encrypt returns the armored message written by the encrypter opened by
+// open with the content of r. Files are marked as binary and keep their
+// name.
+func encrypt(r io.Reader, open func(io.Writer, *openpgp.FileHints) (io.WriteCloser, error)) (string, error) {
 	var hints *openpgp.FileHints
 	if f, ok := r.(*os.File); ok && r != os.Stdin {
 		stat, err := f.Stat()
@@ -81,7 +170,7 @@ func Encrypt(r io.Reader, key string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("could not create armor encoder: %w", err)
 	}
-	w, err := openpgp.SymmetricallyEncrypt(a, []byte(key), hints, pgpConfig)
+	w, err := open(a, hints)
 	if err != nil {
 		return "", fmt.Errorf("could not encrypt: %w", err)
 	}
@@ -2,8 +2,16 @@ package crypto
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 )
 
 func TestEncryptDecrypt(t *testing.T) {
@@ -21,7 +29,7 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 
 	// Test Decrypt
-	decrypted, filename, err := Decrypt(strings.NewReader(encrypted), key)
+	decrypted, filename, err := Decrypt(strings.NewReader(encrypted), key, nil)
 	if err != nil {
 		t.Fatalf("Decrypt failed: %v", err)
 	}
@@ -46,7 +54,7 @@ func TestDecryptInvalidKey(t *testing.T) {
 	content := "hello world"
 	encrypted, _ := Encrypt(strings.NewReader(content), key)
 
-	_, _, err := Decrypt(strings.NewReader(encrypted), "wrong-key")
+	_, _, err := Decrypt(strings.NewReader(encrypted), "wrong-key", nil)
 	if err == nil {
 		t.Fatal("Expected error for wrong key, got nil")
 	}
@@ -56,7 +64,7 @@ func TestDecryptInvalidKey(t *testing.T) {
 }
 
 func TestDecryptInvalidMessage(t *testing.T) {
-	_, _, err := Decrypt(strings.NewReader("invalid message"), "key")
+	_, _, err := Decrypt(strings.NewReader("invalid message"), "key", nil)
 	if err != ErrInvalidMessage {
 		t.Errorf("Expected ErrInvalidMessage, got %v", err)
 	}
@@ -90,7 +98,7 @@ func TestEncryptWithFileName(t *testing.T) {
 		t.Fatal(err)
 	}
 	
-	dec, filename, err := Decrypt(strings.NewReader(encrypted), key)
+	dec, filename, err := Decrypt(strings.NewReader(encrypted), key, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,3 +109,260 @@ func TestEncryptWithFileName(t *testing.T) {
 		t.Errorf("got filename %q, want empty", filename)
 	}
 }
+
+// newTestKey returns a new key pair along with its armored public key
+// or, if private is set, its armored private key.
+func newTestKey(t *testing.T, private bool) (*openpgp.Entity, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Requester", "", "requester@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity failed: %v", err)
+	}
+	var buf bytes.Buffer
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatalf("armor.Encode failed: %v", err)
+	}
+	if private {
+		err = entity.SerializePrivate(w, nil)
+	} else {
+		err = entity.Serialize(w)
+	}
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	w.Close()
+	return entity, buf.String()
+}
+
+func TestEncryptToRecipients(t *testing.T) {
+	alice, armored := newTestKey(t, false)
+	bob, _ := newTestKey(t, false)
+	eve, _ := newTestKey(t, false)
+	key, err := ReadPublicKey(armored)
+	if err != nil {
+		t.Fatalf("ReadPublicKey failed: %v", err)
+	}
+	if key.PrimaryKey.KeyId != alice.PrimaryKey.KeyId {
+		t.Errorf("Expected key %X, got %X", alice.PrimaryKey.KeyId, key.PrimaryKey.KeyId)
+	}
+
+	encrypted, err := Encrypt(strings.NewReader("hello world"), "", key, bob)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, "-----BEGIN PGP MESSAGE-----") {
+		t.Errorf("Expected PGP message, got: %s", encrypted)
+	}
+	for name, e := range map[string]*openpgp.Entity{"alice": alice, "bob": bob} {
+		content, _, err := Decrypt(strings.NewReader(encrypted), "", &Keyring{Entities: openpgp.EntityList{e}})
+		if err != nil || content != "hello world" {
+			t.Errorf("Expected %s to decrypt %q, got %q (%v)", name, "hello world", content, err)
+		}
+	}
+	if _, _, err := Decrypt(strings.NewReader(encrypted), "", &Keyring{Entities: openpgp.EntityList{eve}}); err == nil {
+		t.Error("Expected a message not encrypted to a key not to decrypt with it")
+	}
+	if _, _, err := Decrypt(strings.NewReader(encrypted), "test-key", nil); err == nil {
+		t.Error("Expected a message encrypted to public keys not to decrypt with a passphrase")
+	}
+
+	// Messages encrypted with a key as well decrypt with either.
+	encrypted, err = Encrypt(strings.NewReader("hello world"), "test-key", alice)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	for name, keyring := range map[string]*Keyring{"key": nil, "private key": {Entities: openpgp.EntityList{alice}}} {
+		key := "test-key"
+		if keyring != nil {
+			key = "wrong-key"
+		}
+		content, _, err := Decrypt(strings.NewReader(encrypted), key, keyring)
+		if err != nil || content != "hello world" {
+			t.Errorf("Expected the %s to decrypt %q, got %q (%v)", name, "hello world", content, err)
+		}
+	}
+	if _, _, err := Decrypt(strings.NewReader(encrypted), "wrong-key", nil); err == nil {
+		t.Error("Expected a wrong key not to decrypt the message")
+	}
+}
+
+// readTestdata returns the content of the file name in testdata. The keys
+// and the message were made with GnuPG 2.2: helpdesk is an RSA key pair
+// whose private key is protected with the passphrase "correct horse",
+// curve an Ed25519 key with a Curve25519 subkey, the default of GnuPG 2.3
+// and newer, and the same passphrase, and message is encrypted to helpdesk
+// and with the passphrase "yopass key".
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// passphrase returns a passphrase prompt answering with passphrase that
+// counts how often it was asked.
+func passphrase(passphrase string, asked *int) func(openpgp.Key) ([]byte, error) {
+	return func(openpgp.Key) ([]byte, error) {
+		*asked++
+		return []byte(passphrase), nil
+	}
+}
+
+func TestGnuPGKeys(t *testing.T) {
+	key, err := ReadPublicKey(string(readTestdata(t, "helpdesk.asc")))
+	if err != nil {
+		t.Fatalf("ReadPublicKey failed: %v", err)
+	}
+	if got := fmt.Sprintf("%X", key.PrimaryKey.Fingerprint); got != "8B02FA86DD285D8EDDC15888CDBD8B3AFE472996" {
+		t.Errorf("Expected the helpdesk key, got %s", got)
+	}
+	if _, err := ReadPublicKey(string(readTestdata(t, "helpdesk-secret.gpg"))); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected ErrInvalidPublicKey for a private key, got %v", err)
+	}
+	if _, err := ReadKeyring(readTestdata(t, "helpdesk.asc"), nil); err == nil {
+		t.Error("Expected an error for a keyring without private keys")
+	}
+
+	message := string(readTestdata(t, "message.asc"))
+	if content, _, err := Decrypt(strings.NewReader(message), "yopass key", nil); err != nil || content != "hello from GnuPG" {
+		t.Errorf("Expected the key to decrypt the message, got %q (%v)", content, err)
+	}
+
+	asked := 0
+	keyring, err := ReadKeyring(readTestdata(t, "helpdesk-secret.gpg"), passphrase("wrong", &asked))
+	if err != nil {
+		t.Fatalf("ReadKeyring failed: %v", err)
+	}
+	if _, _, err := Decrypt(strings.NewReader(message), "", keyring); !errors.Is(err, ErrInvalidPassphrase) || asked != 1 {
+		t.Errorf("Expected ErrInvalidPassphrase after asking once, got %v after asking %d times", err, asked)
+	}
+	keyring.Passphrase = passphrase("correct horse", &asked)
+	asked = 0
+	if content, _, err := Decrypt(strings.NewReader(message), "", keyring); err != nil || content != "hello from GnuPG" || asked != 1 {
+		t.Errorf("Expected the private key to decrypt the message after asking once, got %q (%v) after asking %d times", content, err, asked)
+	}
+
+	// The unlocked key decrypts messages encrypted to the public key.
+	encrypted, err := Encrypt(strings.NewReader("hello world"), "", key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if content, _, err := Decrypt(strings.NewReader(encrypted), "", keyring); err != nil || content != "hello world" || asked != 1 {
+		t.Errorf("Expected %q without asking again, got %q (%v) after asking %d times", "hello world", content, err, asked)
+	}
+}
+
+func TestCurve25519Keys(t *testing.T) {
+	key, err := ReadPublicKey(string(readTestdata(t, "curve.asc")))
+	if err != nil {
+		t.Fatalf("ReadPublicKey failed: %v", err)
+	}
+	encrypted, err := Encrypt(strings.NewReader("hello curve"), "yopass key", key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	asked := 0
+	keyring, err := ReadKeyring(readTestdata(t, "curve-secret.gpg"), passphrase("correct horse", &asked))
+	if err != nil {
+		t.Fatalf("ReadKeyring failed: %v", err)
+	}
+	if content, _, err := Decrypt(strings.NewReader(encrypted), "", keyring); err != nil || content != "hello curve" || asked != 1 {
+		t.Errorf("Expected the private key to decrypt the message after asking once, got %q (%v) after asking %d times", content, err, asked)
+	}
+	if content, _, err := Decrypt(strings.NewReader(encrypted), "yopass key", nil); err != nil || content != "hello curve" {
+		t.Errorf("Expected the key to decrypt the message, got %q (%v)", content, err)
+	}
+}
+
+func TestGnuPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("skipping GnuPG interoperability test - gpg not installed")
+	}
+	home := t.TempDir()
+	if err := os.Chmod(home, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	gpg := func(stdin string, args ...string) (string, error) {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback"}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%w: %s", err, exitErr.Stderr)
+		}
+		return string(out), err
+	}
+	t.Cleanup(func() { exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run() })
+
+	key, err := ReadPublicKey(string(readTestdata(t, "helpdesk.asc")))
+	if err != nil {
+		t.Fatalf("ReadPublicKey failed: %v", err)
+	}
+	encrypted, err := Encrypt(strings.NewReader("hello GnuPG"), "yopass key", key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if content, err := gpg(encrypted, "--passphrase", "yopass key", "--decrypt"); err != nil || content != "hello GnuPG" {
+		t.Errorf("Expected gpg to decrypt the message with the key, got %q (%v)", content, err)
+	}
+	if _, err := gpg(string(readTestdata(t, "helpdesk-secret.gpg")), "--passphrase", "correct horse", "--import"); err != nil {
+		t.Fatalf("gpg --import failed: %v", err)
+	}
+	// gpg would try the passphrase of the private key as the key, too.
+	encrypted, err = Encrypt(strings.NewReader("hello GnuPG"), "", key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if content, err := gpg(encrypted, "--passphrase", "correct horse", "--decrypt"); err != nil || content != "hello GnuPG" {
+		t.Errorf("Expected gpg to decrypt the message with the private key, got %q (%v)", content, err)
+	}
+
+	key, err = ReadPublicKey(string(readTestdata(t, "curve.asc")))
+	if err != nil {
+		t.Fatalf("ReadPublicKey failed: %v", err)
+	}
+	if _, err := gpg(string(readTestdata(t, "curve-secret.gpg")), "--passphrase", "correct horse", "--import"); err != nil {
+		t.Fatalf("gpg --import failed: %v", err)
+	}
+	encrypted, err = Encrypt(strings.NewReader("hello curve"), "", key)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if content, err := gpg(encrypted, "--passphrase", "correct horse", "--decrypt"); err != nil || content != "hello curve" {
+		t.Errorf("Expected gpg to decrypt the message with the Curve25519 key, got %q (%v)", content, err)
+	}
+}
+
+func TestReadPublicKeyInvalid(t *testing.T) {
+	_, private := newTestKey(t, true)
+
+	tests := map[string]string{
+		"empty":       "",
+		"garbage":     "not a key",
+		"private key": private,
+		"message":     "-----BEGIN PGP MESSAGE-----\n\n-----END PGP MESSAGE-----",
+	}
+	for name, armored := range tests {
+		if _, err := ReadPublicKey(armored); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s: expected ErrInvalidPublicKey, got %v", name, err)
+		}
+	}
+
+	// Keyrings with more than one key are ambiguous.
+	e1, _ := newTestKey(t, false)
+	e2, _ := newTestKey(t, false)
+	var buf bytes.Buffer
+	w, _ := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	e1.Serialize(w)
+	e2.Serialize(w)
+	w.Close()
+	if _, err := ReadPublicKey(buf.String()); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("expected ErrInvalidPublicKey for two keys, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// Keyring holds the private keys to decrypt messages encrypted to public
+// keys with.
+type Keyring struct {
+	Entities openpgp.EntityList
+	// Passphrase returns the passphrase of an encrypted private key. It is
+	// asked once per key, and only for keys a message is encrypted to.
+	// Without it, encrypted keys can't be used.
+	Passphrase func(key openpgp.Key) ([]byte, error)
+}
+
+// ReadKeyring reads the private keys in data, armored or binary as
+// exported by gpg --export-secret-keys. Public keys in data are ignored.
+func ReadKeyring(data []byte, passphrase func(openpgp.Key) ([]byte, error)) (*Keyring, error) {
+	entities, err := readKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private keys: %w", err)
+	}
+	keyring := &Keyring{Passphrase: passphrase}
+	for _, e := range entities {
+		if e.PrivateKey != nil {
+			keyring.Entities = append(keyring.Entities, e)
+		}
+	}
+	if len(keyring.Entities) == 0 {
+		return nil, errors.New("no private keys found")
+	}
+	return keyring, nil
+}
+
+// ReadPublicKey reads a single OpenPGP public key, armored or binary as
+// exported by gpg --export, that can encrypt messages. Private keys are
+// rejected.
+func ReadPublicKey(key string) (*openpgp.Entity, error) {
+	entities, err := readKeys([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("%w: expected a single key, got %d", ErrInvalidPublicKey, len(entities))
+	}
+	e := entities[0]
+	if e.PrivateKey != nil {
+		return nil, fmt.Errorf("%w: expected a public key, got a private one", ErrInvalidPublicKey)
+	}
+	if _, ok := e.EncryptionKey(time.Now()); !ok {
+		return nil, fmt.Errorf("%w: key %X can't encrypt, it may have expired or been revoked", ErrInvalidPublicKey, e.PrimaryKey.Fingerprint)
+	}
+	return e, nil
+}
+
+// ArmorPublicKey returns the armored public key of e.
+func ArmorPublicKey(e *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := e.Serialize(w); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// readKeys reads the keys in data, which is either an armored public or
+// private key block or a binary keyring.
+func readKeys(data []byte) (openpgp.EntityList, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != openpgp.PublicKeyType && block.Type != openpgp.PrivateKeyType {
+			return nil, fmt.Errorf("expected a key block, got %s", block.Type)
+		}
+		r = block.Body
+	}
+	return openpgp.ReadKeyRing(r)
+}
@@ -0,0 +1,10 @@
+package domain
+
+import "context"
+
+// Auditor records the lifecycle of every secret for the audit log
+type Auditor interface {
+	// Record logs event on behalf of the client the request carried by ctx
+	// comes from. Secrets are recorded as expired by the auditor itself.
+	Record(ctx context.Context, event Event)
+}
@@ -0,0 +1,76 @@
+package domain
+
+import "time"
+
+// Types of events sent to the notification URL of a secret, and recorded
+// in the audit log
+const (
+	EventViewed  = "secret.viewed"
+	EventDeleted = "secret.deleted"
+	EventExpired = "secret.expired"
+)
+
+// Types of events only recorded in the audit log
+const (
+	EventCreated  = "secret.created"
+	EventExtended = "secret.extended"
+	// EventFulfilled is recorded for a request replaced by the secret
+	// fulfilling it
+	EventFulfilled = "request.fulfilled"
+)
+
+// Event tells the creator of a secret, or the audit log, what happened to
+// it. It describes the secret without its message, so an event never
+// carries the ciphertext.
+type Event struct {
+	Type string    `json:"event"`
+	Key  string    `json:"key"`
+	Kind string    `json:"kind,omitempty"`
+	Time time.Time `json:"time"`
+	// MaxViews and RemainingViews are one and zero or one for one-time
+	// secrets, and left out for secrets without a view limit.
+	MaxViews       int       `json:"max_views,omitempty"`
+	RemainingViews int       `json:"remaining_views"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+}
+
+// Consumed reports whether the event is about the last view of a secret
+// with a view limit.
+func (e Event) Consumed() bool {
+	return e.Type == EventViewed && e.MaxViews > 0 && e.RemainingViews == 0
+}
+
+// NewEvent returns an event of type typ about the secret described by meta
+// stored under key.
+func NewEvent(typ, key string, meta Metadata, at time.Time) Event {
+	e := Event{
+		Type:           typ,
+		Key:            key,
+		Kind:           meta.Kind,
+		Time:           at.UTC().Truncate(time.Second),
+		MaxViews:       meta.MaxViews,
+		RemainingViews: meta.RemainingViews,
+		ExpiresAt:      meta.ExpiresAt,
+	}
+	if meta.OneTime {
+		e.MaxViews, e.RemainingViews = 1, 1
+		if meta.Consumed() {
+			e.RemainingViews = 0
+		}
+	}
+	return e
+}
+
+// Notifier delivers the events of secrets created with a notification URL
+type Notifier interface {
+	// Validate returns an error unless url may receive notifications
+	Validate(url string) error
+	// Notify queues event for delivery to url
+	Notify(url string, event Event)
+	// Watch queues event for delivery to url at event.Time, unless Unwatch
+	// is called for event.Key before. Watching a key again replaces the
+	// event.
+	Watch(url string, event Event)
+	// Unwatch drops the event watched for key
+	Unwatch(key string)
+}
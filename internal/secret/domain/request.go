@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// Request asks for a secret to be sent to whoever created it. It is stored
+// as a secret of kind KindRequest holding the public key, and replaced by
+// the secret fulfilling it once it is used.
+type Request struct {
+	Expiration int32 `json:"expiration,omitempty"`
+	// PublicKey is the armored OpenPGP public key the secret has to be
+	// encrypted to. Without one, the secret is encrypted with the key in
+	// the fragment of the request link.
+	PublicKey string `json:"public_key,omitempty"`
+	// ExpiresAt is set by the server when the request is looked up
+	ExpiresAt time.Time `json:"-"`
+}
@@ -1,15 +1,41 @@
 package domain
 
-import "encoding/json"
+import (
+	"time"
 
-// Secret holds the encrypted message
-type Secret struct {
-	Expiration int32  `json:"expiration,omitempty"`
-	Message    string `json:"message"`
-	OneTime    bool   `json:"one_time,omitempty"`
-}
+	"github.com/Khovanskiy5/yopass/pkg/secret"
+)
+
+// The stored secrets and the repository holding them are public, so
+// database drivers can live outside this module. They are aliased here for
+// the rest of the server.
+
+// Kinds of secrets, telling text messages from uploaded files and requests
+// for a secret
+const (
+	KindMessage = secret.KindMessage
+	KindFile    = secret.KindFile
+	KindRequest = secret.KindRequest
+)
+
+type (
+	// Secret holds the encrypted message
+	Secret = secret.Secret
+	// Metadata describes a stored secret without its encrypted message
+	Metadata = secret.Metadata
+	// Repository interface for secret storage
+	Repository = secret.Repository
+)
+
+var (
+	// ErrNotFound is returned when a secret is not found in the repository
+	ErrNotFound = secret.ErrNotFound
+	// ErrUnavailable is returned when the repository refuses requests
+	// because its backend is known to be unhealthy
+	ErrUnavailable = secret.ErrUnavailable
+)
 
-// ToJSON converts a Secret to json
-func (s *Secret) ToJSON() ([]byte, error) {
-	return json.Marshal(&s)
+// NewMetadata returns the metadata of s stored at now
+func NewMetadata(s Secret, now time.Time) Metadata {
+	return secret.NewMetadata(s, now)
 }
@@ -30,6 +30,7 @@ func (h *ConfigHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
 		"DISABLE_FEATURES":      h.cfg.DisableFeatures,
 		"NO_LANGUAGE_SWITCHER":  h.cfg.NoLanguageSwitcher,
 		"FORCE_ONETIME_SECRETS": h.cfg.ForceOneTimeSecrets,
+		"MAX_VIEWS":             h.cfg.MaxViews,
 	}
 
 	if h.cfg.PrivacyNoticeURL != "" {
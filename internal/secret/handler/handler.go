@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/Khovanskiy5/yopass/internal/secret/service"
@@ -13,41 +17,84 @@ import (
 type SecretHandler struct {
 	service service.SecretService
 	logger  *zap.Logger
+	now     func() time.Time
 }
 
 func NewSecretHandler(service service.SecretService, logger *zap.Logger) *SecretHandler {
 	return &SecretHandler{
 		service: service,
 		logger:  logger,
+		now:     time.Now,
 	}
 }
 
+// statusResponse describes a secret without revealing it. Fields that are
+// unknown for secrets stored by earlier versions are left out.
+type statusResponse struct {
+	OneTime bool `json:"oneTime"`
+	// MaxViews and RemainingViews are left out for secrets without a view
+	// limit.
+	MaxViews       int       `json:"maxViews,omitempty"`
+	RemainingViews int       `json:"remainingViews,omitempty"`
+	Size           int       `json:"size,omitempty"`
+	CreatedAt      time.Time `json:"createdAt,omitzero"`
+	ExpiresAt      time.Time `json:"expiresAt,omitzero"`
+	// TTL is the remaining lifetime in seconds.
+	TTL *int64 `json:"ttl,omitempty"`
+}
+
+// manageResponse describes a secret to its creator, who also gets to know
+// whether and when it was viewed first, and where that is notified.
+type manageResponse struct {
+	statusResponse
+	Viewed    bool      `json:"viewed"`
+	ViewedAt  time.Time `json:"viewedAt,omitzero"`
+	NotifyURL string    `json:"notifyUrl,omitempty"`
+}
+
+// extendRequest is the body of a request to extend a secret.
+type extendRequest struct {
+	// Expiration is the new lifetime in seconds counted from now.
+	Expiration int32 `json:"expiration"`
+}
+
 func (h *SecretHandler) CreateSecret(w http.ResponseWriter, r *http.Request) {
 	var secret domain.Secret
 	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
 		h.sendError(w, "Unable to parse json", http.StatusBadRequest)
 		return
 	}
+	secret.Kind = domain.KindMessage
+	if strings.HasPrefix(r.URL.Path, "/file") {
+		secret.Kind = domain.KindFile
+	}
 
-	key, err := h.service.CreateSecret(secret)
+	key, token, err := h.service.CreateSecret(r.Context(), secret)
 	if err != nil {
-		code := http.StatusBadRequest
-		if err.Error() == "failed to store secret in database" {
-			code = http.StatusInternalServerError
+		if h.sendBackendError(w, err) {
+			return
+		}
+		if errors.Is(err, service.ErrStoreFailed) {
+			h.logger.Error("Failed to store secret", zap.Error(err))
+			h.sendError(w, service.ErrStoreFailed.Error(), http.StatusInternalServerError)
+			return
 		}
-		h.sendError(w, err.Error(), code)
+		h.sendError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	h.sendJSON(w, map[string]string{"message": key}, http.StatusOK)
+	h.sendJSON(w, map[string]string{"message": key, "token": token}, http.StatusOK)
 }
 
 func (h *SecretHandler) GetSecret(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "private, no-cache")
 	key := mux.Vars(r)["key"]
 
-	secret, err := h.service.GetSecret(key)
+	secret, err := h.service.GetSecret(r.Context(), key)
 	if err != nil {
+		if h.sendBackendError(w, err) {
+			return
+		}
 		h.sendError(w, "Secret not found", http.StatusNotFound)
 		return
 	}
@@ -69,37 +116,150 @@ func (h *SecretHandler) GetSecretStatus(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Cache-Control", "private, no-cache")
 	key := mux.Vars(r)["key"]
 
-	oneTime, err := h.service.GetSecretStatus(key)
+	meta, err := h.service.GetSecretStatus(r.Context(), key)
 	if err != nil {
+		if h.sendBackendError(w, err) {
+			return
+		}
 		h.sendError(w, "Secret not found", http.StatusNotFound)
 		return
 	}
 
-	h.sendJSON(w, map[string]bool{"oneTime": oneTime}, http.StatusOK)
+	h.sendJSON(w, h.statusResponse(meta), http.StatusOK)
 }
 
-func (h *SecretHandler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
-	key := mux.Vars(r)["key"]
-	deleted, err := h.service.DeleteSecret(key)
+func (h *SecretHandler) statusResponse(meta domain.Metadata) statusResponse {
+	resp := statusResponse{
+		OneTime:        meta.OneTime,
+		MaxViews:       meta.MaxViews,
+		RemainingViews: meta.RemainingViews,
+		Size:           meta.Size,
+		CreatedAt:      meta.CreatedAt,
+		ExpiresAt:      meta.ExpiresAt,
+	}
+	if meta.OneTime {
+		resp.MaxViews, resp.RemainingViews = 1, 1
+		if meta.Consumed() {
+			resp.RemainingViews = 0
+		}
+	}
+	if !meta.ExpiresAt.IsZero() {
+		ttl := max(int64(meta.ExpiresAt.Sub(h.now())/time.Second), 0)
+		resp.TTL = &ttl
+	}
+	return resp
+}
+
+// GetManagedSecret describes a secret to the holder of its management
+// token.
+func (h *SecretHandler) GetManagedSecret(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	token, ok := h.bearerToken(w, r)
+	if !ok {
+		return
+	}
+	meta, err := h.service.GetManagedSecret(r.Context(), mux.Vars(r)["key"], token)
 	if err != nil {
-		h.sendError(w, "Failed to delete secret", http.StatusInternalServerError)
+		h.sendManageError(w, err)
 		return
 	}
+	h.sendJSON(w, h.manageResponse(meta), http.StatusOK)
+}
 
-	if !deleted {
-		h.sendError(w, "Secret not found", http.StatusNotFound)
+// ExtendManagedSecret changes the expiration of a secret for the holder of
+// its management token.
+func (h *SecretHandler) ExtendManagedSecret(w http.ResponseWriter, r *http.Request) {
+	token, ok := h.bearerToken(w, r)
+	if !ok {
+		return
+	}
+	var req extendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Unable to parse json", http.StatusBadRequest)
+		return
+	}
+	meta, err := h.service.ExtendManagedSecret(r.Context(), mux.Vars(r)["key"], token, req.Expiration)
+	if err != nil {
+		h.sendManageError(w, err)
 		return
 	}
+	h.sendJSON(w, h.manageResponse(meta), http.StatusOK)
+}
 
+// DeleteManagedSecret deletes a secret for the holder of its management
+// token.
+func (h *SecretHandler) DeleteManagedSecret(w http.ResponseWriter, r *http.Request) {
+	token, ok := h.bearerToken(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.DeleteManagedSecret(r.Context(), mux.Vars(r)["key"], token); err != nil {
+		h.sendManageError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (h *SecretHandler) manageResponse(meta domain.Metadata) manageResponse {
+	return manageResponse{
+		statusResponse: h.statusResponse(meta),
+		Viewed:         !meta.ViewedAt.IsZero(),
+		ViewedAt:       meta.ViewedAt,
+		NotifyURL:      meta.NotifyURL,
+	}
+}
+
+// bearerToken returns the management token sent in the Authorization
+// header. If there is none, it responds with 401 and reports false.
+func (h *SecretHandler) bearerToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.sendError(w, "Management token required", http.StatusUnauthorized)
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func (h *SecretHandler) sendManageError(w http.ResponseWriter, err error) {
+	switch {
+	case h.sendBackendError(w, err):
+	case errors.Is(err, domain.ErrNotFound):
+		h.sendError(w, "Secret not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidToken):
+		h.sendError(w, "Invalid management token", http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidExpiration):
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+	default:
+		h.logger.Error("Failed to manage secret", zap.Error(err))
+		h.sendError(w, "Failed to manage secret", http.StatusInternalServerError)
+	}
+}
+
 func (h *SecretHandler) OptionsSecret(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "content-type")
+	w.Header().Set("Access-Control-Allow-Headers", "content-type, authorization")
 	w.WriteHeader(http.StatusOK)
 }
 
+// sendBackendError responds to errors caused by the request context ending
+// before the database answered or by the database being unavailable. It
+// reports whether a response was sent.
+func (h *SecretHandler) sendBackendError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		h.logger.Warn("Database operation timed out", zap.Error(err))
+		h.sendError(w, "Database timeout", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		h.sendError(w, "Request canceled", http.StatusServiceUnavailable)
+	case errors.Is(err, domain.ErrUnavailable):
+		h.sendError(w, "Database unavailable", http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *SecretHandler) sendError(w http.ResponseWriter, msg string, code int) {
 	h.logger.Debug("Sending error response", zap.String("message", msg), zap.Int("code", code))
 	h.sendJSON(w, map[string]string{"message": msg}, code)
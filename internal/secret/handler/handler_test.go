@@ -2,43 +2,90 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/secret/service"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap/zaptest"
 )
 
 type mockService struct {
+	created   domain.Secret
 	createKey string
 	createErr error
 	getSecret domain.Secret
 	getErr    error
-	status    bool
+	status    domain.Metadata
 	statusErr error
-	deleteRes bool
-	deleteErr error
+	token     string
+	manageErr error
+	extended  int32
+
+	request    domain.Request
+	requestErr error
+	fulfilled  domain.Secret
 }
 
-func (m *mockService) CreateSecret(secret domain.Secret) (string, error) {
-	return m.createKey, m.createErr
+func (m *mockService) CreateSecret(ctx context.Context, secret domain.Secret) (string, string, error) {
+	m.created = secret
+	return m.createKey, m.token, m.createErr
 }
-func (m *mockService) GetSecret(key string) (domain.Secret, error) {
+func (m *mockService) GetSecret(ctx context.Context, key string) (domain.Secret, error) {
 	return m.getSecret, m.getErr
 }
-func (m *mockService) GetSecretStatus(key string) (bool, error) {
+func (m *mockService) GetSecretStatus(ctx context.Context, key string) (domain.Metadata, error) {
 	return m.status, m.statusErr
 }
-func (m *mockService) DeleteSecret(key string) (bool, error) {
-	return m.deleteRes, m.deleteErr
+func (m *mockService) GetManagedSecret(ctx context.Context, key, token string) (domain.Metadata, error) {
+	if token != m.token {
+		return domain.Metadata{}, service.ErrInvalidToken
+	}
+	return m.status, m.manageErr
+}
+func (m *mockService) DeleteManagedSecret(ctx context.Context, key, token string) error {
+	if token != m.token {
+		return service.ErrInvalidToken
+	}
+	return m.manageErr
+}
+func (m *mockService) ExtendManagedSecret(ctx context.Context, key, token string, expiration int32) (domain.Metadata, error) {
+	if token != m.token {
+		return domain.Metadata{}, service.ErrInvalidToken
+	}
+	m.extended = expiration
+	return m.status, m.manageErr
+}
+
+func (m *mockService) CreateRequest(ctx context.Context, request domain.Request) (string, string, error) {
+	m.request = request
+	return m.createKey, m.token, m.createErr
+}
+func (m *mockService) GetRequest(ctx context.Context, key string) (domain.Request, error) {
+	return m.request, m.requestErr
+}
+func (m *mockService) FulfilRequest(ctx context.Context, key string, secret domain.Secret) error {
+	m.fulfilled = secret
+	return m.requestErr
+}
+func (m *mockService) GetRequestedSecret(ctx context.Context, key, token string) (domain.Secret, error) {
+	if token != m.token {
+		return domain.Secret{}, service.ErrInvalidToken
+	}
+	return m.getSecret, m.requestErr
 }
 
 func TestSecretHandler_CreateSecret(t *testing.T) {
-	svc := &mockService{createKey: "test-key"}
+	svc := &mockService{createKey: "test-key", token: "test-token"}
 	h := NewSecretHandler(svc, zaptest.NewLogger(t))
 
 	secret := domain.Secret{Message: "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----", Expiration: 3600}
@@ -57,6 +104,24 @@ func TestSecretHandler_CreateSecret(t *testing.T) {
 	if resp["message"] != "test-key" {
 		t.Errorf("expected message test-key, got %s", resp["message"])
 	}
+	if resp["token"] != "test-token" {
+		t.Errorf("expected token test-token, got %s", resp["token"])
+	}
+}
+
+func TestSecretHandler_CreateSecretKind(t *testing.T) {
+	for path, want := range map[string]string{"/secret": domain.KindMessage, "/file": domain.KindFile} {
+		svc := &mockService{createKey: "test-key"}
+		h := NewSecretHandler(svc, zaptest.NewLogger(t))
+
+		body := `{"message": "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----", "kind": "other"}`
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		h.CreateSecret(httptest.NewRecorder(), req)
+
+		if svc.created.Kind != want {
+			t.Errorf("expected kind %s for %s, got %s", want, path, svc.created.Kind)
+		}
+	}
 }
 
 func TestSecretHandler_GetSecret(t *testing.T) {
@@ -80,6 +145,271 @@ func TestSecretHandler_GetSecret(t *testing.T) {
 	}
 }
 
+func TestSecretHandler_GetSecretStatus(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc := &mockService{status: domain.Metadata{
+		OneTime:   true,
+		Size:      1234,
+		CreatedAt: created,
+		ExpiresAt: created.Add(time.Hour),
+	}}
+	h := NewSecretHandler(svc, zaptest.NewLogger(t))
+	h.now = func() time.Time { return created.Add(15 * time.Minute) }
+
+	req := httptest.NewRequest(http.MethodGet, "/secret/test-key/status", nil)
+	req = mux.SetURLVars(req, map[string]string{"key": "test-key"})
+	w := httptest.NewRecorder()
+	h.GetSecretStatus(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	want := `{"oneTime":true,"maxViews":1,"remainingViews":1,"size":1234,"createdAt":"2024-05-01T12:00:00Z","expiresAt":"2024-05-01T13:00:00Z","ttl":2700}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	svc.status = domain.Metadata{MaxViews: 5, RemainingViews: 2, Size: 10}
+	w = httptest.NewRecorder()
+	h.GetSecretStatus(w, req)
+	if got := strings.TrimSpace(w.Body.String()); got != `{"oneTime":false,"maxViews":5,"remainingViews":2,"size":10}` {
+		t.Errorf("expected remaining views of multi-view secret, got %s", got)
+	}
+
+	// Secrets stored by earlier versions may only know whether they are
+	// one-time.
+	svc.status = domain.Metadata{}
+	w = httptest.NewRecorder()
+	h.GetSecretStatus(w, req)
+	if got := strings.TrimSpace(w.Body.String()); got != `{"oneTime":false}` {
+		t.Errorf("expected only oneTime, got %s", got)
+	}
+}
+
+func TestSecretHandler_ManagedSecret(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc := &mockService{token: "test-token", status: domain.Metadata{
+		OneTime:   true,
+		Size:      1234,
+		CreatedAt: created,
+		ExpiresAt: created.Add(time.Hour),
+		ViewedAt:  created.Add(10 * time.Minute),
+		NotifyURL: "https://hooks.example.com/yopass",
+	}}
+	h := NewSecretHandler(svc, zaptest.NewLogger(t))
+	h.now = func() time.Time { return created.Add(15 * time.Minute) }
+
+	request := func(method, token, body string) *http.Request {
+		req := httptest.NewRequest(method, "/secret/test-key/manage", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"key": "test-key"})
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	h.GetManagedSecret(w, request(http.MethodGet, "test-token", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	want := `{"oneTime":true,"maxViews":1,"size":1234,"createdAt":"2024-05-01T12:00:00Z","expiresAt":"2024-05-01T13:00:00Z","ttl":2700,"viewed":true,"viewedAt":"2024-05-01T12:10:00Z","notifyUrl":"https://hooks.example.com/yopass"}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	w = httptest.NewRecorder()
+	h.GetManagedSecret(w, request(http.MethodGet, "", ""))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("expected status 401 without token, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.GetManagedSecret(w, request(http.MethodGet, "wrong", ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for a wrong token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ExtendManagedSecret(w, request(http.MethodPatch, "test-token", `{"expiration": 86400}`))
+	if w.Code != http.StatusOK || svc.extended != 86400 {
+		t.Errorf("expected secret to be extended by 86400, got %d, %d", w.Code, svc.extended)
+	}
+	svc.manageErr = fmt.Errorf("%w: the secret already expires later", service.ErrInvalidExpiration)
+	w = httptest.NewRecorder()
+	h.ExtendManagedSecret(w, request(http.MethodPatch, "test-token", `{"expiration": 60}`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid expiration, got %d", w.Code)
+	}
+
+	svc.manageErr = nil
+	w = httptest.NewRecorder()
+	h.DeleteManagedSecret(w, request(http.MethodDelete, "test-token", ""))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	svc.manageErr = domain.ErrNotFound
+	w = httptest.NewRecorder()
+	h.DeleteManagedSecret(w, request(http.MethodDelete, "test-token", ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing secret, got %d", w.Code)
+	}
+	svc.manageErr = context.DeadlineExceeded
+	w = httptest.NewRecorder()
+	h.DeleteManagedSecret(w, request(http.MethodDelete, "test-token", ""))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 for a timeout, got %d", w.Code)
+	}
+}
+
+func TestSecretHandler_Request(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc := &mockService{createKey: "test-key", token: "test-token"}
+	h := NewSecretHandler(svc, zaptest.NewLogger(t))
+	h.now = func() time.Time { return created.Add(15 * time.Minute) }
+
+	request := func(method, token, body string) *http.Request {
+		req := httptest.NewRequest(method, "/request/test-key", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"key": "test-key"})
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	h.CreateRequest(w, request(http.MethodPost, "", `{"expiration": 3600, "public_key": "key"}`))
+	if w.Code != http.StatusOK || svc.request.Expiration != 3600 || svc.request.PublicKey != "key" {
+		t.Fatalf("expected request to be created, got %d, %+v", w.Code, svc.request)
+	}
+	if got, want := strings.TrimSpace(w.Body.String()), `{"message":"test-key","token":"test-token"}`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	svc.createErr = fmt.Errorf("%w: not a key", crypto.ErrInvalidPublicKey)
+	w = httptest.NewRecorder()
+	h.CreateRequest(w, request(http.MethodPost, "", `{"expiration": 3600, "public_key": "key"}`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid public key, got %d", w.Code)
+	}
+
+	svc.request = domain.Request{PublicKey: "key", ExpiresAt: created.Add(time.Hour)}
+	w = httptest.NewRecorder()
+	h.GetRequest(w, request(http.MethodGet, "", ""))
+	want := `{"publicKey":"key","expiresAt":"2024-05-01T13:00:00Z","ttl":2700}`
+	if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != want {
+		t.Errorf("expected %s, got %d: %s", want, w.Code, got)
+	}
+
+	w = httptest.NewRecorder()
+	h.FulfilRequest(w, request(http.MethodPost, "", `{"message": "encrypted"}`))
+	if w.Code != http.StatusNoContent || svc.fulfilled.Message != "encrypted" {
+		t.Errorf("expected request to be fulfilled, got %d, %+v", w.Code, svc.fulfilled)
+	}
+	svc.requestErr = domain.ErrNotFound
+	w = httptest.NewRecorder()
+	h.FulfilRequest(w, request(http.MethodPost, "", `{"message": "encrypted"}`))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing request, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.GetRequestedSecret(w, request(http.MethodGet, "", ""))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.GetRequestedSecret(w, request(http.MethodGet, "wrong", ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for a wrong token, got %d", w.Code)
+	}
+	svc.requestErr = service.ErrRequestPending
+	w = httptest.NewRecorder()
+	h.GetRequestedSecret(w, request(http.MethodGet, "test-token", ""))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "Request not fulfilled yet") {
+		t.Errorf("expected status 404 for a pending request, got %d: %s", w.Code, w.Body.String())
+	}
+	svc.requestErr, svc.getSecret = nil, domain.Secret{Message: "encrypted"}
+	w = httptest.NewRecorder()
+	h.GetRequestedSecret(w, request(http.MethodGet, "test-token", ""))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"message":"encrypted"`) {
+		t.Errorf("expected the requested secret, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSecretHandler_BackendErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *mockService
+		call func(h *SecretHandler, w http.ResponseWriter, r *http.Request)
+		want int
+	}{
+		{
+			name: "Create store failure",
+			svc:  &mockService{createErr: fmt.Errorf("%w: %w", service.ErrStoreFailed, fmt.Errorf("connection refused"))},
+			call: (*SecretHandler).CreateSecret,
+			want: http.StatusInternalServerError,
+		},
+		{
+			name: "Create timeout",
+			svc:  &mockService{createErr: fmt.Errorf("%w: %w", service.ErrStoreFailed, context.DeadlineExceeded)},
+			call: (*SecretHandler).CreateSecret,
+			want: http.StatusGatewayTimeout,
+		},
+		{
+			name: "Get timeout",
+			svc:  &mockService{getErr: context.DeadlineExceeded},
+			call: (*SecretHandler).GetSecret,
+			want: http.StatusGatewayTimeout,
+		},
+		{
+			name: "Get not found",
+			svc:  &mockService{getErr: domain.ErrNotFound},
+			call: (*SecretHandler).GetSecret,
+			want: http.StatusNotFound,
+		},
+		{
+			name: "Status canceled",
+			svc:  &mockService{statusErr: context.Canceled},
+			call: (*SecretHandler).GetSecretStatus,
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "Delete timeout",
+			svc:  &mockService{token: "token", manageErr: context.DeadlineExceeded},
+			call: (*SecretHandler).DeleteManagedSecret,
+			want: http.StatusGatewayTimeout,
+		},
+		{
+			name: "Create unavailable",
+			svc:  &mockService{createErr: fmt.Errorf("%w: %w", service.ErrStoreFailed, domain.ErrUnavailable)},
+			call: (*SecretHandler).CreateSecret,
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "Get unavailable",
+			svc:  &mockService{getErr: domain.ErrUnavailable},
+			call: (*SecretHandler).GetSecret,
+			want: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewSecretHandler(tt.svc, zaptest.NewLogger(t))
+			body, _ := json.Marshal(domain.Secret{Message: "encrypted", Expiration: 3600})
+			req := httptest.NewRequest(http.MethodPost, "/secret", bytes.NewReader(body))
+			req = mux.SetURLVars(req, map[string]string{"key": "test-key"})
+			req.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+
+			tt.call(h, w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
 func TestConfigHandler_GetConfig(t *testing.T) {
 	cfg := &config.Config{DisableUpload: true}
 	h := NewConfigHandler(cfg, zaptest.NewLogger(t))
@@ -99,3 +429,40 @@ func TestConfigHandler_GetConfig(t *testing.T) {
 		t.Errorf("expected DISABLE_UPLOAD true, got %v", resp["DISABLE_UPLOAD"])
 	}
 }
+
+type mockPinger struct {
+	err error
+}
+
+func (m *mockPinger) Ping(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return fmt.Errorf("expected readiness check to have a deadline")
+	}
+	return m.err
+}
+
+func TestHealthHandler(t *testing.T) {
+	pinger := &mockPinger{}
+	h := NewHealthHandler(pinger, zaptest.NewLogger(t))
+
+	check := func(handler http.HandlerFunc, wantCode int, wantStatus string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != wantCode {
+			t.Errorf("expected status %d, got %d", wantCode, w.Code)
+		}
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["status"] != wantStatus {
+			t.Errorf("expected status %q, got %q", wantStatus, resp["status"])
+		}
+	}
+
+	check(h.Live, http.StatusOK, "ok")
+	check(h.Ready, http.StatusOK, "ok")
+
+	pinger.err = fmt.Errorf("connection refused")
+	check(h.Live, http.StatusOK, "ok")
+	check(h.Ready, http.StatusServiceUnavailable, "unavailable")
+}
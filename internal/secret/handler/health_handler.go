@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// readinessTimeout bounds the backend check of a single readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// Pinger checks whether a dependency of the server is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	pinger Pinger
+	logger *zap.Logger
+}
+
+func NewHealthHandler(pinger Pinger, logger *zap.Logger) *HealthHandler {
+	return &HealthHandler{
+		pinger: pinger,
+		logger: logger,
+	}
+}
+
+// Live reports that the process is up and serving requests.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	h.sendStatus(w, "ok", http.StatusOK)
+}
+
+// Ready reports whether the server can handle secrets, i.e. whether the
+// database is reachable.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := h.pinger.Ping(ctx); err != nil {
+		h.logger.Warn("Readiness check failed", zap.Error(err))
+		h.sendStatus(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	h.sendStatus(w, "ok", http.StatusOK)
+}
+
+func (h *HealthHandler) sendStatus(w http.ResponseWriter, status string, code int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": status}); err != nil {
+		h.logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/secret/service"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// requestResponse describes a request for a secret to whoever is asked to
+// send it.
+type requestResponse struct {
+	// PublicKey is the key to encrypt the secret to. Without one, the
+	// secret is encrypted with the key from the request link.
+	PublicKey string    `json:"publicKey,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+	// TTL is the remaining lifetime in seconds.
+	TTL *int64 `json:"ttl,omitempty"`
+}
+
+// CreateRequest stores a request for a secret and returns its key along
+// with the token to fetch the secret with.
+func (h *SecretHandler) CreateRequest(w http.ResponseWriter, r *http.Request) {
+	var request domain.Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.sendError(w, "Unable to parse json", http.StatusBadRequest)
+		return
+	}
+
+	key, token, err := h.service.CreateRequest(r.Context(), request)
+	if err != nil {
+		if h.sendBackendError(w, err) {
+			return
+		}
+		if errors.Is(err, service.ErrStoreFailed) {
+			h.logger.Error("Failed to store request", zap.Error(err))
+			h.sendError(w, service.ErrStoreFailed.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.sendJSON(w, map[string]string{"message": key, "token": token}, http.StatusOK)
+}
+
+// GetRequest tells how to encrypt the secret asked for by a request.
+func (h *SecretHandler) GetRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	request, err := h.service.GetRequest(r.Context(), mux.Vars(r)["key"])
+	if err != nil {
+		h.sendRequestError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := requestResponse{PublicKey: request.PublicKey, ExpiresAt: request.ExpiresAt}
+	if !request.ExpiresAt.IsZero() {
+		ttl := max(int64(request.ExpiresAt.Sub(h.now())/time.Second), 0)
+		resp.TTL = &ttl
+	}
+	h.sendJSON(w, resp, http.StatusOK)
+}
+
+// FulfilRequest stores the secret asked for by a request.
+func (h *SecretHandler) FulfilRequest(w http.ResponseWriter, r *http.Request) {
+	var secret domain.Secret
+	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+		h.sendError(w, "Unable to parse json", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.FulfilRequest(r.Context(), mux.Vars(r)["key"], secret); err != nil {
+		h.sendRequestError(w, err, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRequestedSecret returns the secret fulfilling a request to the holder
+// of the request token.
+func (h *SecretHandler) GetRequestedSecret(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	token, ok := h.bearerToken(w, r)
+	if !ok {
+		return
+	}
+	secret, err := h.service.GetRequestedSecret(r.Context(), mux.Vars(r)["key"], token)
+	if err != nil {
+		h.sendRequestError(w, err, http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, secret, http.StatusOK)
+}
+
+// sendRequestError responds to err with code unless it is known. Errors
+// are only sent as is for code 400, i.e. to invalid requests.
+func (h *SecretHandler) sendRequestError(w http.ResponseWriter, err error, code int) {
+	switch {
+	case h.sendBackendError(w, err):
+	case errors.Is(err, domain.ErrNotFound):
+		h.sendError(w, "Request not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrRequestPending):
+		h.sendError(w, "Request not fulfilled yet", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidToken):
+		h.sendError(w, "Invalid request token", http.StatusForbidden)
+	case errors.Is(err, service.ErrStoreFailed):
+		h.logger.Error("Failed to store requested secret", zap.Error(err))
+		h.sendError(w, service.ErrStoreFailed.Error(), http.StatusInternalServerError)
+	case code == http.StatusBadRequest:
+		h.sendError(w, err.Error(), code)
+	default:
+		h.logger.Error("Failed to get request", zap.Error(err))
+		h.sendError(w, "Failed to get request", code)
+	}
+}
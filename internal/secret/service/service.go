@@ -1,85 +1,472 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/Khovanskiy5/yopass/internal/secret/armor"
+	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
 	"github.com/gofrs/uuid"
 )
 
+// ErrStoreFailed is returned by CreateSecret when the repository could not
+// store the secret. The underlying error stays available to errors.Is.
+var ErrStoreFailed = errors.New("failed to store secret in database")
+
+// ErrInvalidToken is returned when a management token doesn't match the
+// secret, or the secret was stored without one.
+var ErrInvalidToken = errors.New("invalid management token")
+
+// ErrInvalidExpiration is returned for expirations not allowed by the
+// server, be it for a new secret or for extending one.
+var ErrInvalidExpiration = errors.New("invalid expiration specified")
+
+// ErrRequestPending is returned to the requester of a secret that hasn't
+// been sent yet.
+var ErrRequestPending = errors.New("request not fulfilled yet")
+
 type SecretService interface {
-	CreateSecret(secret domain.Secret) (string, error)
-	GetSecret(key string) (domain.Secret, error)
-	GetSecretStatus(key string) (bool, error)
-	DeleteSecret(key string) (bool, error)
+	// CreateSecret stores secret under a new key. It also returns the
+	// management token that authorizes its creator to manage the secret.
+	CreateSecret(ctx context.Context, secret domain.Secret) (key, token string, err error)
+	GetSecret(ctx context.Context, key string) (domain.Secret, error)
+	GetSecretStatus(ctx context.Context, key string) (domain.Metadata, error)
+	// GetManagedSecret returns the metadata of a secret, including when it
+	// was viewed first, even after its last view.
+	GetManagedSecret(ctx context.Context, key, token string) (domain.Metadata, error)
+	// DeleteManagedSecret deletes a secret before it is viewed.
+	DeleteManagedSecret(ctx context.Context, key, token string) error
+	// ExtendManagedSecret lets a secret expire expiration seconds from now
+	// instead, as long as that is later and the secret doesn't outlive the
+	// longest allowed expiration.
+	ExtendManagedSecret(ctx context.Context, key, token string, expiration int32) (domain.Metadata, error)
+	// CreateRequest stores a request for a secret under a new key. It also
+	// returns the token that lets the requester fetch the secret, and
+	// manage the request like a secret.
+	CreateRequest(ctx context.Context, request domain.Request) (key, token string, err error)
+	// GetRequest returns the request stored under key, as long as it
+	// hasn't been fulfilled.
+	GetRequest(ctx context.Context, key string) (domain.Request, error)
+	// FulfilRequest replaces the request stored under key by secret. Every
+	// request can be fulfilled once.
+	FulfilRequest(ctx context.Context, key string, secret domain.Secret) error
+	// GetRequestedSecret returns the secret fulfilling the request stored
+	// under key to the holder of its token, once.
+	GetRequestedSecret(ctx context.Context, key, token string) (domain.Secret, error)
+}
+
+// Options configures a SecretService.
+type Options struct {
+	// MaxLength is the maximum size of a secret, or of the public key of a
+	// request, in bytes.
+	MaxLength int
+	// ForceOneTimeSecrets rejects secrets that can be read more than once.
+	ForceOneTimeSecrets bool
+	// AllowedExpirations lists the lifetimes in seconds secrets may have.
+	AllowedExpirations []int32
+	// BinaryPayloads accepts binary OpenPGP messages besides armored ones.
+	BinaryPayloads bool
+	// MaxViews is the highest view limit of a multi-view secret.
+	MaxViews int
+	// Notifier sends the webhooks of secrets with a notification URL.
+	// Without one such secrets are rejected.
+	Notifier domain.Notifier
+	// Auditor records every operation on a secret, if set.
+	Auditor domain.Auditor
 }
 
 type secretService struct {
-	repo                domain.Repository
-	maxLength           int
-	forceOneTimeSecrets bool
-	allowedExpirations  []int32
+	repo domain.Repository
+	opts Options
+	now  func() time.Time
 }
 
-func NewSecretService(
-	repo domain.Repository,
-	maxLength int,
-	forceOneTimeSecrets bool,
-	allowedExpirations []int32,
-) SecretService {
-	return &secretService{
-		repo:                repo,
-		maxLength:           maxLength,
-		forceOneTimeSecrets: forceOneTimeSecrets,
-		allowedExpirations:  allowedExpirations,
-	}
+func NewSecretService(repo domain.Repository, opts Options) SecretService {
+	return &secretService{repo: repo, opts: opts, now: time.Now}
 }
 
-func (s *secretService) CreateSecret(secret domain.Secret) (string, error) {
+func (s *secretService) CreateSecret(ctx context.Context, secret domain.Secret) (string, string, error) {
 	if !s.isPGPEncrypted(secret.Message) {
-		return "", fmt.Errorf("message must be PGP encrypted")
+		return "", "", fmt.Errorf("message must be PGP encrypted")
 	}
 
 	if !s.isValidExpiration(secret.Expiration) {
-		return "", fmt.Errorf("invalid expiration specified")
+		return "", "", ErrInvalidExpiration
+	}
+
+	if secret.MaxViews < 0 || secret.MaxViews > max(s.opts.MaxViews, 1) {
+		return "", "", fmt.Errorf("invalid number of views specified")
+	}
+	if secret.OneTime && secret.MaxViews > 1 {
+		return "", "", fmt.Errorf("one time download can't have more than one view")
+	}
+	// A single view is the same as a one-time secret, which every database
+	// handles without counting views.
+	if secret.MaxViews == 1 {
+		secret.OneTime, secret.MaxViews = true, 0
+	}
+	secret.RemainingViews = 0
+
+	if !secret.OneTime && s.opts.ForceOneTimeSecrets {
+		return "", "", fmt.Errorf("secret must be one time download")
+	}
+
+	if secret.NotifyURL != "" {
+		if s.opts.Notifier == nil {
+			return "", "", fmt.Errorf("notifications are disabled")
+		}
+		if err := s.opts.Notifier.Validate(secret.NotifyURL); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := s.pack(&secret); err != nil {
+		return "", "", err
+	}
+
+	key, token, err := newKey()
+	if err != nil {
+		return "", "", err
+	}
+	secret.TokenHash = hashToken(token)
+
+	meta := domain.NewMetadata(secret, s.now())
+	// The expiry is watched before the secret is stored, so it can't be
+	// viewed before.
+	if secret.NotifyURL != "" && secret.Expiration > 0 {
+		s.opts.Notifier.Watch(secret.NotifyURL, domain.NewEvent(domain.EventExpired, key, meta, meta.ExpiresAt))
+	}
+	if err := s.repo.Put(ctx, key, secret); err != nil {
+		if secret.NotifyURL != "" {
+			s.opts.Notifier.Unwatch(key)
+		}
+		return "", "", fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+	s.record(ctx, domain.NewEvent(domain.EventCreated, key, meta, meta.CreatedAt))
+
+	return key, token, nil
+}
+
+func (s *secretService) GetSecret(ctx context.Context, key string) (domain.Secret, error) {
+	secret, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return secret, err
+	}
+	// Requests can be read any number of times, just not as a secret.
+	if secret.Kind == domain.KindRequest {
+		return domain.Secret{}, domain.ErrNotFound
+	}
+	// Secrets are stored in binary or, if stored before that was enabled or
+	// by a server with --binary-payloads=false, armored form.
+	if secret.Message, err = armor.Expand(secret.Message); err != nil {
+		return domain.Secret{}, fmt.Errorf("failed to restore secret: %w", err)
+	}
+	now := s.now()
+	meta := domain.Metadata{
+		OneTime:        secret.OneTime,
+		MaxViews:       secret.MaxViews,
+		RemainingViews: secret.RemainingViews,
+		Kind:           secret.Kind,
+		ViewedAt:       now,
+	}
+	event := domain.NewEvent(domain.EventViewed, key, meta, now)
+	s.record(ctx, event)
+	if secret.NotifyURL != "" {
+		s.notify(secret.NotifyURL, event)
+		secret.NotifyURL = ""
+	}
+	return secret, nil
+}
+
+// GetSecretStatus hides the receipts of consumed secrets, only their
+// creator may see them.
+func (s *secretService) GetSecretStatus(ctx context.Context, key string) (domain.Metadata, error) {
+	meta, err := s.repo.Status(ctx, key)
+	if err == nil && (meta.Consumed() || meta.Kind == domain.KindRequest) {
+		return domain.Metadata{}, domain.ErrNotFound
+	}
+	return meta, err
+}
+
+func (s *secretService) GetManagedSecret(ctx context.Context, key, token string) (domain.Metadata, error) {
+	return s.authorize(ctx, key, token)
+}
+
+func (s *secretService) DeleteManagedSecret(ctx context.Context, key, token string) error {
+	meta, err := s.authorize(ctx, key, token)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.repo.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return domain.ErrNotFound
+	}
+	s.deleted(ctx, key, meta)
+	return nil
+}
+
+func (s *secretService) ExtendManagedSecret(ctx context.Context, key, token string, expiration int32) (domain.Metadata, error) {
+	meta, err := s.authorize(ctx, key, token)
+	if err != nil {
+		return domain.Metadata{}, err
+	}
+	if meta.Consumed() {
+		return domain.Metadata{}, domain.ErrNotFound
+	}
+	if !s.isValidExpiration(expiration) {
+		return domain.Metadata{}, ErrInvalidExpiration
+	}
+	expiresAt := s.now().UTC().Truncate(time.Second).Add(time.Duration(expiration) * time.Second)
+	switch {
+	case meta.ExpiresAt.IsZero() || !expiresAt.After(meta.ExpiresAt):
+		return domain.Metadata{}, fmt.Errorf("%w: the secret already expires later", ErrInvalidExpiration)
+	case expiresAt.Sub(meta.CreatedAt) > time.Duration(slices.Max(s.opts.AllowedExpirations))*time.Second:
+		return domain.Metadata{}, fmt.Errorf("%w: the secret would outlive the longest allowed expiration", ErrInvalidExpiration)
+	}
+	if err := s.repo.Extend(ctx, key, expiresAt); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Metadata{}, err
+		}
+		return domain.Metadata{}, fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+	meta.ExpiresAt = expiresAt
+	s.record(ctx, domain.NewEvent(domain.EventExtended, key, meta, s.now()))
+	if meta.NotifyURL != "" && s.opts.Notifier != nil && meta.ViewedAt.IsZero() {
+		s.opts.Notifier.Watch(meta.NotifyURL, domain.NewEvent(domain.EventExpired, key, meta, expiresAt))
 	}
+	return meta, nil
+}
 
-	if !secret.OneTime && s.forceOneTimeSecrets {
-		return "", fmt.Errorf("secret must be one time download")
+func (s *secretService) CreateRequest(ctx context.Context, request domain.Request) (string, string, error) {
+	if !s.isValidExpiration(request.Expiration) {
+		return "", "", ErrInvalidExpiration
+	}
+	if request.PublicKey != "" {
+		if len(request.PublicKey) > s.opts.MaxLength {
+			return "", "", fmt.Errorf("the public key is too long")
+		}
+		if _, err := crypto.ReadPublicKey(request.PublicKey); err != nil {
+			return "", "", err
+		}
 	}
 
-	if len(secret.Message) > s.maxLength {
-		return "", fmt.Errorf("the encrypted message is too long")
+	key, token, err := newKey()
+	if err != nil {
+		return "", "", err
+	}
+	secret := domain.Secret{
+		Expiration: request.Expiration,
+		Message:    request.PublicKey,
+		Kind:       domain.KindRequest,
+		TokenHash:  hashToken(token),
 	}
+	if err := s.repo.Put(ctx, key, secret); err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+	meta := domain.NewMetadata(secret, s.now())
+	s.record(ctx, domain.NewEvent(domain.EventCreated, key, meta, meta.CreatedAt))
+	return key, token, nil
+}
 
+func (s *secretService) GetRequest(ctx context.Context, key string) (domain.Request, error) {
+	meta, err := s.request(ctx, key)
+	if err != nil {
+		return domain.Request{}, err
+	}
+	request := domain.Request{ExpiresAt: meta.ExpiresAt}
+	// Only the public key has to be read, requests without one are
+	// empty. Reading it doesn't count as a view of the request.
+	if meta.Size > 0 {
+		secret, err := s.repo.Peek(ctx, key)
+		if err != nil {
+			return domain.Request{}, err
+		}
+		request.PublicKey = secret.Message
+	}
+	return request, nil
+}
+
+// FulfilRequest deletes the request before the secret is stored, so
+// concurrent senders can't overwrite each other's secret. The secret is
+// one-time and expires when the request would have.
+func (s *secretService) FulfilRequest(ctx context.Context, key string, secret domain.Secret) error {
+	if !s.isPGPEncrypted(secret.Message) {
+		return fmt.Errorf("message must be PGP encrypted")
+	}
+	if err := s.pack(&secret); err != nil {
+		return err
+	}
+	meta, err := s.request(ctx, key)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.repo.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return domain.ErrNotFound
+	}
+	now := s.now()
+	s.record(ctx, domain.NewEvent(domain.EventFulfilled, key, meta, now))
+
+	response := domain.Secret{
+		Message:   secret.Message,
+		OneTime:   true,
+		Kind:      domain.KindMessage,
+		Size:      secret.Size,
+		TokenHash: meta.TokenHash,
+	}
+	if !meta.ExpiresAt.IsZero() {
+		response.Expiration = max(int32(meta.ExpiresAt.Sub(now)/time.Second), 1)
+	}
+	responseKey := requestedKey(key, meta.TokenHash)
+	if err := s.repo.Put(ctx, responseKey, response); err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreFailed, err)
+	}
+	s.record(ctx, domain.NewEvent(domain.EventCreated, responseKey, domain.NewMetadata(response, now), now))
+	return nil
+}
+
+func (s *secretService) GetRequestedSecret(ctx context.Context, key, token string) (domain.Secret, error) {
+	secret, err := s.GetSecret(ctx, requestedKey(key, hashToken(token)))
+	if !errors.Is(err, domain.ErrNotFound) {
+		return secret, err
+	}
+	// Tell the requester whether the secret is still to come.
+	meta, err := s.authorize(ctx, key, token)
+	if err != nil {
+		return domain.Secret{}, err
+	}
+	if meta.Kind == domain.KindRequest {
+		return domain.Secret{}, ErrRequestPending
+	}
+	return domain.Secret{}, domain.ErrNotFound
+}
+
+// request returns the metadata of the request stored under key.
+func (s *secretService) request(ctx context.Context, key string) (domain.Metadata, error) {
+	meta, err := s.repo.Status(ctx, key)
+	if err != nil {
+		return domain.Metadata{}, err
+	}
+	if meta.Kind != domain.KindRequest {
+		return domain.Metadata{}, domain.ErrNotFound
+	}
+	return meta, nil
+}
+
+// requestedKey returns the key the secret fulfilling the request stored
+// under key is stored under. It is derived from the hash of the request
+// token, so only the server and the requester can tell it.
+func requestedKey(key, tokenHash string) string {
+	return uuid.NewV5(uuid.FromStringOrNil(key), tokenHash).String()
+}
+
+// newKey returns a new key to store a secret under and a new management
+// token for it. Only the hash of the token is stored, so the database
+// alone doesn't let anyone manage the secret.
+func newKey() (key, token string, err error) {
 	uuidVal, err := uuid.NewV4()
 	if err != nil {
-		return "", fmt.Errorf("unable to generate UUID: %w", err)
+		return "", "", fmt.Errorf("unable to generate UUID: %w", err)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("unable to generate management token: %w", err)
+	}
+	return uuidVal.String(), base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pack sets the size of secret and stores its message in binary form if
+// enabled. The length limit applies to the binary packets, which is what
+// gets stored, unless the message can't be decoded.
+func (s *secretService) pack(secret *domain.Secret) error {
+	size := len(secret.Message)
+	if m, err := armor.Decode(secret.Message); err == nil {
+		size = len(m.Packets)
+		if s.opts.BinaryPayloads {
+			secret.Message = compact(m, secret.Message)
+		}
+	}
+	if size > s.opts.MaxLength {
+		return fmt.Errorf("the encrypted message is too long")
+	}
+	secret.Size = size
+	return nil
+}
+
+// notify sends event to url. Any event but the expiry itself means the
+// secret didn't expire unread, so its expiry isn't watched any longer.
+func (s *secretService) notify(url string, event domain.Event) {
+	if s.opts.Notifier == nil {
+		return
 	}
-	key := uuidVal.String()
+	s.opts.Notifier.Unwatch(event.Key)
+	s.opts.Notifier.Notify(url, event)
+}
 
-	if err := s.repo.Put(key, secret); err != nil {
-		return "", fmt.Errorf("failed to store secret in database")
+// deleted records deleting the secret described by meta and sends the
+// event. A deleted receipt is only audited, it isn't news to anyone.
+func (s *secretService) deleted(ctx context.Context, key string, meta domain.Metadata) {
+	event := domain.NewEvent(domain.EventDeleted, key, meta, s.now())
+	s.record(ctx, event)
+	if meta.NotifyURL != "" && !meta.Consumed() {
+		s.notify(meta.NotifyURL, event)
 	}
+}
 
-	return key, nil
+// record passes event to the audit log, if there is one.
+func (s *secretService) record(ctx context.Context, event domain.Event) {
+	if s.opts.Auditor != nil {
+		s.opts.Auditor.Record(ctx, event)
+	}
 }
 
-func (s *secretService) GetSecret(key string) (domain.Secret, error) {
-	return s.repo.Get(key)
+// authorize returns the metadata of the secret stored under key if token
+// is its management token.
+func (s *secretService) authorize(ctx context.Context, key, token string) (domain.Metadata, error) {
+	meta, err := s.repo.Status(ctx, key)
+	if err != nil {
+		return domain.Metadata{}, err
+	}
+	if meta.TokenHash == "" || subtle.ConstantTimeCompare([]byte(meta.TokenHash), []byte(hashToken(token))) != 1 {
+		return domain.Metadata{}, ErrInvalidToken
+	}
+	return meta, nil
 }
 
-func (s *secretService) GetSecretStatus(key string) (bool, error) {
-	return s.repo.Status(key)
+// hashToken returns the hash of a management token as stored with the
+// secret. The token is random enough that a plain hash can't be reversed.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func (s *secretService) DeleteSecret(key string) (bool, error) {
-	return s.repo.Delete(key)
+// compact returns the binary form of the decoded message m if it restores
+// exactly the uploaded message and is actually smaller.
+func compact(m *armor.Message, message string) string {
+	if c := m.Compact(); len(c) < len(message) && m.Encode() == message {
+		return c
+	}
+	return message
 }
 
 func (s *secretService) isValidExpiration(expiration int32) bool {
-	for _, ttl := range s.allowedExpirations {
+	for _, ttl := range s.opts.AllowedExpirations {
 		if ttl == expiration {
 			return true
 		}
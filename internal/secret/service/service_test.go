@@ -1,33 +1,96 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Khovanskiy5/yopass/internal/repository"
+	"github.com/Khovanskiy5/yopass/internal/secret/armor"
+	"github.com/Khovanskiy5/yopass/internal/secret/crypto"
 	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/Khovanskiy5/yopass/internal/utils"
+	"github.com/Khovanskiy5/yopass/internal/webhook"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	xarmor "github.com/ProtonMail/go-crypto/openpgp/armor"
 )
 
 type mockRepo struct {
 	putErr error
 	getErr error
 	secret domain.Secret
+	stored domain.Secret
 }
 
-func (m *mockRepo) Get(key string) (domain.Secret, error) {
+func (m *mockRepo) Get(ctx context.Context, key string) (domain.Secret, error) {
 	return m.secret, m.getErr
 }
-func (m *mockRepo) Put(key string, secret domain.Secret) error {
+func (m *mockRepo) Peek(ctx context.Context, key string) (domain.Secret, error) {
+	return m.secret, m.getErr
+}
+func (m *mockRepo) Put(ctx context.Context, key string, secret domain.Secret) error {
+	m.stored = secret
 	return m.putErr
 }
-func (m *mockRepo) Delete(key string) (bool, error) {
+func (m *mockRepo) Delete(ctx context.Context, key string) (bool, error) {
 	return true, nil
 }
-func (m *mockRepo) Status(key string) (bool, error) {
-	return m.secret.OneTime, nil
+func (m *mockRepo) Status(ctx context.Context, key string) (domain.Metadata, error) {
+	return domain.Metadata{OneTime: m.secret.OneTime}, nil
+}
+func (m *mockRepo) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return nil
+}
+func (m *mockRepo) Ping(ctx context.Context) error {
+	return nil
+}
+
+// mockNotifier records the events sent and watched. It only allows URLs
+// starting with allowed.
+type mockNotifier struct {
+	allowed string
+	sent    []domain.Event
+	watched map[string]domain.Event
+}
+
+func (m *mockNotifier) Validate(url string) error {
+	if !strings.HasPrefix(url, m.allowed) {
+		return errors.New("not allowed")
+	}
+	return nil
+}
+func (m *mockNotifier) Notify(url string, event domain.Event) {
+	m.sent = append(m.sent, event)
+}
+func (m *mockNotifier) Watch(url string, event domain.Event) {
+	m.watched[event.Key] = event
+}
+func (m *mockNotifier) Unwatch(key string) {
+	delete(m.watched, key)
+}
+
+// mockAuditor records the events and client IPs it gets.
+type mockAuditor struct {
+	events []domain.Event
+	ips    []string
+}
+
+func (m *mockAuditor) Record(ctx context.Context, event domain.Event) {
+	m.events = append(m.events, event)
+	m.ips = append(m.ips, utils.ClientIP(ctx))
 }
 
 func TestCreateSecret(t *testing.T) {
 	repo := &mockRepo{}
-	svc := NewSecretService(repo, 100, false, []int32{3600, 86400, 604800})
+	svc := NewSecretService(repo, Options{MaxLength: 100, AllowedExpirations: []int32{3600, 86400, 604800}, BinaryPayloads: true, MaxViews: 10})
 
 	tests := []struct {
 		name    string
@@ -70,7 +133,7 @@ func TestCreateSecret(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := svc.CreateSecret(tt.secret)
+			_, _, err := svc.CreateSecret(context.Background(), tt.secret)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateSecret() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -80,37 +143,569 @@ func TestCreateSecret(t *testing.T) {
 
 func TestCustomExpirations(t *testing.T) {
 	repo := &mockRepo{}
-	svc := NewSecretService(repo, 100, false, []int32{60})
+	svc := NewSecretService(repo, Options{MaxLength: 100, AllowedExpirations: []int32{60}, BinaryPayloads: true, MaxViews: 10})
 
 	s := domain.Secret{
 		Message:    "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
 		Expiration: 60,
 	}
-	if _, err := svc.CreateSecret(s); err != nil {
+	if _, _, err := svc.CreateSecret(context.Background(), s); err != nil {
 		t.Errorf("Expected success for 60s expiration, got %v", err)
 	}
 
 	s.Expiration = 3600
-	if _, err := svc.CreateSecret(s); err == nil {
+	if _, _, err := svc.CreateSecret(context.Background(), s); err == nil {
 		t.Error("Expected error for 3600s expiration when only 60s is allowed")
 	}
 }
 
 func TestForceOneTime(t *testing.T) {
 	repo := &mockRepo{}
-	svc := NewSecretService(repo, 100, true, []int32{3600})
+	svc := NewSecretService(repo, Options{MaxLength: 100, ForceOneTimeSecrets: true, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10})
 
 	s := domain.Secret{
 		Message:    "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
 		Expiration: 3600,
 		OneTime:    false,
 	}
-	if _, err := svc.CreateSecret(s); err == nil {
+	if _, _, err := svc.CreateSecret(context.Background(), s); err == nil {
 		t.Error("Expected error when forceOneTimeSecrets is true but secret is not one-time")
 	}
 
 	s.OneTime = true
-	if _, err := svc.CreateSecret(s); err != nil {
+	if _, _, err := svc.CreateSecret(context.Background(), s); err != nil {
 		t.Errorf("Expected success when secret is one-time, got %v", err)
 	}
 }
+
+func TestMaxViews(t *testing.T) {
+	repo := &mockRepo{}
+	svc := NewSecretService(repo, Options{MaxLength: 100, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 5})
+
+	s := domain.Secret{
+		Message:    "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
+		Expiration: 3600,
+		MaxViews:   5,
+	}
+	if _, _, err := svc.CreateSecret(context.Background(), s); err != nil {
+		t.Fatalf("Expected success for 5 views, got %v", err)
+	}
+	if repo.stored.MaxViews != 5 || repo.stored.OneTime {
+		t.Errorf("Expected multi-view secret to be stored, got %+v", repo.stored)
+	}
+
+	s.MaxViews = 1
+	if _, _, err := svc.CreateSecret(context.Background(), s); err != nil {
+		t.Fatalf("Expected success for a single view, got %v", err)
+	}
+	if repo.stored.MaxViews != 0 || !repo.stored.OneTime {
+		t.Errorf("Expected a single view to be stored as one-time secret, got %+v", repo.stored)
+	}
+
+	for _, invalid := range []domain.Secret{
+		{Message: s.Message, Expiration: 3600, MaxViews: 6},
+		{Message: s.Message, Expiration: 3600, MaxViews: -1},
+		{Message: s.Message, Expiration: 3600, MaxViews: 2, OneTime: true},
+	} {
+		if _, _, err := svc.CreateSecret(context.Background(), invalid); err == nil {
+			t.Errorf("Expected error for %d views (one-time %t)", invalid.MaxViews, invalid.OneTime)
+		}
+	}
+
+	svc = NewSecretService(repo, Options{MaxLength: 100, ForceOneTimeSecrets: true, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 5})
+	s.MaxViews = 2
+	if _, _, err := svc.CreateSecret(context.Background(), s); err == nil {
+		t.Error("Expected error for a multi-view secret when forceOneTimeSecrets is true")
+	}
+}
+
+func TestCreateSecretStoreError(t *testing.T) {
+	repo := &mockRepo{putErr: context.DeadlineExceeded}
+	svc := NewSecretService(repo, Options{MaxLength: 100, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10})
+
+	s := domain.Secret{
+		Message:    "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
+		Expiration: 3600,
+	}
+	_, _, err := svc.CreateSecret(context.Background(), s)
+	if !errors.Is(err, ErrStoreFailed) {
+		t.Errorf("Expected ErrStoreFailed, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the repository error to be preserved, got %v", err)
+	}
+}
+
+// armoredMessage returns an ASCII-armored message of size random bytes.
+func armoredMessage(t *testing.T, size int) string {
+	t.Helper()
+	packets := make([]byte, size)
+	rand.Read(packets)
+	var buf bytes.Buffer
+	w, err := xarmor.Encode(&buf, "PGP MESSAGE", map[string]string{"Comment": "https://yopass.se"})
+	if err != nil {
+		t.Fatalf("error in Encode(): %v", err)
+	}
+	w.Write(packets)
+	w.Close()
+	return buf.String()
+}
+
+func TestBinaryPayloads(t *testing.T) {
+	ctx := context.Background()
+	message := armoredMessage(t, 1000)
+
+	repo := &mockRepo{}
+	svc := NewSecretService(repo, Options{MaxLength: 1000, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10})
+	if _, _, err := svc.CreateSecret(ctx, domain.Secret{Message: message, Expiration: 3600}); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if !strings.HasPrefix(repo.stored.Message, armor.Prefix) || len(repo.stored.Message) >= len(message) {
+		t.Fatalf("expected the message to be stored in binary, got %d bytes", len(repo.stored.Message))
+	}
+	if repo.stored.Size != 1000 {
+		t.Fatalf("expected the size of the packets, got %d", repo.stored.Size)
+	}
+
+	repo.secret = repo.stored
+	s, err := svc.GetSecret(ctx, "key")
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if s.Message != message {
+		t.Fatalf("expected the armored message to be restored, got\n%s", s.Message)
+	}
+
+	// Secrets stored armored are returned as they are.
+	repo.secret = domain.Secret{Message: message}
+	if s, err := svc.GetSecret(ctx, "key"); err != nil || s.Message != message {
+		t.Fatalf("expected armored secret unchanged, got %v", err)
+	}
+
+	repo.secret = domain.Secret{Message: armor.Prefix + "\xff"}
+	if _, err := svc.GetSecret(ctx, "key"); !errors.Is(err, armor.ErrMalformed) {
+		t.Fatalf("expected ErrMalformed for a corrupt message, got %v", err)
+	}
+}
+
+func TestBinaryPayloadsDisabled(t *testing.T) {
+	message := armoredMessage(t, 1000)
+	repo := &mockRepo{}
+	svc := NewSecretService(repo, Options{MaxLength: 1000, AllowedExpirations: []int32{3600}, MaxViews: 10})
+	if _, _, err := svc.CreateSecret(context.Background(), domain.Secret{Message: message, Expiration: 3600}); err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if repo.stored.Message != message {
+		t.Fatal("expected the message to be stored armored")
+	}
+}
+
+func TestMaxLengthOfDecodedMessage(t *testing.T) {
+	svc := NewSecretService(&mockRepo{}, Options{MaxLength: 1000, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10})
+
+	// The armored messages are longer than the limit, their packets aren't.
+	if _, _, err := svc.CreateSecret(context.Background(), domain.Secret{Message: armoredMessage(t, 1000), Expiration: 3600}); err != nil {
+		t.Errorf("expected message of max length to be accepted, got %v", err)
+	}
+	if _, _, err := svc.CreateSecret(context.Background(), domain.Secret{Message: armoredMessage(t, 1001), Expiration: 3600}); err == nil {
+		t.Error("expected message over max length to be rejected")
+	}
+}
+
+func TestManagementToken(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemory(0)
+	svc := NewSecretService(repo, Options{MaxLength: 100, AllowedExpirations: []int32{3600, 86400}, BinaryPayloads: true, MaxViews: 10})
+
+	s := domain.Secret{
+		Message:    "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----",
+		Expiration: 3600,
+		OneTime:    true,
+	}
+	key, token, err := svc.CreateSecret(ctx, s)
+	if err != nil || token == "" {
+		t.Fatalf("expected a management token from CreateSecret(), got %q, %v", token, err)
+	}
+	if stored, err := repo.Status(ctx, key); err != nil || stored.TokenHash == "" || strings.Contains(stored.TokenHash, token) {
+		t.Fatalf("expected only the hash of the token to be stored, got %+v, %v", stored, err)
+	}
+
+	if _, err := svc.GetManagedSecret(ctx, key, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for a wrong token, got %v", err)
+	}
+	meta, err := svc.GetManagedSecret(ctx, key, token)
+	if err != nil || !meta.ViewedAt.IsZero() {
+		t.Fatalf("expected unviewed secret, got %+v, %v", meta, err)
+	}
+
+	svc.(*secretService).now = func() time.Time { return meta.CreatedAt }
+	for _, expiration := range []int32{60, 3600} {
+		if _, err := svc.ExtendManagedSecret(ctx, key, token, expiration); !errors.Is(err, ErrInvalidExpiration) {
+			t.Fatalf("expected ErrInvalidExpiration extending by %d, got %v", expiration, err)
+		}
+	}
+	extended, err := svc.ExtendManagedSecret(ctx, key, token, 86400)
+	if err != nil || !extended.ExpiresAt.Equal(meta.CreatedAt.Add(24*time.Hour)) {
+		t.Fatalf("expected secret to be extended by a day, got %+v, %v", extended, err)
+	}
+	// The secret must not outlive the longest expiration.
+	svc.(*secretService).now = func() time.Time { return meta.CreatedAt.Add(time.Hour) }
+	if _, err := svc.ExtendManagedSecret(ctx, key, token, 86400); !errors.Is(err, ErrInvalidExpiration) {
+		t.Fatalf("expected ErrInvalidExpiration beyond the longest expiration, got %v", err)
+	}
+
+	if _, err := svc.GetSecret(ctx, key); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if _, err := svc.GetSecretStatus(ctx, key); err != domain.ErrNotFound {
+		t.Fatalf("expected the receipt to be hidden from GetSecretStatus(), got %v", err)
+	}
+	if meta, err := svc.GetManagedSecret(ctx, key, token); err != nil || meta.ViewedAt.IsZero() {
+		t.Fatalf("expected viewed secret, got %+v, %v", meta, err)
+	}
+	if _, err := svc.ExtendManagedSecret(ctx, key, token, 86400); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound extending a viewed secret, got %v", err)
+	}
+
+	if err := svc.DeleteManagedSecret(ctx, key, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for a wrong token, got %v", err)
+	}
+	if err := svc.DeleteManagedSecret(ctx, key, token); err != nil {
+		t.Fatalf("DeleteManagedSecret() error = %v", err)
+	}
+	if _, err := svc.GetManagedSecret(ctx, key, token); err != domain.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after deleting, got %v", err)
+	}
+
+	// Secrets stored without a token can't be managed.
+	if err := repo.Put(ctx, "legacy", domain.Secret{Message: "foo", Expiration: 3600}); err != nil {
+		t.Fatalf("error in Put(): %v", err)
+	}
+	if _, err := svc.GetManagedSecret(ctx, "legacy", ""); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for a secret without token, got %v", err)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := context.Background()
+	message := "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----"
+	s := domain.Secret{Message: message, Expiration: 3600, MaxViews: 2, NotifyURL: "https://hooks.example.com/yopass"}
+
+	svc := NewSecretService(repository.NewMemory(0), Options{MaxLength: 100, AllowedExpirations: []int32{3600, 86400}, BinaryPayloads: true, MaxViews: 10})
+	if _, _, err := svc.CreateSecret(ctx, s); err == nil {
+		t.Fatal("expected an error for a notification URL with notifications disabled")
+	}
+
+	notifier := &mockNotifier{allowed: "https://hooks.example.com/", watched: map[string]domain.Event{}}
+	svc = NewSecretService(repository.NewMemory(0), Options{MaxLength: 100, AllowedExpirations: []int32{3600, 86400}, BinaryPayloads: true, MaxViews: 10, Notifier: notifier})
+	if _, _, err := svc.CreateSecret(ctx, domain.Secret{Message: message, Expiration: 3600, NotifyURL: "https://evil.example.com/"}); err == nil {
+		t.Fatal("expected an error for a notification URL outside the allowlist")
+	}
+
+	key, token, err := svc.CreateSecret(ctx, s)
+	if err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	meta, err := svc.GetManagedSecret(ctx, key, token)
+	if err != nil {
+		t.Fatalf("GetManagedSecret() error = %v", err)
+	}
+	if e := notifier.watched[key]; e.Type != domain.EventExpired || !e.Time.Equal(meta.ExpiresAt) || e.RemainingViews != 2 {
+		t.Fatalf("expected the expiry to be watched, got %+v", e)
+	}
+
+	svc.(*secretService).now = func() time.Time { return meta.CreatedAt }
+	extended, err := svc.ExtendManagedSecret(ctx, key, token, 86400)
+	if err != nil {
+		t.Fatalf("ExtendManagedSecret() error = %v", err)
+	}
+	if e := notifier.watched[key]; !e.Time.Equal(extended.ExpiresAt) {
+		t.Fatalf("expected the extended expiry to be watched, got %+v", e)
+	}
+
+	got, err := svc.GetSecret(ctx, key)
+	if err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if got.NotifyURL != "" {
+		t.Errorf("expected the notification URL to be hidden from the reader, got %s", got.NotifyURL)
+	}
+	if _, ok := notifier.watched[key]; ok {
+		t.Error("expected a viewed secret not to expire unread")
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("expected a viewed event, got %+v", notifier.sent)
+	}
+	if e := notifier.sent[0]; e.Type != domain.EventViewed || e.Key != key || e.MaxViews != 2 || e.RemainingViews != 1 {
+		t.Errorf("unexpected viewed event %+v", e)
+	}
+
+	if err := svc.DeleteManagedSecret(ctx, key, token); err != nil {
+		t.Fatalf("DeleteManagedSecret() error = %v", err)
+	}
+	if e := notifier.sent[len(notifier.sent)-1]; len(notifier.sent) != 2 || e.Type != domain.EventDeleted || e.Key != key {
+		t.Errorf("expected a deleted event, got %+v", notifier.sent)
+	}
+
+	// A one-time secret is consumed by its view, deleting its receipt is
+	// no news.
+	s.MaxViews, s.OneTime = 0, true
+	key, token, err = svc.CreateSecret(ctx, s)
+	if err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if _, err := svc.GetSecret(ctx, key); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if e := notifier.sent[len(notifier.sent)-1]; e.Type != domain.EventViewed || e.MaxViews != 1 || e.RemainingViews != 0 {
+		t.Errorf("unexpected viewed event %+v", e)
+	}
+	if err := svc.DeleteManagedSecret(ctx, key, token); err != nil {
+		t.Fatalf("DeleteManagedSecret() error = %v", err)
+	}
+	if len(notifier.sent) != 3 {
+		t.Errorf("expected no event for deleting a receipt, got %+v", notifier.sent)
+	}
+
+	key, token, err = svc.CreateSecret(ctx, s)
+	if err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if err := svc.DeleteManagedSecret(ctx, key, token); err != nil {
+		t.Fatalf("DeleteManagedSecret() error = %v", err)
+	}
+	if e := notifier.sent[len(notifier.sent)-1]; e.Type != domain.EventDeleted || e.Key != key {
+		t.Errorf("expected a deleted event, got %+v", e)
+	}
+	if _, ok := notifier.watched[key]; ok {
+		t.Error("expected a deleted secret not to expire unread")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ctx := utils.WithClientIP(context.Background(), "1.2.3.4")
+	message := "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----"
+	auditor := &mockAuditor{}
+	svc := NewSecretService(repository.NewMemory(0), Options{MaxLength: 100, AllowedExpirations: []int32{3600, 86400}, BinaryPayloads: true, MaxViews: 10, Auditor: auditor})
+
+	key, token, err := svc.CreateSecret(ctx, domain.Secret{Message: message, Expiration: 3600, MaxViews: 2})
+	if err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	meta, err := svc.GetManagedSecret(ctx, key, token)
+	if err != nil {
+		t.Fatalf("GetManagedSecret() error = %v", err)
+	}
+	svc.(*secretService).now = func() time.Time { return meta.CreatedAt }
+	extended, err := svc.ExtendManagedSecret(ctx, key, token, 86400)
+	if err != nil {
+		t.Fatalf("ExtendManagedSecret() error = %v", err)
+	}
+	if _, err := svc.GetSecret(ctx, key); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	if _, err := svc.GetSecret(ctx, key); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+	// Deleting the receipt of a consumed secret is audited, too.
+	if err := svc.DeleteManagedSecret(ctx, key, token); err != nil {
+		t.Fatalf("DeleteManagedSecret() error = %v", err)
+	}
+
+	expected := []domain.Event{
+		{Type: domain.EventCreated, ExpiresAt: meta.ExpiresAt, RemainingViews: 2},
+		{Type: domain.EventExtended, ExpiresAt: extended.ExpiresAt, RemainingViews: 2},
+		{Type: domain.EventViewed, RemainingViews: 1},
+		{Type: domain.EventViewed, RemainingViews: 0},
+		{Type: domain.EventDeleted, ExpiresAt: extended.ExpiresAt, RemainingViews: 0},
+	}
+	if len(auditor.events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), auditor.events)
+	}
+	for i, e := range auditor.events {
+		want := expected[i]
+		if e.Type != want.Type || e.Key != key || e.MaxViews != 2 || e.RemainingViews != want.RemainingViews || !e.ExpiresAt.Equal(want.ExpiresAt) {
+			t.Errorf("expected event %+v, got %+v", want, e)
+		}
+		if auditor.ips[i] != "1.2.3.4" {
+			t.Errorf("expected the client IP with %s, got %q", e.Type, auditor.ips[i])
+		}
+	}
+
+	// Failures aren't audited.
+	auditor.events = nil
+	if _, _, err := svc.CreateSecret(ctx, domain.Secret{Message: "plain text", Expiration: 3600}); err == nil {
+		t.Fatal("expected an error for a plain text message")
+	}
+	if err := svc.DeleteManagedSecret(ctx, key, "wrong"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a deleted secret, got %v", err)
+	}
+	if len(auditor.events) != 0 {
+		t.Errorf("expected no events, got %+v", auditor.events)
+	}
+}
+
+// armoredKey returns a new armored OpenPGP key, the private one if private
+// is set.
+func armoredKey(t *testing.T, private bool) string {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Helpdesk", "", "helpdesk@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockType, serialize := openpgp.PublicKeyType, entity.Serialize
+	if private {
+		blockType, serialize = openpgp.PrivateKeyType, func(w io.Writer) error { return entity.SerializePrivate(w, nil) }
+	}
+	var buf bytes.Buffer
+	w, err := xarmor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return buf.String()
+}
+
+func TestRequests(t *testing.T) {
+	ctx := context.Background()
+	message := "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----"
+	auditor := &mockAuditor{}
+	repo := repository.NewMemory(0)
+	svc := NewSecretService(repo, Options{MaxLength: 10000, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10, Auditor: auditor})
+	created := time.Now()
+	svc.(*secretService).now = func() time.Time { return created }
+
+	if _, _, err := svc.CreateRequest(ctx, domain.Request{Expiration: 60}); !errors.Is(err, ErrInvalidExpiration) {
+		t.Errorf("expected ErrInvalidExpiration, got %v", err)
+	}
+	for name, publicKey := range map[string]string{"garbage": "not a key", "private key": armoredKey(t, true)} {
+		if _, _, err := svc.CreateRequest(ctx, domain.Request{Expiration: 3600, PublicKey: publicKey}); !errors.Is(err, crypto.ErrInvalidPublicKey) {
+			t.Errorf("expected ErrInvalidPublicKey for %s, got %v", name, err)
+		}
+	}
+
+	publicKey := armoredKey(t, false)
+	key, token, err := svc.CreateRequest(ctx, domain.Request{Expiration: 3600, PublicKey: publicKey})
+	if err != nil {
+		t.Fatalf("CreateRequest() error = %v", err)
+	}
+	request, err := svc.GetRequest(ctx, key)
+	if err != nil {
+		t.Fatalf("GetRequest() error = %v", err)
+	}
+	if request.PublicKey != publicKey || request.ExpiresAt.IsZero() {
+		t.Errorf("expected the request with its public key and expiry, got %+v", request)
+	}
+	// Opening the request page doesn't count as a view.
+	if _, err := svc.GetRequest(ctx, key); err != nil {
+		t.Fatalf("expected the request to be readable again, got %v", err)
+	}
+	if status, err := repo.Status(ctx, key); err != nil || !status.ViewedAt.IsZero() {
+		t.Errorf("expected the request to be unviewed, got %+v, %v", status, err)
+	}
+
+	// Requests can't be read or prefetched as secrets.
+	if _, err := svc.GetSecret(ctx, key); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected GetSecret() to return ErrNotFound, got %v", err)
+	}
+	if _, err := svc.GetSecretStatus(ctx, key); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected GetSecretStatus() to return ErrNotFound, got %v", err)
+	}
+
+	if _, err := svc.GetRequestedSecret(ctx, key, token); !errors.Is(err, ErrRequestPending) {
+		t.Errorf("expected ErrRequestPending, got %v", err)
+	}
+	if _, err := svc.GetRequestedSecret(ctx, key, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+	if err := svc.FulfilRequest(ctx, key, domain.Secret{Message: "plain text"}); err == nil {
+		t.Error("expected an error for a plain text message")
+	}
+
+	svc.(*secretService).now = func() time.Time { return created.Add(30 * time.Minute) }
+	if err := svc.FulfilRequest(ctx, key, domain.Secret{Message: message, OneTime: false, Expiration: 604800}); err != nil {
+		t.Fatalf("FulfilRequest() error = %v", err)
+	}
+	if err := svc.FulfilRequest(ctx, key, domain.Secret{Message: message}); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected a request to be fulfilled once, got %v", err)
+	}
+	if _, err := svc.GetRequest(ctx, key); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected a fulfilled request to be gone, got %v", err)
+	}
+	if _, err := svc.GetRequestedSecret(ctx, key, "wrong"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a wrong token, got %v", err)
+	}
+	secret, err := svc.GetRequestedSecret(ctx, key, token)
+	if err != nil {
+		t.Fatalf("GetRequestedSecret() error = %v", err)
+	}
+	if secret.Message != message {
+		t.Errorf("expected message %q, got %q", message, secret.Message)
+	}
+	if _, err := svc.GetRequestedSecret(ctx, key, token); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected the requested secret to be one-time, got %v", err)
+	}
+
+	types := []string{domain.EventCreated, domain.EventFulfilled, domain.EventCreated, domain.EventViewed}
+	if len(auditor.events) != len(types) {
+		t.Fatalf("expected %d events, got %+v", len(types), auditor.events)
+	}
+	for i, e := range auditor.events {
+		if e.Type != types[i] {
+			t.Errorf("expected event %d to be %s, got %s", i, types[i], e.Type)
+		}
+	}
+	// The secret expires with the request, not a day after it was sent.
+	if sent := auditor.events[2]; sent.Key == key || request.ExpiresAt.Sub(sent.ExpiresAt).Abs() > time.Second || sent.MaxViews != 1 {
+		t.Errorf("expected the secret to be one-time and expire with the request, got %+v", sent)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify([]byte("secret"), r.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("expected a signed webhook, got %v", err)
+		}
+		received <- body
+	}))
+	defer srv.Close()
+
+	notifier, err := webhook.New(webhook.Options{
+		Allowlist: []string{srv.URL},
+		Secret:    "secret",
+		Path:      filepath.Join(t.TempDir(), "webhooks.db"),
+		Interval:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("webhook.New() error = %v", err)
+	}
+	defer notifier.Close()
+
+	ctx := context.Background()
+	svc := NewSecretService(repository.NewMemory(0), Options{MaxLength: 100, AllowedExpirations: []int32{3600}, MaxViews: 10, Notifier: notifier})
+	message := "-----BEGIN PGP MESSAGE-----\nciphertext\n-----END PGP MESSAGE-----"
+	key, _, err := svc.CreateSecret(ctx, domain.Secret{Message: message, Expiration: 3600, OneTime: true, NotifyURL: srv.URL + "/hook"})
+	if err != nil {
+		t.Fatalf("CreateSecret() error = %v", err)
+	}
+	if _, err := svc.GetSecret(ctx, key); err != nil {
+		t.Fatalf("GetSecret() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if !strings.Contains(string(body), `"event":"secret.viewed"`) || !strings.Contains(string(body), key) {
+			t.Errorf("unexpected webhook %s", body)
+		}
+		if strings.Contains(string(body), "ciphertext") {
+			t.Errorf("expected the webhook not to carry the secret, got %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a webhook for the view")
+	}
+}
@@ -15,11 +15,13 @@ func NewRouter(
 	cfg *config.Config,
 	secretHandler *handler.SecretHandler,
 	configHandler *handler.ConfigHandler,
+	healthHandler *handler.HealthHandler,
 	registry *prometheus.Registry,
 ) http.Handler {
 	mx := mux.NewRouter()
 	mx.Use(middleware.Metrics(registry))
 	mx.Use(middleware.CORS(cfg.CORSAllowOrigin))
+	mx.Use(middleware.ClientIP(cfg.TrustedProxies))
 
 	// Secret routes
 	mx.HandleFunc("/secret", secretHandler.CreateSecret).Methods(http.MethodPost)
@@ -28,12 +30,25 @@ func NewRouter(
 		mx.HandleFunc("/secret/"+constants.KeyParameter+"/status", secretHandler.GetSecretStatus).Methods(http.MethodGet)
 	}
 	mx.HandleFunc("/secret/"+constants.KeyParameter, secretHandler.GetSecret).Methods(http.MethodGet)
-	mx.HandleFunc("/secret/"+constants.KeyParameter, secretHandler.DeleteSecret).Methods(http.MethodDelete)
+	handleManage(mx, "/secret/"+constants.KeyParameter+"/manage", secretHandler)
+
+	// Request routes
+	mx.HandleFunc("/request", secretHandler.CreateRequest).Methods(http.MethodPost)
+	mx.HandleFunc("/request", secretHandler.OptionsSecret).Methods(http.MethodOptions)
+	mx.HandleFunc("/request/"+constants.KeyParameter, secretHandler.GetRequest).Methods(http.MethodGet)
+	mx.HandleFunc("/request/"+constants.KeyParameter, secretHandler.FulfilRequest).Methods(http.MethodPost)
+	mx.HandleFunc("/request/"+constants.KeyParameter, secretHandler.OptionsSecret).Methods(http.MethodOptions)
+	mx.HandleFunc("/request/"+constants.KeyParameter+"/secret", secretHandler.GetRequestedSecret).Methods(http.MethodGet)
+	mx.HandleFunc("/request/"+constants.KeyParameter+"/secret", secretHandler.OptionsSecret).Methods(http.MethodOptions)
 
 	// Config routes
 	mx.HandleFunc("/config", configHandler.GetConfig).Methods(http.MethodGet)
 	mx.HandleFunc("/config", configHandler.OptionsConfig).Methods(http.MethodOptions)
 
+	// Health routes
+	mx.HandleFunc("/healthz", healthHandler.Live).Methods(http.MethodGet)
+	mx.HandleFunc("/readyz", healthHandler.Ready).Methods(http.MethodGet)
+
 	// File routes (if enabled)
 	if !cfg.DisableUpload {
 		mx.HandleFunc("/file", secretHandler.CreateSecret).Methods(http.MethodPost)
@@ -42,12 +57,22 @@ func NewRouter(
 			mx.HandleFunc("/file/"+constants.KeyParameter+"/status", secretHandler.GetSecretStatus).Methods(http.MethodGet)
 		}
 		mx.HandleFunc("/file/"+constants.KeyParameter, secretHandler.GetSecret).Methods(http.MethodGet)
-		mx.HandleFunc("/file/"+constants.KeyParameter, secretHandler.DeleteSecret).Methods(http.MethodDelete)
+		handleManage(mx, "/file/"+constants.KeyParameter+"/manage", secretHandler)
 	}
 
-	// Static files
-	mx.PathPrefix("/").Handler(http.FileServer(http.Dir(cfg.AssetPath)))
+	// Static files. Other methods get 405 on API routes, e.g. DELETE
+	// without the management token.
+	mx.PathPrefix("/").Handler(http.FileServer(http.Dir(cfg.AssetPath))).Methods(http.MethodGet, http.MethodHead)
 
 	// Security headers
 	return middleware.SecurityHeaders(mx)
 }
+
+// handleManage registers the routes that let the creator of a secret manage
+// it with its management token.
+func handleManage(mx *mux.Router, path string, secretHandler *handler.SecretHandler) {
+	mx.HandleFunc(path, secretHandler.GetManagedSecret).Methods(http.MethodGet)
+	mx.HandleFunc(path, secretHandler.ExtendManagedSecret).Methods(http.MethodPatch)
+	mx.HandleFunc(path, secretHandler.DeleteManagedSecret).Methods(http.MethodDelete)
+	mx.HandleFunc(path, secretHandler.OptionsSecret).Methods(http.MethodOptions)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/secret/handler"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
@@ -51,13 +52,15 @@ func (s *Server) Start(handler http.Handler) *http.Server {
 	return srv
 }
 
-func (s *Server) StartMetrics() *http.Server {
+func (s *Server) StartMetrics(health *handler.HealthHandler) *http.Server {
 	if s.cfg.MetricsPort <= 0 {
 		return nil
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	mux.HandleFunc("GET /healthz", health.Live)
+	mux.HandleFunc("GET /readyz", health.Ready)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", s.cfg.Address, s.cfg.MetricsPort),
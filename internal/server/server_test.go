@@ -2,15 +2,181 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Khovanskiy5/yopass/internal/config"
+	"github.com/Khovanskiy5/yopass/internal/repository"
+	"github.com/Khovanskiy5/yopass/internal/secret/handler"
+	"github.com/Khovanskiy5/yopass/internal/secret/service"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestRouterHealthRoutes(t *testing.T) {
+	cfg := &config.Config{AssetPath: t.TempDir()}
+	logger := zap.NewNop()
+	var pingErr error
+	health := handler.NewHealthHandler(pingerFunc(func(context.Context) error { return pingErr }), logger)
+	router := NewRouter(cfg, handler.NewSecretHandler(nil, logger), handler.NewConfigHandler(cfg, logger), health, prometheus.NewRegistry())
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected /readyz to return 200, got %d", code)
+	}
+
+	pingErr = errors.New("database down")
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to stay 200 while the database is down, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 while the database is down, got %d", code)
+	}
+}
+
+func TestRouterManageRoutes(t *testing.T) {
+	cfg := &config.Config{AssetPath: t.TempDir()}
+	logger := zap.NewNop()
+	router := NewRouter(cfg, handler.NewSecretHandler(nil, logger), handler.NewConfigHandler(cfg, logger), nil, prometheus.NewRegistry())
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		for _, prefix := range []string{"/secret/", "/file/"} {
+			path := prefix + "6ba7b810-9dad-11d1-80b4-00c04fd430c8/manage"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected %s %s without token to return 401, got %d", method, path, rec.Code)
+			}
+		}
+	}
+}
+
+func TestRouterDeleteRequiresToken(t *testing.T) {
+	cfg := &config.Config{AssetPath: t.TempDir()}
+	logger := zap.NewNop()
+	svc := service.NewSecretService(repository.NewMemory(0), service.Options{MaxLength: 1000, AllowedExpirations: []int32{3600}, MaxViews: 10})
+	router := NewRouter(cfg, handler.NewSecretHandler(svc, logger), handler.NewConfigHandler(cfg, logger), nil, prometheus.NewRegistry())
+
+	send := func(method, path, token, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, prefix := range []string{"/secret", "/file"} {
+		req := httptest.NewRequest(http.MethodPost, prefix, strings.NewReader(`{"message": "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----", "expiration": 3600}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp map[string]string
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected creating a secret to return 200, got %d: %v", rec.Code, resp)
+		}
+		path := prefix + "/" + resp["message"]
+
+		// Only the creator can delete a secret, with its management token.
+		if code := send(http.MethodDelete, path, "", ""); code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected DELETE %s without token to return 405, got %d", prefix, code)
+		}
+		if code := send(http.MethodDelete, path+"/manage", "", ""); code != http.StatusUnauthorized {
+			t.Errorf("Expected DELETE %s/manage without token to return 401, got %d", prefix, code)
+		}
+		if code := send(http.MethodGet, path+"/manage", resp["token"], ""); code != http.StatusOK {
+			t.Errorf("Expected the secret to be kept, got %d", code)
+		}
+		if code := send(http.MethodDelete, path+"/manage", resp["token"], ""); code != http.StatusNoContent {
+			t.Errorf("Expected DELETE %s/manage with token to return 204, got %d", prefix, code)
+		}
+		if code := send(http.MethodGet, path+"/manage", resp["token"], ""); code != http.StatusNotFound {
+			t.Errorf("Expected the secret to be deleted, got %d", code)
+		}
+	}
+}
+
+func TestRouterRequestRoutes(t *testing.T) {
+	cfg := &config.Config{AssetPath: t.TempDir()}
+	logger := zap.NewNop()
+	svc := service.NewSecretService(repository.NewMemory(0), service.Options{MaxLength: 1000, AllowedExpirations: []int32{3600}, BinaryPayloads: true, MaxViews: 10})
+	router := NewRouter(cfg, handler.NewSecretHandler(svc, logger), handler.NewConfigHandler(cfg, logger), nil, prometheus.NewRegistry())
+
+	send := func(method, path, token, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	code, resp := send(http.MethodPost, "/request", "", `{"expiration": 3600}`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected creating a request to return 200, got %d: %v", code, resp)
+	}
+	key, token := resp["message"].(string), resp["token"].(string)
+	path := "/request/" + key
+
+	if code, resp := send(http.MethodGet, path, "", ""); code != http.StatusOK || resp["publicKey"] != nil || resp["ttl"] == nil {
+		t.Errorf("Expected the request without a public key, got %d: %v", code, resp)
+	}
+	if code, _ := send(http.MethodGet, "/secret/"+key, "", ""); code != http.StatusNotFound {
+		t.Errorf("Expected a request not to be readable as a secret, got %d", code)
+	}
+	if code, _ := send(http.MethodGet, path+"/secret", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected the secret to require the request token, got %d", code)
+	}
+	if code, _ := send(http.MethodGet, path+"/secret", "wrong", ""); code != http.StatusForbidden {
+		t.Errorf("Expected the secret to require the right token, got %d", code)
+	}
+	if code, resp := send(http.MethodGet, path+"/secret", token, ""); code != http.StatusNotFound || resp["message"] != "Request not fulfilled yet" {
+		t.Errorf("Expected the secret to be pending, got %d: %v", code, resp)
+	}
+
+	message := `{"message": "-----BEGIN PGP MESSAGE-----\n...\n-----END PGP MESSAGE-----"}`
+	if code, resp := send(http.MethodPost, path, "", message); code != http.StatusNoContent {
+		t.Fatalf("Expected fulfilling the request to return 204, got %d: %v", code, resp)
+	}
+	if code, _ := send(http.MethodPost, path, "", message); code != http.StatusNotFound {
+		t.Errorf("Expected a request to be fulfilled once, got %d", code)
+	}
+	if code, _ := send(http.MethodGet, path, "", ""); code != http.StatusNotFound {
+		t.Errorf("Expected a fulfilled request to be gone, got %d", code)
+	}
+	if code, resp := send(http.MethodGet, path+"/secret", token, ""); code != http.StatusOK || !strings.HasPrefix(resp["message"].(string), "-----BEGIN PGP MESSAGE-----") {
+		t.Errorf("Expected the requested secret, got %d: %v", code, resp)
+	}
+	if code, _ := send(http.MethodGet, path+"/secret", token, ""); code != http.StatusNotFound {
+		t.Errorf("Expected the requested secret to be one-time, got %d", code)
+	}
+}
+
 func TestNewServer(t *testing.T) {
 	cfg := &config.Config{Address: "127.0.0.1", Port: 1337}
 	logger := zap.NewNop()
@@ -54,13 +220,26 @@ func TestMetricsServerStartShutdown(t *testing.T) {
 	reg := prometheus.NewRegistry()
 	s := NewServer(cfg, logger, reg)
 
-	srv := s.StartMetrics()
+	srv := s.StartMetrics(handler.NewHealthHandler(pingerFunc(func(context.Context) error {
+		return errors.New("database down")
+	}), logger))
 	if srv == nil {
 		t.Fatal("Expected metrics server to be created")
 	}
 
 	time.Sleep(100 * time.Millisecond)
 
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		resp, err := http.Get("http://127.0.0.1:11337" + path)
+		if err != nil {
+			t.Fatalf("Failed to request %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected status %d for %s, got %d", want, path, resp.StatusCode)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	s.Shutdown(ctx, srv)
@@ -69,7 +248,7 @@ func TestMetricsServerStartShutdown(t *testing.T) {
 func TestMetricsServerDisabled(t *testing.T) {
 	cfg := &config.Config{MetricsPort: -1}
 	s := NewServer(cfg, zap.NewNop(), prometheus.NewRegistry())
-	srv := s.StartMetrics()
+	srv := s.StartMetrics(nil)
 	if srv != nil {
 		t.Error("Metrics server should be disabled for MetricsPort <= 0")
 	}
@@ -1,11 +1,27 @@
 package utils
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
 )
 
+// clientIPKey is the context key of the client IP.
+type clientIPKey struct{}
+
+// WithClientIP returns ctx carrying the IP of the client the request is
+// handled for.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the client IP carried by ctx, or "" if there is none.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
 // GetRealClientIP returns the real client IP address by checking X-Forwarded-For
 // header only if the request comes from a trusted proxy, otherwise returns RemoteAddr
 func GetRealClientIP(req *http.Request, trustedProxies []string) string {
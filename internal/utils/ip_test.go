@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"net/http"
 	"testing"
 )
@@ -70,3 +71,13 @@ func TestGetRealClientIP(t *testing.T) {
 		})
 	}
 }
+
+func TestClientIPContext(t *testing.T) {
+	ctx := context.Background()
+	if ip := ClientIP(ctx); ip != "" {
+		t.Errorf("expected no client IP, got %s", ip)
+	}
+	if ip := ClientIP(WithClientIP(ctx, "1.2.3.4")); ip != "1.2.3.4" {
+		t.Errorf("expected client IP 1.2.3.4, got %s", ip)
+	}
+}
@@ -50,3 +50,31 @@ func ParseURL(s string) (id, key string, fileOpt, keyOpt bool, err error) {
 	}
 	return id, key, fileOpt, keyOpt, nil
 }
+
+// RequestURL returns a URL which sends a secret for the specified request
+// from the browser. Requests for a secret encrypted to a public key have no
+// key.
+func RequestURL(baseURL, id, key string) string {
+	path := id
+	if key != "" {
+		path += "/" + key
+	}
+	return fmt.Sprintf("%s/#/r/%s", strings.TrimSuffix(baseURL, "/"), path)
+}
+
+// ParseRequestURL returns request ID and key from a request URL.
+func ParseRequestURL(s string) (id, key string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	f := strings.Split(u.Fragment, "/")
+	if len(f) < 3 || len(f) > 4 || f[0] != "" || f[1] != "r" || f[2] == "" {
+		return "", "", fmt.Errorf("unexpected request URL: %q", s)
+	}
+	if len(f) == 4 {
+		key = f[3]
+	}
+	return f[2], key, nil
+}
@@ -124,3 +124,40 @@ func TestParseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestURL(t *testing.T) {
+	if got, want := RequestURL("https://yopass.se/", "uuid", "key"), "https://yopass.se/#/r/uuid/key"; got != want {
+		t.Errorf("RequestURL() = %v, want %v", got, want)
+	}
+	if got, want := RequestURL("https://yopass.se", "uuid", ""), "https://yopass.se/#/r/uuid"; got != want {
+		t.Errorf("RequestURL() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRequestURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantId  string
+		wantKey string
+		wantErr bool
+	}{
+		{name: "Request URL", url: "https://yopass.se/#/r/uuid/key", wantId: "uuid", wantKey: "key"},
+		{name: "Public key request URL", url: "https://yopass.se/#/r/uuid", wantId: "uuid"},
+		{name: "Secret URL", url: "https://yopass.se/#/s/uuid/key", wantErr: true},
+		{name: "Missing ID", url: "https://yopass.se/#/r/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, key, err := ParseRequestURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRequestURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if id != tt.wantId || key != tt.wantKey {
+				t.Errorf("ParseRequestURL() = %v, %v; want %v, %v", id, key, tt.wantId, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxURLLength bounds the length of a notification URL.
+const maxURLLength = 2048
+
+// ErrURLNotAllowed is returned by Validate for notification URLs outside
+// the allowlist.
+var ErrURLNotAllowed = errors.New("notification URL not allowed")
+
+// allowlist holds the URL prefixes notifications may be sent to.
+type allowlist []*url.URL
+
+func parseAllowlist(entries []string) (allowlist, error) {
+	var list allowlist
+	for _, entry := range entries {
+		u, err := parseURL(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook allowlist entry %q: %w", entry, err)
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// allows reports whether u starts with one of the prefixes. Scheme and host
+// have to be equal, and the path has to be equal or below the prefix path.
+func (l allowlist) allows(u *url.URL) bool {
+	for _, prefix := range l {
+		if u.Scheme != prefix.Scheme || !strings.EqualFold(u.Host, prefix.Host) {
+			continue
+		}
+		dir := strings.TrimSuffix(prefix.Path, "/")
+		if u.Path == prefix.Path || strings.HasPrefix(u.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseURL parses an absolute HTTP(S) URL. URLs with credentials or dot
+// segments, which could sneak past a prefix, are rejected.
+func parseURL(raw string) (*url.URL, error) {
+	if len(raw) > maxURLLength {
+		return nil, errors.New("URL too long")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, errors.New("not an absolute http or https URL")
+	}
+	if u.User != nil {
+		return nil, errors.New("URL must not contain credentials")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if clean := path.Clean(u.Path); clean != strings.TrimSuffix(u.Path, "/") && clean != u.Path {
+		return nil, errors.New("URL path must not contain dot segments")
+	}
+	return u, nil
+}
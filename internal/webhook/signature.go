@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a webhook request
+const (
+	// SignatureHeader carries the time the request was signed at and the
+	// HMAC-SHA256 of that time and the body, see Sign.
+	SignatureHeader = "X-Yopass-Signature"
+	// EventHeader carries the type of the event, e.g. secret.viewed.
+	EventHeader = "X-Yopass-Event"
+	// DeliveryHeader identifies a delivery. It stays the same when the
+	// delivery is retried, so receivers can drop duplicates.
+	DeliveryHeader = "X-Yopass-Delivery"
+)
+
+// ErrInvalidSignature is returned by Verify for requests not signed with
+// the secret, or signed too long ago.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature header of body sent at t. It has the form
+// t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">, so a
+// receiver can reject replays of old requests.
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks the signature header of body against secret. Signatures
+// made more than tolerance before or after now are rejected.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: no timestamp", ErrInvalidSignature)
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: timestamp out of tolerance", ErrInvalidSignature)
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
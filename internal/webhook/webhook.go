@@ -0,0 +1,492 @@
+// Package webhook delivers the events of secrets to the notification URLs
+// their creators asked for.
+//
+// Events are queued in a bbolt database on local disk before they are
+// sent, so deliveries survive restarts. Failed deliveries are retried with
+// exponential backoff, which means a receiver may get an event more than
+// once; the delivery header tells duplicates apart. Every request is
+// signed with the shared webhook secret, see Sign and Verify.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/internal/secret/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// maxBackoff caps the pause between two attempts of a delivery.
+const maxBackoff = time.Hour
+
+// Defaults of the Options left zero
+const (
+	defaultRetryBackoff = 30 * time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultInterval     = 5 * time.Second
+)
+
+var (
+	// bucketDeliveries maps big-endian delivery IDs to their JSON encoded
+	// delivery, so they are sent in the order they were queued.
+	bucketDeliveries = []byte("deliveries")
+	// bucketWatches maps secret keys to the JSON encoded watch of their
+	// expiry.
+	bucketWatches = []byte("watches")
+)
+
+// Options configures a Notifier.
+type Options struct {
+	// Allowlist holds the URL prefixes notifications may be sent to.
+	Allowlist []string
+	// Secret is the key the requests are signed with.
+	Secret string
+	// Path is the file the queue is kept in.
+	Path string
+	// MaxAttempts is how often a delivery is tried before it is dropped.
+	MaxAttempts int
+	// RetryBackoff is the pause after the first failed attempt. It doubles
+	// with every further attempt, up to an hour. It defaults to 30s.
+	RetryBackoff time.Duration
+	// Timeout bounds a single request. It defaults to 10s.
+	Timeout time.Duration
+	// Interval is how often the queue is checked for due deliveries and
+	// expired secrets. It defaults to 5s.
+	Interval time.Duration
+	// Repository is asked whether a secret was viewed or deleted, possibly
+	// through another server, in the last check before it expires. Without
+	// one only Unwatch keeps the expiry of a secret from being notified.
+	Repository domain.Repository
+	Logger     *zap.Logger
+	Registerer prometheus.Registerer
+}
+
+// delivery is an event queued for sending.
+type delivery struct {
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts,omitempty"`
+	Due      time.Time       `json:"due"`
+}
+
+// watch is an event queued once the secret it is about expires.
+type watch struct {
+	URL   string       `json:"url"`
+	Event domain.Event `json:"event"`
+}
+
+// Notifier is a domain.Notifier sending events as signed webhook requests.
+type Notifier struct {
+	opts       Options
+	allowlist  allowlist
+	db         *bolt.DB
+	client     *http.Client
+	now        func() time.Time
+	deliveries *prometheus.CounterVec
+
+	wake      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New opens, or creates, the queue at opts.Path and starts delivering its
+// events until Close is called.
+func New(opts Options) (*Notifier, error) {
+	if opts.Secret == "" {
+		return nil, errors.New("no webhook secret specified")
+	}
+	list, err := parseAllowlist(opts.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook queue directory: %w", err)
+	}
+	db, err := bolt.Open(opts.Path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook queue: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketDeliveries, bucketWatches} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize webhook queue: %w", err)
+	}
+
+	n := &Notifier{
+		opts:      opts,
+		allowlist: list,
+		db:        db,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			// A redirect could lead anywhere, outside the allowlist too.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now: time.Now,
+		deliveries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "yopass_webhook_deliveries_total",
+				Help: "Total number of webhook delivery attempts by result.",
+			},
+			[]string{"result"},
+		),
+		wake: make(chan struct{}, 1),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	if opts.Registerer != nil {
+		opts.Registerer.MustRegister(n.deliveries)
+	}
+
+	n.wg.Add(1)
+	go n.run()
+	return n, nil
+}
+
+// Validate returns ErrURLNotAllowed unless raw is below an allowlist entry.
+func (n *Notifier) Validate(raw string) error {
+	u, err := parseURL(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrURLNotAllowed, err)
+	}
+	if !n.allowlist.allows(u) {
+		return ErrURLNotAllowed
+	}
+	return nil
+}
+
+// Notify queues event for delivery to target right away. Failures to queue it
+// are logged, the event is lost then.
+func (n *Notifier) Notify(target string, event domain.Event) {
+	err := n.db.Update(func(tx *bolt.Tx) error {
+		return n.enqueue(tx, target, event)
+	})
+	if err != nil {
+		n.opts.Logger.Error("Failed to queue webhook", zap.String("event", event.Type), zap.Error(err))
+		return
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Watch queues event for delivery to target once event.Time has passed. Like
+// with Notify, failures are logged.
+func (n *Notifier) Watch(target string, event domain.Event) {
+	data, err := json.Marshal(watch{URL: target, Event: event})
+	if err == nil {
+		err = n.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketWatches).Put([]byte(event.Key), data)
+		})
+	}
+	if err != nil {
+		n.opts.Logger.Error("Failed to queue webhook", zap.String("event", event.Type), zap.Error(err))
+	}
+}
+
+// Unwatch drops the event watched for key, if there is one.
+func (n *Notifier) Unwatch(key string) {
+	err := n.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWatches).Delete([]byte(key))
+	})
+	if err != nil {
+		n.opts.Logger.Error("Failed to drop webhook", zap.Error(err))
+	}
+}
+
+// Close stops the deliveries, cancelling a request in flight, and closes
+// the queue. Undelivered events are sent once a Notifier is opened on the
+// queue again.
+func (n *Notifier) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		n.cancel()
+		n.wg.Wait()
+		err = n.db.Close()
+	})
+	return err
+}
+
+func (n *Notifier) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.opts.Interval)
+	defer ticker.Stop()
+	for {
+		n.process()
+		select {
+		case <-ticker.C:
+		case <-n.wake:
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// process queues the events of expired watches and sends all deliveries
+// that are due.
+func (n *Notifier) process() {
+	now := n.now()
+	if err := n.expire(now); err != nil {
+		n.opts.Logger.Error("Failed to queue webhooks of expired secrets", zap.Error(err))
+	}
+
+	type pending struct {
+		id []byte
+		d  delivery
+	}
+	var due []pending
+	err := n.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDeliveries).ForEach(func(id, v []byte) error {
+			var d delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				n.opts.Logger.Error("Dropping corrupt webhook", zap.Error(err))
+				due = append(due, pending{id: bytes.Clone(id)})
+				return nil
+			}
+			if !d.Due.After(now) {
+				due = append(due, pending{id: bytes.Clone(id), d: d})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		n.opts.Logger.Error("Failed to read webhook queue", zap.Error(err))
+		return
+	}
+
+	for _, p := range due {
+		if n.ctx.Err() != nil {
+			return
+		}
+		if !n.attempt(p.id, p.d) {
+			continue
+		}
+		err := n.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketDeliveries).Delete(p.id)
+		})
+		if err != nil {
+			n.opts.Logger.Error("Failed to remove webhook from queue", zap.Error(err))
+		}
+	}
+}
+
+// attempt sends the delivery d queued as id and reports whether it is done
+// with, i.e. delivered or dropped after its last attempt. Corrupt
+// deliveries without a URL are dropped right away.
+func (n *Notifier) attempt(id []byte, d delivery) bool {
+	if d.URL == "" {
+		return true
+	}
+	err := n.send(id, d)
+	switch {
+	case err == nil:
+		n.deliveries.WithLabelValues("delivered").Inc()
+		return true
+	case n.ctx.Err() != nil:
+		// Shutting down, it is sent again after the restart.
+		return false
+	case d.Attempts+1 >= n.opts.MaxAttempts:
+		n.deliveries.WithLabelValues("dropped").Inc()
+		n.opts.Logger.Error("Webhook delivery failed, dropping it", zap.String("event", d.Event),
+			zap.String("host", host(d.URL)), zap.Int("attempts", d.Attempts+1), zap.Error(err))
+		return true
+	}
+
+	n.deliveries.WithLabelValues("failed").Inc()
+	d.Attempts++
+	d.Due = n.now().Add(n.backoff(d.Attempts))
+	n.opts.Logger.Warn("Webhook delivery failed, retrying", zap.String("event", d.Event),
+		zap.String("host", host(d.URL)), zap.Time("due", d.Due), zap.Error(err))
+	data, err := json.Marshal(d)
+	if err == nil {
+		err = n.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketDeliveries).Put(id, data)
+		})
+	}
+	if err != nil {
+		n.opts.Logger.Error("Failed to reschedule webhook", zap.Error(err))
+	}
+	return false
+}
+
+// expire moves the events of the watches due at now to the deliveries.
+// The watches are scanned in a read transaction first, so there is no
+// write to sync unless a secret has expired.
+//
+// An expired secret can't be told apart from a consumed one anymore, so
+// the watches due before the next check are checked against the repository
+// now instead, and dropped if their secret was viewed or deleted in the
+// meantime. A view in the last interval before the expiry goes unnoticed
+// unless it was through this server.
+func (n *Notifier) expire(now time.Time) error {
+	var expired, checked [][]byte
+	err := n.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWatches).ForEach(func(key, v []byte) error {
+			var w watch
+			switch err := json.Unmarshal(v, &w); {
+			case err != nil, !w.Event.Time.After(now):
+				expired = append(expired, bytes.Clone(key))
+			case n.opts.Repository != nil && !w.Event.Time.After(now.Add(n.opts.Interval)):
+				checked = append(checked, bytes.Clone(key))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	var dropped [][]byte
+	for _, key := range checked {
+		if n.settled(string(key)) {
+			dropped = append(dropped, key)
+		}
+	}
+	if len(expired) == 0 && len(dropped) == 0 {
+		return nil
+	}
+	return n.db.Update(func(tx *bolt.Tx) error {
+		watches := tx.Bucket(bucketWatches)
+		for _, key := range expired {
+			var w watch
+			v := watches.Get(key)
+			if v == nil {
+				// Unwatched in the meantime.
+				continue
+			}
+			if err := json.Unmarshal(v, &w); err == nil {
+				if err := n.enqueue(tx, w.URL, w.Event); err != nil {
+					return err
+				}
+			}
+			if err := watches.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, key := range dropped {
+			if err := watches.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// settled reports whether the secret stored under key was viewed or is
+// gone before its expiry. Errors are logged and count as neither, so the
+// expiry is still notified.
+func (n *Notifier) settled(key string) bool {
+	ctx, cancel := context.WithTimeout(n.ctx, n.opts.Timeout)
+	defer cancel()
+	meta, err := n.opts.Repository.Status(ctx, key)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return true
+	case err != nil:
+		n.opts.Logger.Warn("Failed to check secret before its expiry", zap.Error(err))
+		return false
+	}
+	return !meta.ViewedAt.IsZero()
+}
+
+// enqueue adds the delivery of event to target to the queue, due right
+// away.
+func (n *Notifier) enqueue(tx *bolt.Tx, target string, event domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(delivery{URL: target, Event: event.Type, Body: body, Due: n.now()})
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket(bucketDeliveries)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	return b.Put(binary.BigEndian.AppendUint64(nil, seq), data)
+}
+
+// send posts the signed delivery. Any status but 2xx is a failure.
+func (n *Notifier) send(id []byte, d delivery) error {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yopass-webhook")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(binary.BigEndian.Uint64(id), 10))
+	req.Header.Set(SignatureHeader, Sign([]byte(n.opts.Secret), n.now(), d.Body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// backoff returns the pause after the given number of failed attempts.
+func (n *Notifier) backoff(attempts int) time.Duration {
+	d := n.opts.RetryBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// host returns the host of a notification URL for logging. The full URL
+// may carry a token of the receiver.
+func host(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
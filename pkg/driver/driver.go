@@ -0,0 +1,98 @@
+// Package driver is the registry of database backends selectable with
+// --database.
+//
+// A backend registers itself from an init function, so adding one to the
+// server only takes a blank import of its package, which may live in a
+// module of its own:
+//
+//	func init() {
+//		driver.Register(driver.Driver{
+//			Name: "example",
+//			Flags: func(fs *pflag.FlagSet) {
+//				fs.String("example-url", "", "example database URL")
+//			},
+//			Open: func(s driver.Settings, opts driver.Options) (secret.Repository, error) {
+//				return NewExample(s.GetString("example-url"))
+//			},
+//		})
+//	}
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/pkg/secret"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// Settings gives access to configuration values by flag name. Values can
+// come from flags, environment variables or their defaults.
+type Settings interface {
+	GetString(key string) string
+	GetBool(key string) bool
+	GetInt(key string) int
+	GetDuration(key string) time.Duration
+	GetStringSlice(key string) []string
+}
+
+// Options carries the server facilities a driver may use.
+type Options struct {
+	Logger     *zap.Logger
+	Registerer prometheus.Registerer
+}
+
+// Driver describes a database backend.
+type Driver struct {
+	// Name selects the driver with --database.
+	Name string
+	// Flags registers the configuration flags of the driver, if it has
+	// any. Flag names should start with the driver name to avoid clashes.
+	Flags func(fs *pflag.FlagSet)
+	// Open connects to the backend configured by s.
+	Open func(s Settings, opts Options) (secret.Repository, error)
+}
+
+var (
+	mu      sync.RWMutex
+	drivers = map[string]Driver{}
+)
+
+// Register makes a driver available by its name. Like registering a
+// database/sql driver, it panics if the driver has no name or Open function,
+// or if its name is already taken.
+func Register(d Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+	if d.Name == "" || d.Open == nil {
+		panic("driver: Register driver without name or Open")
+	}
+	if _, dup := drivers[d.Name]; dup {
+		panic(fmt.Sprintf("driver: Register called twice for driver %s", d.Name))
+	}
+	drivers[d.Name] = d
+}
+
+// Lookup returns the driver registered as name.
+func Lookup(name string) (Driver, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := drivers[name]
+	return d, ok
+}
+
+// Drivers returns all registered drivers sorted by name.
+func Drivers() []Driver {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Driver, 0, len(drivers))
+	for _, d := range drivers {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
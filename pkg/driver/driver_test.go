@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/Khovanskiy5/yopass/pkg/secret"
+)
+
+func openNothing(s Settings, opts Options) (secret.Repository, error) {
+	return nil, nil
+}
+
+// isolateDrivers gives the test an empty registry.
+func isolateDrivers(t *testing.T) {
+	mu.Lock()
+	saved := drivers
+	drivers = map[string]Driver{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		drivers = saved
+		mu.Unlock()
+	})
+}
+
+func TestRegister(t *testing.T) {
+	isolateDrivers(t)
+	Register(Driver{Name: "test-b", Open: openNothing})
+	Register(Driver{Name: "test-a", Open: openNothing})
+
+	if _, ok := Lookup("test-a"); !ok {
+		t.Fatal("expected registered driver to be found")
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Fatal("expected unknown driver not to be found")
+	}
+
+	var names []string
+	for _, d := range Drivers() {
+		names = append(names, d.Name)
+	}
+	if len(names) != 2 || names[0] != "test-a" || names[1] != "test-b" {
+		t.Fatalf("expected drivers sorted by name, got %v", names)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	isolateDrivers(t)
+	for name, d := range map[string]Driver{
+		"duplicate": {Name: "test-duplicate", Open: openNothing},
+		"no name":   {Open: openNothing},
+		"no open":   {Name: "test-no-open"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if name == "duplicate" {
+				Register(d)
+			}
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected Register to panic")
+				}
+			}()
+			Register(d)
+		})
+	}
+}
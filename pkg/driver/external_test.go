@@ -0,0 +1,128 @@
+package driver_test
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Khovanskiy5/yopass/pkg/driver"
+	"github.com/Khovanskiy5/yopass/pkg/secret"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// mapRepository is a database driver written against the public packages
+// only, as one in a module of its own would be.
+type mapRepository struct {
+	mu      sync.Mutex
+	prefix  string
+	secrets map[string]secret.Secret
+}
+
+func (m *mapRepository) Get(ctx context.Context, key string) (secret.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.secrets[m.prefix+key]
+	if !ok {
+		return secret.Secret{}, secret.ErrNotFound
+	}
+	if s.OneTime {
+		delete(m.secrets, m.prefix+key)
+	}
+	return s, nil
+}
+
+func (m *mapRepository) Peek(ctx context.Context, key string) (secret.Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.secrets[m.prefix+key]
+	if !ok {
+		return secret.Secret{}, secret.ErrNotFound
+	}
+	return s, nil
+}
+
+func (m *mapRepository) Put(ctx context.Context, key string, s secret.Secret) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secrets[m.prefix+key] = s
+	return nil
+}
+
+func (m *mapRepository) Delete(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.secrets[m.prefix+key]
+	delete(m.secrets, m.prefix+key)
+	return ok, nil
+}
+
+func (m *mapRepository) Status(ctx context.Context, key string) (secret.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.secrets[m.prefix+key]
+	if !ok {
+		return secret.Metadata{}, secret.ErrNotFound
+	}
+	return secret.NewMetadata(s, time.Now()), nil
+}
+
+func (m *mapRepository) Extend(ctx context.Context, key string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *mapRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func ExampleRegister() {
+	driver.Register(driver.Driver{
+		Name: "example",
+		Flags: func(fs *pflag.FlagSet) {
+			fs.String("example-prefix", "yopass:", "prefix of the example database keys")
+		},
+		Open: func(s driver.Settings, opts driver.Options) (secret.Repository, error) {
+			return &mapRepository{prefix: s.GetString("example-prefix"), secrets: map[string]secret.Secret{}}, nil
+		},
+	})
+
+	// The server looks the driver up by the --database flag.
+	d, _ := driver.Lookup("example")
+	fs := pflag.NewFlagSet("yopass-server", pflag.ContinueOnError)
+	d.Flags(fs)
+	settings := viper.New()
+	_ = settings.BindPFlags(fs)
+
+	repo, _ := d.Open(settings, driver.Options{})
+	ctx := context.Background()
+	_ = repo.Put(ctx, "key", secret.Secret{Message: "foo", OneTime: true})
+	s, _ := repo.Get(ctx, "key")
+	_, err := repo.Get(ctx, "key")
+	fmt.Println(s.Message, err)
+	// Output: foo secret not found
+}
+
+// TestPublicDependencies checks that the packages drivers are written
+// against don't depend on internal packages, which modules other than this
+// one can't import.
+func TestPublicDependencies(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	out, err := exec.Command("go", "list", "-deps", "-f", "{{.ImportPath}}",
+		"github.com/Khovanskiy5/yopass/pkg/driver",
+		"github.com/Khovanskiy5/yopass/pkg/secret",
+	).Output()
+	if err != nil {
+		t.Fatalf("error in go list: %v", err)
+	}
+	for _, pkg := range strings.Fields(string(out)) {
+		if strings.HasPrefix(pkg, "github.com/Khovanskiy5/yopass/internal/") {
+			t.Errorf("expected public packages not to depend on %s", pkg)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Package secret defines the secrets stored by Yopass and the Repository
+// interface that database drivers implement.
+package secret
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a secret is not found in the repository
+var ErrNotFound = errors.New("secret not found")
+
+// ErrUnavailable is returned when the repository refuses requests because
+// its backend is known to be unhealthy
+var ErrUnavailable = errors.New("database unavailable")
+
+// Repository interface for secret storage
+type Repository interface {
+	// Get returns the secret for the given key and counts the view
+	Get(ctx context.Context, key string) (Secret, error)
+	// Peek returns the secret for the given key like Get, but without
+	// counting a view or recording its time
+	Peek(ctx context.Context, key string) (Secret, error)
+	// Put stores the secret for the given key
+	Put(ctx context.Context, key string, secret Secret) error
+	// Delete removes the secret for the given key
+	Delete(ctx context.Context, key string) (bool, error)
+	// Status returns the metadata of the secret for the given key without
+	// consuming it. Secrets whose last view has been read are reported for
+	// as long as their receipt is kept.
+	Status(ctx context.Context, key string) (Metadata, error)
+	// Extend moves the expiry of the secret for the given key to expiresAt
+	Extend(ctx context.Context, key string, expiresAt time.Time) error
+	// Ping checks that the backend is reachable
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,136 @@
+package secret
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of secrets, telling text messages from uploaded files and requests
+// for a secret
+const (
+	KindMessage = "message"
+	KindFile    = "file"
+	KindRequest = "request"
+)
+
+// Secret holds the encrypted message
+type Secret struct {
+	Expiration int32  `json:"expiration,omitempty"`
+	Message    string `json:"message"`
+	OneTime    bool   `json:"one_time,omitempty"`
+	// MaxViews is how often a multi-view secret can be read before it is
+	// removed. Zero means no limit, one-time secrets have OneTime set
+	// instead.
+	MaxViews int `json:"max_views,omitempty"`
+	// RemainingViews is how often a multi-view secret can still be read,
+	// returned along with it. It is zero after the last view.
+	RemainingViews int `json:"remaining_views,omitempty"`
+	// Kind is set by the server from the endpoint the secret was
+	// uploaded to
+	Kind string `json:"-"`
+	// Size is the size of the encrypted message without ASCII armor, set
+	// by the server. Zero means the length of Message.
+	Size int `json:"-"`
+	// TokenHash is the hash of the management token handed to the creator
+	// of the secret, set by the server
+	TokenHash string `json:"-"`
+	// NotifyURL receives a webhook when the secret is viewed, deleted or
+	// expires unread. It is never returned to readers of the secret.
+	NotifyURL string `json:"notify_url,omitempty"`
+}
+
+// ToJSON converts a Secret to json
+func (s *Secret) ToJSON() ([]byte, error) {
+	return json.Marshal(&s)
+}
+
+// Metadata describes a stored secret without its encrypted message, so it
+// can be looked up without transferring the message. Secrets stored by
+// earlier versions may lack the size and timestamps, which are zero then.
+// The view counts are only set for multi-view secrets.
+type Metadata struct {
+	OneTime        bool      `json:"one_time,omitempty"`
+	MaxViews       int       `json:"max_views,omitempty"`
+	RemainingViews int       `json:"remaining_views,omitempty"`
+	Size           int       `json:"size"`
+	Kind           string    `json:"kind,omitempty"`
+	CreatedAt      time.Time `json:"created_at,omitzero"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+	TokenHash      string    `json:"token_hash,omitempty"`
+	// ViewedAt is when the secret was read first
+	ViewedAt  time.Time `json:"viewed_at,omitzero"`
+	NotifyURL string    `json:"notify_url,omitempty"`
+}
+
+// NewMetadata returns the metadata of secret stored at now
+func NewMetadata(secret Secret, now time.Time) Metadata {
+	m := Metadata{
+		OneTime:   secret.OneTime,
+		Size:      secret.Size,
+		Kind:      secret.Kind,
+		CreatedAt: now.UTC().Truncate(time.Second),
+		TokenHash: secret.TokenHash,
+		NotifyURL: secret.NotifyURL,
+	}
+	if m.Size == 0 {
+		m.Size = len(secret.Message)
+	}
+	if m.Kind == "" {
+		m.Kind = KindMessage
+	}
+	if secret.MaxViews > 1 && !secret.OneTime {
+		m.MaxViews = secret.MaxViews
+		m.RemainingViews = secret.MaxViews
+	}
+	if secret.Expiration > 0 {
+		m.ExpiresAt = m.CreatedAt.Add(time.Duration(secret.Expiration) * time.Second)
+	}
+	return m
+}
+
+// Secret returns the secret described by m holding message
+func (m Metadata) Secret(message string) Secret {
+	s := Secret{
+		Message:        message,
+		OneTime:        m.OneTime,
+		MaxViews:       m.MaxViews,
+		RemainingViews: m.RemainingViews,
+		Kind:           m.Kind,
+		Size:           m.Size,
+		NotifyURL:      m.NotifyURL,
+	}
+	if !m.ExpiresAt.IsZero() {
+		s.Expiration = int32(m.ExpiresAt.Sub(m.CreatedAt) / time.Second)
+	}
+	return s
+}
+
+// Viewed returns m after the secret has been read once more at now and
+// whether that was its last view, so its message has to be removed.
+func (m Metadata) Viewed(now time.Time) (Metadata, bool) {
+	if m.ViewedAt.IsZero() {
+		m.ViewedAt = now.UTC().Truncate(time.Second)
+	}
+	if m.OneTime {
+		return m, true
+	}
+	if m.RemainingViews == 0 {
+		return m, false
+	}
+	m.RemainingViews--
+	return m, m.RemainingViews == 0
+}
+
+// Consumed reports whether the last view of the secret has been read.
+// Only a receipt of it may be left then.
+func (m Metadata) Consumed() bool {
+	return m.OneTime && !m.ViewedAt.IsZero() || m.MaxViews > 0 && m.RemainingViews == 0
+}
+
+// KeepReceipt reports whether the metadata of the secret is kept after its
+// last view until the secret would have expired, so its creator can tell
+// when it was read. Only secrets with a management token and an expiry
+// keep one.
+func (m Metadata) KeepReceipt() bool {
+	return m.TokenHash != "" && !m.ExpiresAt.IsZero()
+}
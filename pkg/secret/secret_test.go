@@ -0,0 +1,119 @@
+package secret
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSecretToJSON(t *testing.T) {
+	s := Secret{
+		Expiration: 3600,
+		Message:    "test message",
+		OneTime:    true,
+	}
+	
+	got, err := s.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	
+	var decoded Secret
+	if err := json.Unmarshal(got, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	
+	if decoded.Expiration != s.Expiration || decoded.Message != s.Message || decoded.OneTime != s.OneTime {
+		t.Errorf("Decoded secret %v does not match original %v", decoded, s)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	s := Secret{Expiration: 3600, Message: "test message", OneTime: true, NotifyURL: "https://hooks.example.com/yopass"}
+
+	m := NewMetadata(s, now)
+	if m.Size != len(s.Message) || !m.OneTime || m.Kind != KindMessage {
+		t.Errorf("Unexpected metadata %+v", m)
+	}
+	if want := now.Truncate(time.Second).Add(time.Hour); !m.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %v, got %v", want, m.ExpiresAt)
+	}
+
+	got := m.Secret(s.Message)
+	if got.Expiration != s.Expiration || got.Message != s.Message || !got.OneTime || got.Kind != KindMessage ||
+		got.NotifyURL != s.NotifyURL {
+		t.Errorf("Secret %+v does not match original %+v", got, s)
+	}
+
+	if m := NewMetadata(Secret{Message: "compact", Size: 3}, now); m.Size != 3 {
+		t.Errorf("Expected size set by the server, got %d", m.Size)
+	}
+
+	data, err := json.Marshal(NewMetadata(Secret{Message: "x", Kind: KindFile}, now))
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if _, ok := decoded["expires_at"]; ok {
+		t.Errorf("Expected no expiry for a secret without expiration, got %s", data)
+	}
+	if decoded["kind"] != KindFile {
+		t.Errorf("Expected kind file, got %s", data)
+	}
+}
+
+func TestMetadataViewed(t *testing.T) {
+	now := time.Now()
+	m := NewMetadata(Secret{Message: "x", MaxViews: 3}, now)
+	if m.MaxViews != 3 || m.RemainingViews != 3 {
+		t.Fatalf("Expected three views, got %+v", m)
+	}
+	for _, want := range []int{2, 1, 0} {
+		var last bool
+		m, last = m.Viewed(now)
+		if m.RemainingViews != want || last != (want == 0) {
+			t.Fatalf("Expected %d remaining views, got %d (last %t)", want, m.RemainingViews, last)
+		}
+	}
+
+	if m := NewMetadata(Secret{Message: "x", OneTime: true, MaxViews: 3}, now); m.MaxViews != 0 {
+		t.Errorf("Expected no view counts for a one-time secret, got %+v", m)
+	}
+	if !m.Consumed() || !m.ViewedAt.Equal(now.UTC().Truncate(time.Second)) {
+		t.Errorf("Expected secret viewed at %v to be consumed, got %+v", now, m)
+	}
+	oneTime := NewMetadata(Secret{Message: "x", OneTime: true}, now)
+	if oneTime.Consumed() {
+		t.Error("Expected unread one-time secret not to be consumed")
+	}
+	if oneTime, last := oneTime.Viewed(now); !last || !oneTime.Consumed() {
+		t.Error("Expected the first view of a one-time secret to be its last")
+	}
+	if m, last := NewMetadata(Secret{Message: "x"}, now).Viewed(now); last || m.RemainingViews != 0 || m.Consumed() || m.ViewedAt.IsZero() {
+		t.Errorf("Expected unlimited views, got %+v (last %t)", m, last)
+	}
+	if s := m.Secret("x"); s.MaxViews != 3 || s.RemainingViews != 0 {
+		t.Errorf("Expected view counts in secret, got %+v", s)
+	}
+}
+
+func TestMetadataKeepReceipt(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		secret Secret
+		want   bool
+	}{
+		{Secret{Message: "x", Expiration: 3600, TokenHash: "hash"}, true},
+		{Secret{Message: "x", Expiration: 3600}, false},
+		{Secret{Message: "x", TokenHash: "hash"}, false},
+	}
+	for _, tt := range tests {
+		if got := NewMetadata(tt.secret, now).KeepReceipt(); got != tt.want {
+			t.Errorf("KeepReceipt() of %+v = %t, want %t", tt.secret, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import { useConfig } from '@shared/hooks/useConfig';
 import Navbar from '@shared/components/Navbar';
 import Prefetcher from '@features/display-secret/Prefetcher';
 import Upload from '@features/Upload';
+import FulfilRequest from '@features/FulfilRequest';
 import { useTranslation } from 'react-i18next';
 
 export default function App() {
@@ -24,6 +25,8 @@ export default function App() {
                 {!DISABLE_UPLOAD && (
                   <Route path="/upload" element={<Upload />} />
                 )}
+                <Route path="/r/:key/:password" element={<FulfilRequest />} />
+                <Route path="/r/:key" element={<FulfilRequest />} />
                 <Route
                   path="/:format/:key/:password"
                   element={<Prefetcher />}
@@ -0,0 +1,102 @@
+import { useState, type FormEvent } from 'react';
+import { useParams } from 'react-router-dom';
+import { useTranslation } from 'react-i18next';
+import { useAsync } from 'react-use';
+import { encryptMessage, encryptMessageToKey } from '@shared/lib/crypto';
+import { fulfilRequest, getRequest } from '@shared/lib/api';
+import ErrorPage from '@features/display-secret/ErrorPage';
+
+// FulfilRequest encrypts a secret asked for by a request link in the
+// browser, to the requester's public key or with the key from the link.
+export default function FulfilRequest() {
+  const { t } = useTranslation();
+  const { key, password } = useParams();
+  const [secret, setSecret] = useState('');
+  const [error, setError] = useState('');
+  const [sending, setSending] = useState(false);
+  const [sent, setSent] = useState(false);
+
+  const request = useAsync(async () => getRequest(key ?? ''), [key]);
+
+  if (request.loading) {
+    return <div>{t('display.loading')}</div>;
+  }
+  if (request.error || !request.value) {
+    return <ErrorPage />;
+  }
+  const publicKey = request.value.publicKey;
+  if (!publicKey && !password) {
+    return <ErrorPage />;
+  }
+
+  async function onSubmit(e: FormEvent) {
+    e.preventDefault();
+    if (!secret) {
+      setError(t('create.errorSecretRequired'));
+      return;
+    }
+    setSending(true);
+    try {
+      const encrypted = publicKey
+        ? await encryptMessageToKey(secret, publicKey)
+        : await encryptMessage(secret, password ?? '');
+      const { data, status } = await fulfilRequest(key ?? '', encrypted);
+      if (status !== 204) {
+        setError(data.message);
+        return;
+      }
+      setSecret('');
+      setSent(true);
+    } catch (e) {
+      setError((e as Error).message);
+    } finally {
+      setSending(false);
+    }
+  }
+
+  if (sent) {
+    return (
+      <>
+        <h2 className="text-3xl font-bold mb-4">{t('request.titleSent')}</h2>
+        <p className="text-base-content/70 text-lg">
+          {t('request.subtitleSent')}
+        </p>
+      </>
+    );
+  }
+
+  return (
+    <>
+      <h2 className="text-3xl font-bold mb-4">{t('request.title')}</h2>
+      <p className="mb-8 text-base-content/70 text-lg">
+        {publicKey ? t('request.subtitlePublicKey') : t('request.subtitle')}
+      </p>
+      <form onSubmit={onSubmit}>
+        {error && (
+          <div className="mb-4 text-red-600 text-sm font-medium">{error}</div>
+        )}
+        <div className="form-control">
+          <label className="label">
+            <span className="label-text">{t('create.inputSecretLabel')}</span>
+          </label>
+          <textarea
+            className="textarea textarea-bordered w-full min-h-[100px] text-base p-4 resize-y focus:outline-none focus:border-primary focus:ring-1 focus:ring-primary bg-base-100"
+            value={secret}
+            onChange={e => setSecret(e.target.value)}
+            placeholder={t('create.inputSecretPlaceholder')}
+            rows={4}
+          />
+        </div>
+        <div className="form-control mt-8">
+          <button
+            className="btn btn-primary w-full h-14 text-lg font-semibold shadow-lg hover:shadow-xl transition-all duration-200"
+            type="submit"
+            disabled={!secret || sending}
+          >
+            {t('request.buttonSend')}
+          </button>
+        </div>
+      </form>
+    </>
+  );
+}
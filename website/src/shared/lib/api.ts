@@ -32,3 +32,36 @@ export async function postSecret(body: SecretBody): Promise<ApiResponse> {
 export async function uploadFile(body: SecretBody): Promise<ApiResponse> {
   return post(backendDomain + '/file', body);
 }
+
+export interface SecretRequest {
+  publicKey?: string;
+  expiresAt?: string;
+}
+
+export async function getRequest(key: string): Promise<SecretRequest> {
+  const request = await fetch(`${backendDomain}/request/${key}`);
+  if (!request.ok) {
+    throw new Error('Failed to fetch request');
+  }
+  return request.json();
+}
+
+// fulfilRequest sends the secret asked for by a request. Success has no
+// content, so only failures carry a message.
+export async function fulfilRequest(
+  key: string,
+  message: string,
+): Promise<ApiResponse> {
+  try {
+    const request = await fetch(`${backendDomain}/request/${key}`, {
+      body: JSON.stringify({ message }),
+      method: 'POST',
+    });
+    if (request.ok) {
+      return { data: { message: '' }, status: request.status };
+    }
+    return { data: await request.json(), status: request.status };
+  } catch (error) {
+    return { data: { message: error as string }, status: 500 };
+  }
+}
@@ -3,6 +3,7 @@ import {
   decrypt,
   readMessage,
   createMessage,
+  readKey,
   type DecryptMessageResult,
 } from 'openpgp';
 
@@ -25,3 +26,10 @@ export async function encryptMessage(data: string, passwords: string) {
     encryptionKeys: [], // ensures symmetric encryption if only passwords are provided
   });
 }
+
+export async function encryptMessageToKey(data: string, armoredKey: string) {
+  return encrypt({
+    message: await createMessage({ text: data }),
+    encryptionKeys: await readKey({ armoredKey }),
+  });
+}
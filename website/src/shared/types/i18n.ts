@@ -122,4 +122,12 @@ export interface TranslationResources {
     imprint: string;
     createdBy: string;
   };
+  request: {
+    title: string;
+    subtitle: string;
+    subtitlePublicKey: string;
+    buttonSend: string;
+    titleSent: string;
+    subtitleSent: string;
+  };
 }